@@ -157,13 +157,14 @@ var (
 			if err != nil {
 				logger.Sugar().Fatalf("config.yaml is not exists; %v", err)
 			}
+			logger.Init(logOptions(cmd))
 
 			if port := v1.Env.GetInt("server.port"); port > 0 {
 				cArgs.Port = port
 			}
 
-			internal.Initialized()
 			if cArgs.MView {
+				internal.Initialized()
 				println("模型可用列表:")
 				var hasModel = false
 				for model := range v1.Models() {
@@ -197,6 +198,63 @@ func Execute() {
 	}
 }
 
+// 日志配置, 命令行参数优先
+//
+//	logger:
+//	  format: json          # console | json
+//	  path: logger
+//	  level: info
+//	  stdout: true
+//	  rotation: { max-size: 10, max-backups: 5, max-age: 28, compress: true }
+//	  levels:
+//	    internal/v1: debug
+//	    github.com/chromedp: error
+//	  redact:
+//	    enabled: true
+//	    fields: [ "x-custom-token" ]
+//	    patterns: [ "(secret=)\\w+" ]
+func logOptions(cmd *cobra.Command) logger.Options {
+	opts := logger.DefaultOptions()
+	opts.Path = cArgs.LogPath
+	opts.Level = LogLevel(cArgs.LogLevel)
+
+	env := v1.Env
+	if path := env.GetString("logger.path"); path != "" && !cmd.Flags().Changed("logger-path") {
+		opts.Path = path
+	}
+	if level := env.GetString("logger.level"); level != "" && !cmd.Flags().Changed("logger") {
+		opts.Level = LogLevel(level)
+	}
+	if format := env.GetString("logger.format"); format != "" {
+		opts.Format = format
+	}
+	if env.IsSet("logger.stdout") {
+		opts.Stdout = env.GetBool("logger.stdout")
+	}
+	if n := env.GetInt("logger.rotation.max-size"); n > 0 {
+		opts.MaxSize = n
+	}
+	if n := env.GetInt("logger.rotation.max-backups"); n > 0 {
+		opts.MaxBackups = n
+	}
+	if n := env.GetInt("logger.rotation.max-age"); n > 0 {
+		opts.MaxAge = n
+	}
+	opts.Compress = env.GetBool("logger.rotation.compress")
+
+	opts.Redact = logger.RedactOptions{
+		Disabled: env.IsSet("logger.redact.enabled") && !env.GetBool("logger.redact.enabled"),
+		Fields:   env.GetStringSlice("logger.redact.fields"),
+		Patterns: env.GetStringSlice("logger.redact.patterns"),
+	}
+
+	opts.Levels = make(map[string]logger.Level)
+	for pkg, level := range env.GetStringMapString("logger.levels") {
+		opts.Levels[pkg] = LogLevel(level)
+	}
+	return opts
+}
+
 func LogLevel(lv string) logger.Level {
 	switch lv {
 	case "debug":
@@ -17,6 +17,7 @@ import (
 	"github.com/bincooo/ago/internal/chromium/plugins"
 	v1 "github.com/bincooo/ago/internal/v1"
 	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
 	"github.com/chromedp/chromedp"
 )
 
@@ -52,13 +53,8 @@ func switchPlugin(expr string) []byte {
 
 func InitChromiumRemote(ctx context.Context, urlstr string) (context.Context, context.CancelFunc) {
 	chromiumCtx, _ := chromedp.NewRemoteAllocator(context.Background(), urlstr)
-	ctx, cancel := chromedp.NewContext(
-		chromiumCtx,
-		chromedp.WithLogf(logger.Sugar().Infof),
-		chromedp.WithDebugf(logger.Sugar().Debugf),
-		chromedp.WithErrorf(logger.Sugar().Errorf),
-	)
-	return ctx, cancel
+	ctx, cancel := chromedp.NewContext(chromiumCtx, contextOptions()...)
+	return ctx, tracked(cancel)
 }
 
 func InitChromium(ctx context.Context, proxies, userAgent, userDir string, plugins ...string) (context.Context, context.CancelFunc) {
@@ -157,14 +153,26 @@ func InitChromium(ctx context.Context, proxies, userAgent, userDir string, plugi
 	opts = append(chromedp.DefaultExecAllocatorOptions[:], opts...)
 	chromiumCtx, _ := chromedp.NewExecAllocator(ctx, opts...)
 
-	ctx, cancel := chromedp.NewContext(
-		chromiumCtx,
-		chromedp.WithLogf(logger.Sugar().Infof),
-		chromedp.WithDebugf(logger.Sugar().Debugf),
-		chromedp.WithErrorf(logger.Sugar().Errorf),
-	)
+	ctx, cancel := chromedp.NewContext(chromiumCtx, contextOptions()...)
+	return ctx, tracked(cancel)
+}
 
-	return ctx, cancel
+// chromedp 日志: CDP 协议消息含 cookie、请求头与页面数据, 写出前脱敏
+func contextOptions() []chromedp.ContextOption {
+	return []chromedp.ContextOption{
+		chromedp.WithLogf(logger.Redactf(logger.Sugar().Infof)),
+		chromedp.WithDebugf(logger.Redactf(logger.Sugar().Debugf)),
+		chromedp.WithErrorf(logger.Redactf(logger.Sugar().Errorf)),
+	}
+}
+
+// 关闭时同步浏览器实例计数
+func tracked(cancel context.CancelFunc) context.CancelFunc {
+	release := model.TrackBrowser()
+	return func() {
+		cancel()
+		release()
+	}
 }
 
 func InitExtensions(plugins ...string) []chromedp.ExecAllocatorOption {
@@ -3,28 +3,60 @@ package internal
 import (
 	"os"
 	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
 var (
+	mu    sync.Mutex
 	inits = make([]func(), 0)
 	exits = make([]func(), 0)
+
+	// 初始化回调已全部执行
+	ready atomic.Bool
 )
 
-func AddInitialized(apply func()) { inits = append(inits, apply) }
-func AddExited(apply func())      { exits = append(exits, apply) }
-func Initialized() {
-	for _, yield := range inits {
-		yield()
-	}
+func AddInitialized(apply func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	inits = append(inits, apply)
+}
+
+func AddExited(apply func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	exits = append(exits, apply)
+}
+
+// 初始化回调是否已全部执行完毕
+func Ready() bool { return ready.Load() }
 
+// 执行初始化回调, 随后执行依赖回调注册内容 (适配器、凭证等) 的 prepared, 全部完成后就绪
+func Initialized(prepared ...func()) {
 	osSignal := make(chan os.Signal, 1)
 	signal.Notify(osSignal, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	go func(ch chan os.Signal) {
 		<-ch
-		for _, yield := range exits {
+		for _, yield := range callbacks(&exits) {
 			yield()
 		}
 		os.Exit(0)
 	}(osSignal)
+
+	for _, yield := range callbacks(&inits) {
+		yield()
+	}
+	for _, yield := range prepared {
+		yield()
+	}
+	ready.Store(true)
+}
+
+// 回调列表的快照, 执行期间可继续注册
+func callbacks(list *[]func()) []func() {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(*list)
 }
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestInitialized(t *testing.T) {
+	var order []string
+	AddInitialized(func() {
+		if Ready() {
+			t.Error("should not be ready while running callbacks")
+		}
+		order = append(order, "callback")
+	})
+
+	// 依赖回调注册内容的初始化在回调之后执行, 完成后才就绪
+	Initialized(func() {
+		if Ready() {
+			t.Error("should not be ready while preparing")
+		}
+		order = append(order, "prepared")
+	})
+
+	if !Ready() {
+		t.Fatal("should be ready")
+	}
+	if !slices.Equal(order, []string{"callback", "prepared"}) {
+		t.Fatalf("order = %v", order)
+	}
+}
@@ -0,0 +1,107 @@
+package v1
+
+import (
+	"crypto/subtle"
+	"time"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	localPlayground = "ago.playground"
+)
+
+// 管理接口, 需配置 admin.key
+func adminRoutes(app *fiber.App) {
+	admin := app.Group("/admin", adminAuth)
+
+	admin.Get("/dashboard", dashboard)
+	admin.Post("/playground", playground, rateLimit, enforceBudget, completions)
+	admin.Get("/adapters", listAdapters)
+	admin.Get("/adapters/:adapter", getAdapter)
+	admin.Delete("/adapters/:adapter", deleteAdapter)
+	admin.Post("/adapters/:adapter/enable", enableAdapter)
+	admin.Post("/adapters/:adapter/disable", disableAdapter)
+	admin.Post("/adapters/:adapter/models", addAdapterModels)
+	admin.Delete("/adapters/:adapter/models", removeAdapterModels)
+	admin.Post("/adapters/:adapter/credentials", addAdapterCredentials)
+	admin.Delete("/adapters/:adapter/credentials/:id", removeAdapterCredential)
+	admin.Get("/credentials", listCredentials)
+	admin.Post("/credentials/:pool", addCredentials)
+	admin.Delete("/credentials/:pool/:id", removeCredential)
+	admin.Post("/credentials/:pool/:id/reset", resetCredential)
+	admin.Post("/prompt-rules/reload", reloadPrompts)
+	admin.Get("/traces", listTraces)
+	admin.Get("/audit", queryAudit)
+	admin.Get("/usage", listUsage)
+	admin.Get("/usage/export", exportUsage)
+	admin.Get("/budgets", listBudgets)
+
+	admin.Get("/log-level", getLogLevel)
+	admin.Put("/log-level", setLogLevel)
+	admin.Get("/debug/captures", listCaptures)
+	admin.Post("/debug/captures", createCapture)
+	admin.Get("/debug/captures/:id", downloadCapture)
+	admin.Delete("/debug/captures/:id", deleteCapture)
+}
+
+// 管理密钥校验
+func adminAuth(ctx *fiber.Ctx) error {
+	if Env == nil || Env.GetString("admin.key") == "" {
+		return writeErrorf(ctx, fiber.StatusForbidden, "invalid_request_error", "admin_disabled",
+			"Admin API is disabled, configure `admin.key` to enable it.")
+	}
+
+	if !isAdmin(ctx) {
+		return writeErrorf(ctx, fiber.StatusUnauthorized, "invalid_request_error", "invalid_api_key",
+			"Incorrect admin key provided.")
+	}
+	return ctx.Next()
+}
+
+// 请求是否携带管理密钥
+func isAdmin(ctx *fiber.Ctx) bool {
+	key := ""
+	if Env != nil {
+		key = Env.GetString("admin.key")
+	}
+	token := model.ExtractToken(ctx)
+	return key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
+
+// 调试台: 管理密钥不转发到上游, 请求计入 playground-key 对应的客户端密钥,
+// 与普通请求一样经过配额、限流与预算校验
+//
+//	admin:
+//	  key: admin-xxx
+//	  playground-key: sk-xxx        # 开启鉴权时必须配置
+//	  playground-upstream: ""       # 转发到上游的凭证, 密钥配置了 upstream 时以其为准
+func playground(ctx *fiber.Ctx) error {
+	ctx.Locals(localPlayground, true)
+	ctx.Request().Header.Del("X-Api-Key")
+	ctx.Request().Header.Del(fiber.HeaderAuthorization)
+	if upstream := Env.GetString("admin.playground-upstream"); upstream != "" {
+		ctx.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+upstream)
+	}
+
+	if !authEnabled() {
+		return ctx.Next()
+	}
+
+	key := keys.lookup(Env.GetString("admin.playground-key"))
+	if key == nil {
+		return writeErrorf(ctx, fiber.StatusForbidden, "invalid_request_error", "playground_disabled",
+			"Playground is disabled, configure `admin.playground-key` with a valid API key to enable it.")
+	}
+	return admit(ctx, key)
+}
+
+// 立即重新加载提示词规则
+func reloadPrompts(ctx *fiber.Ctx) error {
+	prompts.mu.Lock()
+	prompts.checked = time.Time{}
+	prompts.modTime = time.Time{}
+	prompts.mu.Unlock()
+	return ctx.JSON(fiber.Map{"rules": len(prompts.current())})
+}
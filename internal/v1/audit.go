@@ -0,0 +1,499 @@
+package v1
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	localAudit = "ago.audit"
+	auditDay   = "2006-01-02"
+)
+
+var (
+	auditLog     *auditStore
+	auditLogOnce sync.Once
+)
+
+// 审计记录
+type auditRecord struct {
+	Id        string              `json:"id"`
+	RequestId string              `json:"request_id"`
+	Time      time.Time           `json:"time"`
+	Type      string              `json:"type"`
+	Path      string              `json:"path"`
+	Client    string              `json:"client,omitempty"`
+	Model     string              `json:"model"`
+	Adapter   string              `json:"adapter,omitempty"`
+	Stream    bool                `json:"stream"`
+	Status    int                 `json:"status"`
+	Latency   int64               `json:"latency_ms"`
+	Usage     model.ResponseUsage `json:"usage,omitempty"`
+	Request   interface{}         `json:"request,omitempty"`
+	Output    interface{}         `json:"output,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// 单个请求的审计状态
+type auditTrail struct {
+	mu sync.Mutex
+
+	c      *model.Ctx
+	record auditRecord
+	start  time.Time
+	agg    model.Aggregator
+	output interface{}
+	done   bool
+}
+
+// 审计日志: 按客户端密钥记录请求元数据、请求体、聚合后的输出、用量、耗时与错误;
+// 按天追加写入 JSONL 文件, 内存中保留索引用于查询
+//
+//	audit:
+//	  enabled: true
+//	  dir: audit
+//	  retention: 720h      # 超出时长的文件被删除
+//	  max-bytes: 1073741824 # 超出总大小时删除最早的文件
+//	  body: true           # 记录请求体与输出, 为 false 时仅记录元数据
+func auditEnabled() bool {
+	return Env != nil && Env.GetBool("audit.enabled")
+}
+
+func audits() *auditStore {
+	auditLogOnce.Do(func() {
+		dir := Env.GetString("audit.dir")
+		if dir == "" {
+			dir = "audit"
+		}
+		retention := Env.GetDuration("audit.retention")
+		if retention <= 0 {
+			retention = 30 * 24 * time.Hour
+		}
+		auditLog = newAuditStore(dir, retention, Env.GetInt64("audit.max-bytes"))
+	})
+	return auditLog
+}
+
+// 开始审计请求, 流式响应在结束信号时写入
+func audited(c *model.Ctx, mod string) {
+	if !auditEnabled() {
+		return
+	}
+
+	trail := &auditTrail{
+		c:     c,
+		start: time.Now(),
+		record: auditRecord{
+			Id:        uuid.NewString(),
+			RequestId: requestIdOf(c.Ctx()),
+			Type:      c.Type,
+			Path:      strings.Clone(c.Ctx().Path()),
+			Model:     mod,
+		},
+	}
+	if key, ok := c.Ctx().Locals(localKey).(*apiKey); ok {
+		trail.record.Client = key.account()
+	}
+	c.Ctx().Locals(localAudit, trail)
+
+	c.InterceptAt(model.StageCapture, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		trail.add(msg)
+		if msg == model.Flush {
+			defer trail.finish(fiber.StatusOK, "")
+		}
+		return next(msg)
+	})
+}
+
+// 非流式响应在处理函数返回后写入
+func audit(ctx *fiber.Ctx) (err error) {
+	defer func() {
+		trail, ok := ctx.Locals(localAudit).(*auditTrail)
+		if !ok || ctx.Response().IsBodyStream() {
+			return
+		}
+
+		status, message := ctx.Response().StatusCode(), ""
+		if err != nil {
+			status, message = fiber.StatusInternalServerError, err.Error()
+			var e *fiber.Error
+			if errors.As(err, &e) {
+				status = e.Code
+			}
+		} else if status >= fiber.StatusBadRequest {
+			message = string(ctx.Response().Body())
+		}
+		trail.finish(status, message)
+	}()
+	return ctx.Next()
+}
+
+// 记录分发的适配器
+func auditDispatched(c *model.Ctx, entry registered) {
+	if trail, ok := c.Ctx().Locals(localAudit).(*auditTrail); ok {
+		trail.mu.Lock()
+		trail.record.Adapter = entry.Id
+		trail.mu.Unlock()
+	}
+}
+
+func (trail *auditTrail) add(msg interface{}) {
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+	trail.agg.Add(msg)
+
+	switch v := msg.(type) {
+	case error:
+		if v != io.EOF {
+			trail.record.Error = v.Error()
+		}
+	case *model.Response, model.Response:
+	default:
+		if msg != model.Flush && trail.c.Type != "relay" {
+			trail.output = snapshotOf(msg)
+		}
+	}
+}
+
+func (trail *auditTrail) finish(status int, message string) {
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+	if trail.done {
+		return
+	}
+	trail.done = true
+
+	record := trail.record
+	record.Time = trail.start
+	record.Stream = trail.c.Streaming()
+	record.Status = status
+	record.Latency = time.Since(trail.start).Milliseconds()
+	record.Usage = trail.agg.Usage
+	if message != "" && record.Error == "" {
+		record.Error = message
+	}
+
+	if enforced("audit.body") {
+		for _, key := range []string{"completion", "embedding", "generation"} {
+			if value := trail.c.Get(key); value != nil {
+				record.Request = value
+				break
+			}
+		}
+
+		if trail.c.Type == "relay" && (trail.agg.Done || trail.agg.Content.Len() > 0 || len(trail.agg.ToolCalls) > 0) {
+			record.Output = trail.agg.Response()
+		} else if trail.output != nil {
+			record.Output = trail.output
+		}
+	}
+	audits().write(&record)
+}
+
+// 审计日志文件中一条记录的位置
+type auditEntry struct {
+	time   time.Time
+	client string
+	model  string
+	status int
+	file   string
+	offset int64
+	size   int
+}
+
+// 按天追加写入的审计日志, 内存中保留按时间排序的索引
+type auditStore struct {
+	mu sync.RWMutex
+
+	dir       string
+	retention time.Duration
+	maxBytes  int64
+
+	index []auditEntry
+	sizes map[string]int64
+
+	day  string
+	file *os.File
+}
+
+func newAuditStore(dir string, retention time.Duration, maxBytes int64) *auditStore {
+	store := &auditStore{
+		dir:       dir,
+		retention: retention,
+		maxBytes:  maxBytes,
+		sizes:     make(map[string]int64),
+	}
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		logger.Sugar().Errorf("audit: create dir failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	sort.Strings(files)
+	for _, file := range files {
+		if err := store.load(file); err != nil {
+			logger.Sugar().Errorf("audit: load %s failed: %v", file, err)
+		}
+	}
+	store.prune(time.Now())
+	return store
+}
+
+// 读取文件建立索引
+func (store *auditStore) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	name := filepath.Base(file)
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var header struct {
+				Time   time.Time `json:"time"`
+				Client string    `json:"client"`
+				Model  string    `json:"model"`
+				Status int       `json:"status"`
+			}
+			if json.Unmarshal(line, &header) == nil {
+				store.index = append(store.index, auditEntry{
+					time: header.Time, client: header.Client, model: header.Model, status: header.Status,
+					file: name, offset: offset, size: len(line) - 1,
+				})
+			}
+			offset += int64(len(line))
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	store.sizes[name] = offset
+	if info, err := f.Stat(); err == nil {
+		store.sizes[name] = info.Size()
+	}
+	sort.SliceStable(store.index, func(i, j int) bool { return store.index[i].time.Before(store.index[j].time) })
+	return nil
+}
+
+func (store *auditStore) write(record *auditRecord) {
+	chunk, err := json.Marshal(record)
+	if err != nil {
+		logger.Sugar().Errorf("audit: marshal failed: %v", err)
+		return
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	day := record.Time.Format(auditDay)
+	if store.file == nil || store.day != day {
+		if store.file != nil {
+			_ = store.file.Close()
+		}
+		store.file, err = os.OpenFile(filepath.Join(store.dir, day+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			store.file = nil
+			logger.Sugar().Errorf("audit: open failed: %v", err)
+			return
+		}
+		store.day = day
+		store.prune(record.Time)
+	}
+
+	name := day + ".jsonl"
+	offset := store.sizes[name]
+	if _, err = store.file.Write(append(chunk, '\n')); err != nil {
+		logger.Sugar().Errorf("audit: write failed: %v", err)
+		return
+	}
+	store.sizes[name] = offset + int64(len(chunk)) + 1
+
+	entry := auditEntry{
+		time: record.Time, client: record.Client, model: record.Model, status: record.Status,
+		file: name, offset: offset, size: len(chunk),
+	}
+	i := sort.Search(len(store.index), func(i int) bool { return store.index[i].time.After(entry.time) })
+	store.index = append(store.index, auditEntry{})
+	copy(store.index[i+1:], store.index[i:])
+	store.index[i] = entry
+
+	if store.maxBytes > 0 {
+		store.prune(record.Time)
+	}
+}
+
+// 删除超出保留时长或总大小的文件, 需持有锁
+func (store *auditStore) prune(now time.Time) {
+	names := make([]string, 0, len(store.sizes))
+	var total int64
+	for name, size := range store.sizes {
+		names = append(names, name)
+		total += size
+	}
+	sort.Strings(names)
+
+	expired := now.Add(-store.retention).Format(auditDay) + ".jsonl"
+	removed := make(map[string]bool)
+	for _, name := range names {
+		current := name == store.day+".jsonl"
+		if current || (name >= expired && (store.maxBytes <= 0 || total <= store.maxBytes)) {
+			break
+		}
+		if err := os.Remove(filepath.Join(store.dir, name)); err != nil && !os.IsNotExist(err) {
+			logger.Sugar().Errorf("audit: remove %s failed: %v", name, err)
+			break
+		}
+		total -= store.sizes[name]
+		delete(store.sizes, name)
+		removed[name] = true
+	}
+
+	if len(removed) > 0 {
+		index := store.index[:0]
+		for _, entry := range store.index {
+			if !removed[entry.file] {
+				index = append(index, entry)
+			}
+		}
+		store.index = index
+	}
+}
+
+// 审计查询条件
+type auditQuery struct {
+	from, to time.Time
+	client   string
+	model    string
+	status   string
+	limit    int
+	offset   int
+}
+
+func (query *auditQuery) match(entry *auditEntry) bool {
+	if !query.from.IsZero() && entry.time.Before(query.from) {
+		return false
+	}
+	if !query.to.IsZero() && !entry.time.Before(query.to) {
+		return false
+	}
+	if query.client != "" && entry.client != query.client {
+		return false
+	}
+	if query.model != "" {
+		if ok, _ := path.Match(query.model, entry.model); !ok && query.model != entry.model {
+			return false
+		}
+	}
+
+	// 200 精确匹配, 4xx 按类别匹配, error 匹配所有 >= 400
+	switch status := strconv.Itoa(entry.status); {
+	case query.status == "":
+	case query.status == "error":
+		return entry.status >= fiber.StatusBadRequest
+	case len(query.status) == 3 && strings.HasSuffix(query.status, "xx"):
+		return status[0] == query.status[0]
+	default:
+		return status == query.status
+	}
+	return true
+}
+
+// 按时间倒序查询, 返回记录与匹配总数
+func (store *auditStore) query(query *auditQuery) (records []json.RawMessage, total int, err error) {
+	store.mu.RLock()
+	var matched []auditEntry
+	for i := len(store.index) - 1; i >= 0; i-- {
+		entry := &store.index[i]
+		if !query.match(entry) {
+			continue
+		}
+		if total >= query.offset && len(matched) < query.limit {
+			matched = append(matched, *entry)
+		}
+		total++
+	}
+	store.mu.RUnlock()
+
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	records = make([]json.RawMessage, 0, len(matched))
+	for _, entry := range matched {
+		f, ok := files[entry.file]
+		if !ok {
+			if f, err = os.Open(filepath.Join(store.dir, entry.file)); err != nil {
+				return
+			}
+			files[entry.file] = f
+		}
+
+		chunk := make([]byte, entry.size)
+		if _, err = f.ReadAt(chunk, entry.offset); err != nil {
+			return
+		}
+		records = append(records, chunk)
+	}
+	return
+}
+
+// 查询审计日志
+//
+//	GET /admin/audit?from=2025-01-01T00:00:00Z&to=...&key=team-a&model=gpt-*&status=5xx&limit=100&offset=0
+func queryAudit(ctx *fiber.Ctx) error {
+	if !auditEnabled() {
+		return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "audit_disabled",
+			"Audit log is disabled, configure `audit.enabled` to enable it.")
+	}
+
+	query := &auditQuery{
+		client: ctx.Query("key"),
+		model:  ctx.Query("model"),
+		status: ctx.Query("status"),
+		limit:  ctx.QueryInt("limit", 100),
+		offset: max(ctx.QueryInt("offset", 0), 0),
+	}
+	if query.limit <= 0 || query.limit > 1000 {
+		query.limit = 1000
+	}
+
+	for name, field := range map[string]*time.Time{"from": &query.from, "to": &query.to} {
+		value := ctx.Query(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_query",
+				"Invalid `"+name+"`, expected an RFC 3339 timestamp.")
+		}
+		*field = t
+	}
+
+	records, total, err := audits().query(query)
+	if err != nil {
+		return writeErrorf(ctx, fiber.StatusInternalServerError, "server_error", "", err.Error())
+	}
+	return ctx.JSON(fiber.Map{"total": total, "data": records})
+}
@@ -0,0 +1,144 @@
+package v1
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+)
+
+// 写入两天的审计记录, 按时间先后: 1..6
+func auditFixture(store *auditStore, base time.Time) {
+	records := []auditRecord{
+		{Id: "1", Client: "a", Model: "gpt-4o", Status: 200},
+		{Id: "2", Client: "b", Model: "gpt-4o-mini", Status: 429},
+		{Id: "3", Client: "a", Model: "claude-3", Status: 502},
+		{Id: "4", Client: "a", Model: "gpt-4o", Status: 200},
+		{Id: "5", Client: "b", Model: "claude-3", Status: 503},
+		{Id: "6", Client: "a", Model: "gpt-4o-mini", Status: 400},
+	}
+	for i := range records {
+		// 前三条在前一天
+		offset := time.Duration(i) * time.Minute
+		if i < 3 {
+			offset -= 24 * time.Hour
+		}
+		records[i].Time = base.Add(offset)
+		store.write(&records[i])
+	}
+}
+
+func auditIds(t *testing.T, records []json.RawMessage) (ids []string) {
+	for _, chunk := range records {
+		var record auditRecord
+		if err := json.Unmarshal(chunk, &record); err != nil {
+			t.Fatalf("invalid record %s: %v", chunk, err)
+		}
+		ids = append(ids, record.Id)
+	}
+	return
+}
+
+func TestAuditQuery(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	dir := t.TempDir()
+	base := time.Now().UTC().Truncate(time.Hour)
+	store := newAuditStore(dir, 720*time.Hour, 0)
+	auditFixture(store, base)
+
+	cases := []struct {
+		name  string
+		query auditQuery
+		ids   []string
+		total int
+	}{
+		{"all", auditQuery{}, []string{"6", "5", "4", "3", "2", "1"}, 6},
+		{"client", auditQuery{client: "b"}, []string{"5", "2"}, 2},
+		{"model glob", auditQuery{model: "gpt-4o*"}, []string{"6", "4", "2", "1"}, 4},
+		{"model exact", auditQuery{model: "gpt-4o"}, []string{"4", "1"}, 2},
+		{"status", auditQuery{status: "200"}, []string{"4", "1"}, 2},
+		{"status class", auditQuery{status: "5xx"}, []string{"5", "3"}, 2},
+		{"status error", auditQuery{status: "error"}, []string{"6", "5", "3", "2"}, 4},
+		{"time range", auditQuery{from: base.Add(-24 * time.Hour).Add(time.Minute), to: base.Add(5 * time.Minute)}, []string{"5", "4", "3", "2"}, 4},
+		{"combined", auditQuery{client: "a", status: "error"}, []string{"6", "3"}, 2},
+		{"page", auditQuery{offset: 1, limit: 2}, []string{"5", "4"}, 6},
+		{"past the end", auditQuery{offset: 10}, nil, 6},
+	}
+
+	check := func(store *auditStore) {
+		for _, tc := range cases {
+			query := tc.query
+			if query.limit == 0 {
+				query.limit = 100
+			}
+
+			records, total, err := store.query(&query)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if ids := auditIds(t, records); !slices.Equal(ids, tc.ids) || total != tc.total {
+				t.Errorf("%s: ids = %v (total %d), want %v (total %d)", tc.name, ids, total, tc.ids, tc.total)
+			}
+		}
+	}
+
+	check(store)
+	_ = store.file.Close()
+
+	// 重启后从 JSONL 文件重建索引
+	check(newAuditStore(dir, 720*time.Hour, 0))
+}
+
+func TestAuditPrune(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	base := time.Now().UTC().Truncate(time.Hour)
+
+	cases := []struct {
+		name      string
+		retention time.Duration
+		maxBytes  int64
+		ids       []string
+	}{
+		{"keep", 720 * time.Hour, 0, []string{"6", "5", "4", "3", "2", "1"}},
+		// 超出总大小时删除最早的文件, 当天的文件总是保留
+		{"max bytes", 720 * time.Hour, 1, []string{"6", "5", "4"}},
+	}
+
+	for _, tc := range cases {
+		dir := t.TempDir()
+		store := newAuditStore(dir, tc.retention, tc.maxBytes)
+		auditFixture(store, base)
+		_ = store.file.Close()
+
+		records, _, err := store.query(&auditQuery{limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := auditIds(t, records); !slices.Equal(ids, tc.ids) {
+			t.Errorf("%s: ids = %v, want %v", tc.name, ids, tc.ids)
+		}
+	}
+
+	// 重启时删除超出保留时长的文件
+	dir := t.TempDir()
+	store := newAuditStore(dir, 720*time.Hour, 0)
+	auditFixture(store, base)
+	_ = store.file.Close()
+
+	restarted := newAuditStore(dir, time.Hour, 0)
+	records, _, _ := restarted.query(&auditQuery{limit: 100})
+	if ids := auditIds(t, records); !slices.Equal(ids, []string{"6", "5", "4"}) {
+		t.Errorf("expired files should be removed, ids = %v", ids)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Errorf("files = %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, base.Add(-24*time.Hour).Format(auditDay)+".jsonl")); !os.IsNotExist(err) {
+		t.Errorf("expired file should be removed: %v", err)
+	}
+}
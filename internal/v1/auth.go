@@ -0,0 +1,374 @@
+package v1
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/internal"
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+const (
+	localKey = "ago.api-key"
+)
+
+var (
+	keys = &keyStore{
+		usage: make(map[string]*keyUsage),
+	}
+)
+
+// 客户端密钥
+//
+//	auth:
+//	  enabled: true
+//	  file: keys.yaml
+//	  state: tmp/auth-state.json
+//	  keys:
+//	    - key: sk-xxx
+//	      name: team-a
+//	      models: [ "gpt-*" ]
+//	      rpm: 60       # 覆盖 rate-limit.key
+//	      tpm: 100000
+//	      quota: { requests: 10000, tokens: 5000000 }
+//	      upstream: sk-upstream
+type apiKey struct {
+	Key      string   `mapstructure:"key" json:"key"`
+	Name     string   `mapstructure:"name" json:"name"`
+	Models   []string `mapstructure:"models" json:"models"`
+	RPM      int64    `mapstructure:"rpm" json:"rpm"`
+	TPM      int64    `mapstructure:"tpm" json:"tpm"`
+	Upstream string   `mapstructure:"upstream" json:"upstream"`
+	Disabled bool     `mapstructure:"disabled" json:"disabled"`
+
+	Quota struct {
+		Requests int64 `mapstructure:"requests" json:"requests"`
+		Tokens   int64 `mapstructure:"tokens" json:"tokens"`
+	} `mapstructure:"quota" json:"quota"`
+}
+
+// 月度用量
+type keyUsage struct {
+	Month    string `json:"month"`
+	Requests int64  `json:"requests"`
+	Tokens   int64  `json:"tokens"`
+}
+
+type keyStore struct {
+	mu sync.RWMutex
+
+	keys    map[string]*apiKey
+	usage   map[string]*keyUsage
+	modTime time.Time
+	checked time.Time
+	loaded  bool
+	dirty   bool
+}
+
+func authEnabled() bool {
+	return Env != nil && Env.GetBool("auth.enabled")
+}
+
+// 模型白名单
+func (key *apiKey) allow(mod string) bool {
+	if len(key.Models) == 0 || mod == "" {
+		return true
+	}
+
+	for _, pattern := range key.Models {
+		if pattern == mod {
+			return true
+		}
+		if ok, _ := path.Match(pattern, mod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 用量、预算与审计的统计标识: 未命名的密钥使用摘要, 避免展示形式相同的密钥合并统计
+func (key *apiKey) account() string {
+	if key.Name != "" {
+		return key.Name
+	}
+	hash := sha256.Sum256([]byte(key.Key))
+	return "key-" + hex.EncodeToString(hash[:6])
+}
+
+// 日志中展示的标识
+func (key *apiKey) id() string {
+	if key.Name != "" {
+		return key.Name
+	}
+	if len(key.Key) > 8 {
+		return key.Key[:3] + "..." + key.Key[len(key.Key)-4:]
+	}
+	return "***"
+}
+
+// 查找密钥, 文件来源的密钥在修改后自动重新加载
+func (store *keyStore) lookup(token string) *apiKey {
+	store.reload()
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	key, ok := store.keys[token]
+	if !ok || key.Disabled {
+		return nil
+	}
+	return key
+}
+
+func (store *keyStore) reload() {
+	store.mu.RLock()
+	fresh := store.loaded && time.Since(store.checked) < 10*time.Second
+	store.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.checked = time.Now()
+
+	file := Env.GetString("auth.file")
+	var modTime time.Time
+	if file != "" {
+		info, err := os.Stat(file)
+		if err != nil {
+			logger.Sugar().Errorf("auth: stat key file failed: %v", err)
+		} else {
+			modTime = info.ModTime()
+		}
+	}
+
+	if store.loaded && modTime.Equal(store.modTime) {
+		return
+	}
+
+	var list []*apiKey
+	if err := Env.UnmarshalKey("auth.keys", &list); err != nil {
+		logger.Sugar().Errorf("auth: load keys failed: %v", err)
+	}
+
+	if file != "" && !modTime.IsZero() {
+		vip := viper.New()
+		vip.SetConfigFile(file)
+		if err := vip.ReadInConfig(); err != nil {
+			logger.Sugar().Errorf("auth: read key file failed: %v", err)
+		} else {
+			var fileKeys []*apiKey
+			if err = vip.UnmarshalKey("keys", &fileKeys); err != nil {
+				logger.Sugar().Errorf("auth: load key file failed: %v", err)
+			}
+			list = append(list, fileKeys...)
+		}
+	}
+
+	store.keys = make(map[string]*apiKey, len(list))
+	for _, key := range list {
+		if key.Key != "" {
+			store.keys[key.Key] = key
+		}
+	}
+
+	if !store.loaded {
+		store.restore()
+	}
+	store.loaded = true
+	store.modTime = modTime
+}
+
+// 当月用量, 需持有锁
+func (store *keyStore) current(key *apiKey) *keyUsage {
+	month := time.Now().Format("2006-01")
+	usage, ok := store.usage[key.Key]
+	if !ok || usage.Month != month {
+		usage = &keyUsage{Month: month}
+		store.usage[key.Key] = usage
+	}
+	return usage
+}
+
+// 校验配额, 需持有锁
+func (store *keyStore) exceeded(key *apiKey) (code, msg string) {
+	usage := store.current(key)
+	if key.Quota.Requests > 0 && usage.Requests >= key.Quota.Requests {
+		return "insufficient_quota", "You exceeded your monthly request quota."
+	}
+	if key.Quota.Tokens > 0 && usage.Tokens >= key.Quota.Tokens {
+		return "insufficient_quota", "You exceeded your monthly token quota."
+	}
+	return
+}
+
+// 校验配额, 不计入请求
+func (store *keyStore) check(key *apiKey) (code, msg string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.exceeded(key)
+}
+
+// 校验配额, 通过后计入一次请求
+func (store *keyStore) acquire(key *apiKey) (code, msg string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if code, msg = store.exceeded(key); code != "" {
+		return
+	}
+
+	store.current(key).Requests++
+	store.dirty = true
+	return
+}
+
+// 计入 token 用量
+func (store *keyStore) consume(key *apiKey, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	usage := store.current(key)
+	usage.Tokens += tokens
+	store.dirty = true
+}
+
+// 恢复持久化的月度用量, 需持有锁
+func (store *keyStore) restore() {
+	file := Env.GetString("auth.state")
+	if file == "" {
+		return
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Sugar().Errorf("auth: read state failed: %v", err)
+		}
+		return
+	}
+
+	if err = json.Unmarshal(data, &store.usage); err != nil {
+		logger.Sugar().Errorf("auth: parse state failed: %v", err)
+	}
+}
+
+// 持久化月度用量
+func (store *keyStore) save() {
+	file := Env.GetString("auth.state")
+	if file == "" {
+		return
+	}
+
+	store.mu.Lock()
+	if !store.dirty {
+		store.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(store.usage)
+	store.dirty = false
+	store.mu.Unlock()
+	if err != nil {
+		logger.Sugar().Errorf("auth: marshal state failed: %v", err)
+		return
+	}
+
+	if err = writeFile(file, data); err != nil {
+		logger.Sugar().Errorf("auth: save state failed: %v", err)
+	}
+}
+
+func initAuth() {
+	if !authEnabled() {
+		return
+	}
+
+	keys.reload()
+	internal.AddExited(keys.save)
+	go func() {
+		for range time.Tick(time.Minute) {
+			keys.save()
+		}
+	}()
+}
+
+// 鉴权中间件
+func authenticate(ctx *fiber.Ctx) error {
+	if !authEnabled() || exempt(ctx) {
+		return ctx.Next()
+	}
+
+	key := keys.lookup(model.ExtractToken(ctx))
+	if key == nil {
+		return writeErrorf(ctx, fiber.StatusUnauthorized, "invalid_request_error", "invalid_api_key",
+			"Incorrect API key provided.")
+	}
+	return admit(ctx, key)
+}
+
+// 校验密钥的模型权限与配额, 通过后记入请求
+func admit(ctx *fiber.Ctx, key *apiKey) error {
+	if mod := peekModel(ctx); !key.allow(mod) {
+		return writeErrorf(ctx, fiber.StatusForbidden, "invalid_request_error", "model_not_found",
+			fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", mod))
+	}
+
+	if code, msg := keys.check(key); code != "" {
+		return writeErrorf(ctx, fiber.StatusTooManyRequests, code, code, msg)
+	}
+
+	ctx.Locals(localKey, key)
+	return ctx.Next()
+}
+
+// 鉴权通过的请求: Token 替换为密钥配置的上游凭证 (未配置时清空, 由适配器使用自身凭证),
+// 客户端密钥只写入 ClientKey 而不转发到上游, 并统计 token 用量
+func authorize(c *model.Ctx) {
+	key, ok := c.Ctx().Locals(localKey).(*apiKey)
+	if !ok {
+		return
+	}
+
+	c.ClientKey = key.Key
+	c.Token = key.Upstream
+	c.Annotate("client", key.id())
+	c.InterceptAt(model.StageMeter, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if usage, ok := model.UsageOf(msg); ok {
+			keys.consume(key, usage.Total())
+		}
+		return next(msg)
+	})
+}
+
+// 模型解析到适配器后计入一次请求, 未知模型不消耗配额
+func charge(c *model.Ctx) (handled bool, err error) {
+	key, ok := c.Ctx().Locals(localKey).(*apiKey)
+	if !ok {
+		return
+	}
+
+	if code, msg := keys.acquire(key); code != "" {
+		return true, writeErrorf(c.Ctx(), fiber.StatusTooManyRequests, code, code, msg)
+	}
+	return
+}
+
+// 预读请求体中的模型
+func peekModel(ctx *fiber.Ctx) string {
+	var body struct {
+		Model string `json:"model"`
+	}
+	_ = json.Unmarshal(ctx.Body(), &body)
+	return body.Model
+}
@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+func TestApiKeyAllow(t *testing.T) {
+	cases := []struct {
+		models []string
+		model  string
+		allow  bool
+	}{
+		{nil, "gpt-4o", true},
+		{[]string{"gpt-*"}, "gpt-4o", true},
+		{[]string{"gpt-*"}, "claude-3", false},
+		{[]string{"claude-3", "gpt-4o"}, "gpt-4o", true},
+		{[]string{"gpt-4o"}, "gpt-4o-mini", false},
+		{[]string{"gpt-*"}, "", true},
+	}
+
+	for _, tc := range cases {
+		key := &apiKey{Key: "sk-test", Models: tc.models}
+		if key.allow(tc.model) != tc.allow {
+			t.Errorf("models %v, model %q: allow = %v", tc.models, tc.model, !tc.allow)
+		}
+	}
+}
+
+func TestKeyQuota(t *testing.T) {
+	store := &keyStore{usage: make(map[string]*keyUsage)}
+	key := &apiKey{Key: "sk-test"}
+	key.Quota.Requests = 2
+	key.Quota.Tokens = 100
+
+	for i := range 2 {
+		if code, _ := store.acquire(key); code != "" {
+			t.Fatalf("request %d should be allowed: %s", i, code)
+		}
+	}
+	if code, _ := store.check(key); code != "insufficient_quota" {
+		t.Fatalf("request quota should be exceeded: %q", code)
+	}
+
+	// 跨月后重新计数
+	store.usage[key.Key].Month = "2000-01"
+	if code, _ := store.acquire(key); code != "" {
+		t.Fatalf("quota should roll over: %s", code)
+	}
+
+	store.consume(key, 100)
+	if code, msg := store.check(key); code != "insufficient_quota" || msg != "You exceeded your monthly token quota." {
+		t.Fatalf("token quota should be exceeded: %q %q", code, msg)
+	}
+}
+
+func TestAuthorizeToken(t *testing.T) {
+	app := fiber.New()
+	for _, tc := range []struct{ upstream, token string }{{"", ""}, {"sk-upstream", "sk-upstream"}} {
+		ctx := app.AcquireCtx(new(fasthttp.RequestCtx))
+		ctx.Request().Header.Set(fiber.HeaderAuthorization, "Bearer sk-client")
+		ctx.Locals(localKey, &apiKey{Key: "sk-client", Upstream: tc.upstream})
+
+		c := model.New(ctx)
+		authorize(c)
+		if c.Token != tc.token || c.ClientKey != "sk-client" {
+			t.Errorf("upstream %q: token = %q, client key = %q", tc.upstream, c.Token, c.ClientKey)
+		}
+		app.ReleaseCtx(ctx)
+	}
+}
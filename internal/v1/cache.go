@@ -0,0 +1,348 @@
+package v1
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+)
+
+var (
+	responseCache     cacheBackend
+	responseCacheOnce sync.Once
+)
+
+// 缓存条目
+type cacheEntry struct {
+	Key      string          `json:"key"`
+	Response *model.Response `json:"response"`
+	Expires  time.Time       `json:"expires"`
+
+	size int64
+}
+
+// 缓存后端
+type cacheBackend interface {
+	get(key string) (*cacheEntry, bool)
+	set(entry *cacheEntry)
+}
+
+// 精确匹配的响应缓存
+//
+//	cache:
+//	  enabled: true
+//	  backend: memory     # memory | disk
+//	  dir: tmp/cache
+//	  ttl: 1h
+//	  max-entries: 10000
+//	  max-bytes: 104857600
+//	  models: [ "*" ]
+func cacheEnabled(mod string) bool {
+	if Env == nil || !Env.GetBool("cache.enabled") {
+		return false
+	}
+
+	models := Env.GetStringSlice("cache.models")
+	if len(models) == 0 {
+		return true
+	}
+
+	for _, pattern := range models {
+		if ok, _ := path.Match(pattern, mod); ok || pattern == mod {
+			return true
+		}
+	}
+	return false
+}
+
+func cache() cacheBackend {
+	responseCacheOnce.Do(func() {
+		maxEntries := Env.GetInt("cache.max-entries")
+		if maxEntries <= 0 {
+			maxEntries = 10000
+		}
+		maxBytes := Env.GetInt64("cache.max-bytes")
+		if maxBytes <= 0 {
+			maxBytes = 100 << 20
+		}
+
+		memory := &memoryCache{
+			maxEntries: maxEntries,
+			maxBytes:   maxBytes,
+			items:      make(map[string]*list.Element),
+			order:      list.New(),
+		}
+
+		if Env.GetString("cache.backend") == "disk" {
+			dir := Env.GetString("cache.dir")
+			if dir == "" {
+				dir = "tmp/cache"
+			}
+			responseCache = newDiskCache(dir, memory)
+			return
+		}
+		responseCache = memory
+	})
+	return responseCache
+}
+
+func cacheTTL() time.Duration {
+	if ttl := Env.GetDuration("cache.ttl"); ttl > 0 {
+		return ttl
+	}
+	return time.Hour
+}
+
+// 规范化请求的摘要, 区分客户端密钥, 避免按密钥注入的提示词产生的结果跨租户命中
+func cacheKey(client string, completion *model.Completion) string {
+	chunk, _ := json.Marshal(struct {
+		Client      string                    `json:"client"`
+		Model       string                    `json:"model"`
+		System      string                    `json:"system"`
+		Messages    []model.CompletionMessage `json:"messages"`
+		Tools       []model.CompletionTool    `json:"tools"`
+		ToolChoice  interface{}               `json:"tool_choice"`
+		Temperature float32                   `json:"temperature"`
+		TopP        float32                   `json:"top_p"`
+		TopK        int                       `json:"top_k"`
+		MaxTokens   int                       `json:"max_tokens"`
+		Stop        []string                  `json:"stop"`
+		Seed        *int64                    `json:"seed"`
+		Format      model.Record[string, any] `json:"response_format"`
+	}{
+		client,
+		completion.Model,
+		completion.System,
+		completion.Messages,
+		completion.Tools,
+		completion.ToolChoice,
+		completion.Temperature,
+		completion.TopP,
+		completion.TopK,
+		completion.MaxTokens,
+		completion.StopSequences,
+		completion.Seed,
+		completion.ResponseFormat,
+	})
+
+	hash := sha256.Sum256(chunk)
+	return hex.EncodeToString(hash[:])
+}
+
+// 查询缓存, 命中时直接重放; 需在添加输出处理拦截器之前调用
+func cached(c *model.Ctx, completion *model.Completion) (hit bool, err error) {
+	if !cacheEnabled(completion.Model) {
+		return
+	}
+
+	control := strings.ToLower(c.Ctx().Get("Cache-Control"))
+	key := cacheKey(c.ClientKey, completion)
+	if !strings.Contains(control, "no-cache") {
+		if entry, ok := cache().get(key); ok {
+			c.Ctx().Set("x-cache", "HIT")
+			usageRequested(c, "cache")
+			return true, replay(c, entry.Response, completion.Stream, model.StageProcess, model.StageAdapter, model.StageGlobal)
+		}
+	}
+
+	c.Ctx().Set("x-cache", "MISS")
+	if !strings.Contains(control, "no-store") {
+		c.InterceptAt(model.StageCapture, cacheCapture(key))
+	}
+	return
+}
+
+// 聚合写出的消息, 正常结束后写入缓存
+func cacheCapture(key string) model.Interceptor {
+	agg := new(model.Aggregator)
+	stored := false
+	return func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		agg.Add(msg)
+		if err := next(msg); err != nil {
+			return err
+		}
+
+		if agg.Done && agg.Err == nil && !stored {
+			stored = true
+			cache().set(&cacheEntry{
+				Key:      key,
+				Response: agg.Response(),
+				Expires:  time.Now().Add(cacheTTL()),
+			})
+		}
+		return nil
+	}
+}
+
+// 重放完整响应, 客户端要求流式时以 SSE 分片写出; skip 为已执行过、不再重复的阶段.
+// 计量与审计始终保留, 重放的响应同样计入配额与用量
+func replay(c *model.Ctx, response *model.Response, stream bool, skip ...model.Stage) error {
+	c.Discard(skip...)
+	if !stream {
+		return c.JSON(response)
+	}
+
+	c.SSE(func(writer func(interface{}) error) {
+		if err := model.Replay(response, writer); err != nil {
+			c.Logger().Errorf("cache: replay failed: %v", err)
+		}
+	})
+	return nil
+}
+
+// 内存 LRU 缓存
+type memoryCache struct {
+	mu sync.Mutex
+
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	items      map[string]*list.Element
+	order      *list.List
+
+	// 淘汰回调
+	evicted func(entry *cacheEntry)
+}
+
+func (cache *memoryCache) get(key string) (*cacheEntry, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.Expires) {
+		cache.remove(element, true)
+		return nil, false
+	}
+
+	cache.order.MoveToFront(element)
+	return entry, true
+}
+
+func (cache *memoryCache) set(entry *cacheEntry) {
+	if entry.size == 0 {
+		chunk, _ := json.Marshal(entry.Response)
+		entry.size = int64(len(chunk))
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if element, ok := cache.items[entry.Key]; ok {
+		cache.remove(element, false)
+	}
+
+	cache.items[entry.Key] = cache.order.PushFront(entry)
+	cache.bytes += entry.size
+	for cache.order.Len() > cache.maxEntries || cache.bytes > cache.maxBytes {
+		cache.remove(cache.order.Back(), true)
+	}
+}
+
+// 需持有锁, evict 为 false 时表示覆盖写入
+func (cache *memoryCache) remove(element *list.Element, evict bool) {
+	entry := element.Value.(*cacheEntry)
+	cache.order.Remove(element)
+	delete(cache.items, entry.Key)
+	cache.bytes -= entry.size
+	if evict && cache.evicted != nil {
+		cache.evicted(entry)
+	}
+}
+
+// 磁盘缓存, 内存中仅保留索引用于容量控制
+type diskCache struct {
+	dir   string
+	index *memoryCache
+}
+
+func newDiskCache(dir string, index *memoryCache) *diskCache {
+	cache := &diskCache{dir: dir, index: index}
+	index.evicted = func(entry *cacheEntry) {
+		_ = os.Remove(cache.file(entry.Key))
+	}
+
+	var entries []*cacheEntry
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+
+		entry, e := cache.read(strings.TrimSuffix(filepath.Base(p), ".json"))
+		if e != nil || time.Now().After(entry.Expires) {
+			_ = os.Remove(p)
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Expires.Before(entries[j].Expires) })
+	for _, entry := range entries {
+		entry.Response = nil
+		index.set(entry)
+	}
+	return cache
+}
+
+func (cache *diskCache) file(key string) string {
+	return filepath.Join(cache.dir, key[:2], key+".json")
+}
+
+func (cache *diskCache) read(key string) (entry *cacheEntry, err error) {
+	chunk, err := os.ReadFile(cache.file(key))
+	if err != nil {
+		return
+	}
+
+	entry = new(cacheEntry)
+	if err = json.Unmarshal(chunk, entry); err != nil {
+		return
+	}
+	entry.size = int64(len(chunk))
+	return
+}
+
+func (cache *diskCache) get(key string) (*cacheEntry, bool) {
+	if _, ok := cache.index.get(key); !ok {
+		return nil, false
+	}
+
+	entry, err := cache.read(key)
+	if err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (cache *diskCache) set(entry *cacheEntry) {
+	chunk, err := json.Marshal(entry)
+	if err != nil {
+		logger.Sugar().Errorf("cache: marshal failed: %v", err)
+		return
+	}
+
+	if err = writeFile(cache.file(entry.Key), chunk); err != nil {
+		logger.Sugar().Errorf("cache: write failed: %v", err)
+		return
+	}
+
+	cache.index.set(&cacheEntry{
+		Key:     entry.Key,
+		Expires: entry.Expires,
+		size:    int64(len(chunk)),
+	})
+}
@@ -0,0 +1,166 @@
+package v1
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+func newMemoryCache(maxEntries int, maxBytes int64) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func cachedResponse(content string) *model.Response {
+	return &model.Response{Choices: []model.Choice{{Message: &model.ChoiceMessage{Content: content}}}}
+}
+
+func TestCacheKey(t *testing.T) {
+	completion := &model.Completion{Model: "m", Messages: []model.CompletionMessage{{"role": "user", "content": "hi"}}}
+	if cacheKey("a", completion) != cacheKey("a", completion) {
+		t.Fatal("same request should have the same key")
+	}
+	if cacheKey("a", completion) == cacheKey("b", completion) {
+		t.Fatal("keys should differ between clients")
+	}
+
+	other := *completion
+	other.Temperature = 0.5
+	if cacheKey("a", completion) == cacheKey("a", &other) {
+		t.Fatal("keys should differ between parameters")
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := newMemoryCache(2, 1<<20)
+	expires := time.Now().Add(time.Hour)
+	for _, key := range []string{"a", "b"} {
+		cache.set(&cacheEntry{Key: key, Response: cachedResponse(key), Expires: expires})
+	}
+
+	// a 最近被访问, 淘汰 b
+	cache.get("a")
+	cache.set(&cacheEntry{Key: "c", Response: cachedResponse("c"), Expires: expires})
+	if _, ok := cache.get("b"); ok {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Fatalf("%s should be cached", key)
+		}
+	}
+
+	cache.set(&cacheEntry{Key: "d", Response: cachedResponse("d"), Expires: time.Now().Add(-time.Second)})
+	if _, ok := cache.get("d"); ok {
+		t.Fatal("expired entry should be missed")
+	}
+
+	small := newMemoryCache(100, 1)
+	small.set(&cacheEntry{Key: "e", Response: cachedResponse("e"), Expires: expires})
+	if _, ok := small.get("e"); ok || small.bytes != 0 {
+		t.Fatal("entries beyond max-bytes should be evicted")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	key := cacheKey("a", &model.Completion{Model: "m"})
+	cache := newDiskCache(dir, newMemoryCache(1, 1<<20))
+	cache.set(&cacheEntry{Key: key, Response: cachedResponse("hello"), Expires: time.Now().Add(time.Hour)})
+
+	// 重启后从磁盘恢复索引
+	restored := newDiskCache(dir, newMemoryCache(1, 1<<20))
+	entry, ok := restored.get(key)
+	if !ok || entry.Response.Choices[0].Message.Content != "hello" {
+		t.Fatalf("entry should be restored: %+v", entry)
+	}
+
+	other := cacheKey("b", &model.Completion{Model: "m"})
+	restored.set(&cacheEntry{Key: other, Response: cachedResponse("world"), Expires: time.Now().Add(time.Hour)})
+	if _, err := os.Stat(restored.file(key)); !os.IsNotExist(err) {
+		t.Fatalf("evicted entry should be removed from disk: %v", err)
+	}
+}
+
+func TestCachedReplay(t *testing.T) {
+	vip := viper.New()
+	vip.Set("cache.enabled", true)
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+	responseCacheOnce.Do(func() {})
+	responseCache = newMemoryCache(10, 1<<20)
+	defer func() { responseCache = nil }()
+
+	app := fiber.New()
+	completion := &model.Completion{Model: "m", Messages: []model.CompletionMessage{{"role": "user", "content": "hi"}}}
+	request := func() (hit bool, stages []string) {
+		ctx := app.AcquireCtx(new(fasthttp.RequestCtx))
+		defer app.ReleaseCtx(ctx)
+		c := model.New(ctx)
+		for _, stage := range []model.Stage{model.StageProcess, model.StageMeter} {
+			name := fmt.Sprint(stage)
+			c.InterceptAt(stage, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+				stages = append(stages, name)
+				return next(msg)
+			})
+		}
+
+		hit, err := cached(c, completion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hit {
+			response := cachedResponse("hello")
+			response.Usage = model.ResponseUsage{"total_tokens": 3}
+			_ = c.JSON(response)
+		}
+		return
+	}
+
+	if hit, _ := request(); hit {
+		t.Fatal("first request should miss")
+	}
+	hit, stages := request()
+	meter := fmt.Sprint(model.StageMeter)
+	if !hit || !slices.Equal(stages, []string{meter}) {
+		t.Fatalf("hit = %v, stages = %v; cached responses should only be metered", hit, stages)
+	}
+}
+
+func TestWriteFileConcurrent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writeFile(file, []byte(fmt.Sprintf(`{"writer":%02d}`, i))); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(file)
+	if err != nil || len(data) != len(`{"writer":00}`) {
+		t.Fatalf("data = %q, err = %v", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(file))
+	if len(entries) != 1 {
+		t.Fatalf("temporary files should be removed: %d entries", len(entries))
+	}
+}
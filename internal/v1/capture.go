@@ -0,0 +1,322 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// 单条记录的最大字节数, 超出时截断
+	captureMaxBytes = 1 << 20
+	// 到期后保留记录以供下载的时长
+	captureRetention = time.Hour
+)
+
+var (
+	captures = &captureStore{items: make(map[string]*capture)}
+)
+
+// 调试抓取: 临时记录匹配客户端或模型的请求体、上游请求与写出的分片, 到期自动失效
+//
+//	POST /admin/debug/captures  {"client": "team-a", "model": "gpt-*", "ttl": "10m", "size": 500}
+type capture struct {
+	mu sync.Mutex
+
+	Id      string    `json:"id"`
+	Client  string    `json:"client,omitempty"`
+	Model   string    `json:"model,omitempty"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+	Size    int       `json:"size"`
+	Total   int       `json:"total"`
+
+	events []captureEvent
+	next   int
+}
+
+type captureEvent struct {
+	Time      time.Time   `json:"time"`
+	RequestId string      `json:"request_id"`
+	Kind      string      `json:"kind"`
+	Data      interface{} `json:"data"`
+}
+
+type captureStore struct {
+	mu    sync.RWMutex
+	items map[string]*capture
+}
+
+// 请求命中的抓取, 经 context 传递给出站请求
+type captureSet struct {
+	requestId string
+	items     []*capture
+}
+
+type captureKey struct{}
+
+// 写入环形缓冲
+func (item *capture) add(event captureEvent) {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if len(item.events) < item.Size {
+		item.events = append(item.events, event)
+	} else {
+		item.events[item.next] = event
+		item.next = (item.next + 1) % item.Size
+	}
+	item.Total++
+}
+
+// 按时间顺序的记录
+func (item *capture) snapshot() []captureEvent {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	events := make([]captureEvent, 0, len(item.events))
+	events = append(events, item.events[item.next:]...)
+	return append(events, item.events[:item.next]...)
+}
+
+func (item *capture) match(key *apiKey, mod string) bool {
+	if item.Client != "" && (key == nil || (item.Client != key.Key && item.Client != key.Name)) {
+		return false
+	}
+	if item.Model != "" {
+		if ok, _ := path.Match(item.Model, mod); !ok && item.Model != mod {
+			return false
+		}
+	}
+	return true
+}
+
+// 清理到期超过保留时长的抓取
+func (store *captureStore) expire() {
+	now := time.Now().Add(-captureRetention)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for id, item := range store.items {
+		if now.After(item.Until) {
+			delete(store.items, id)
+		}
+	}
+}
+
+func (store *captureStore) find(key *apiKey, mod string) (matched []*capture) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	now := time.Now()
+	for _, item := range store.items {
+		if now.Before(item.Until) && item.match(key, mod) {
+			matched = append(matched, item)
+		}
+	}
+	return
+}
+
+// 记录前按日志的规则脱敏
+func (set *captureSet) record(kind string, data interface{}) {
+	event := captureEvent{Time: time.Now(), RequestId: set.requestId, Kind: kind, Data: logger.RedactValue(data)}
+	for _, item := range set.items {
+		item.add(event)
+	}
+}
+
+// 命中抓取时记录请求体与写出的内容, 并经 context 记录出站请求
+func captureRequest(c *model.Ctx, mod string) {
+	captures.mu.RLock()
+	empty := len(captures.items) == 0
+	captures.mu.RUnlock()
+	if empty {
+		return
+	}
+
+	key, _ := c.Ctx().Locals(localKey).(*apiKey)
+	matched := captures.find(key, mod)
+	if len(matched) == 0 {
+		return
+	}
+
+	set := &captureSet{requestId: requestIdOf(c.Ctx()), items: matched}
+	set.record("request", payloadOf(c.Ctx().Body()))
+	c.WithContext(context.WithValue(c.Context(), captureKey{}, set))
+	c.InterceptAt(model.StageCapture, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		switch v := msg.(type) {
+		case error:
+			if v == io.EOF {
+				set.record("output", "[DONE]")
+			} else {
+				set.record("error", v.Error())
+			}
+		default:
+			if msg != model.Flush {
+				set.record("output", snapshotOf(msg))
+			}
+		}
+		return next(msg)
+	})
+}
+
+// 记录出站请求, 请求体读取后还原
+func captureOutbound(request *http.Request) *captureSet {
+	set, ok := request.Context().Value(captureKey{}).(*captureSet)
+	if !ok {
+		return nil
+	}
+
+	var body []byte
+	if request.GetBody != nil {
+		if reader, err := request.GetBody(); err == nil {
+			body, _ = io.ReadAll(reader)
+			_ = reader.Close()
+		}
+	} else if request.Body != nil {
+		body, _ = io.ReadAll(request.Body)
+		_ = request.Body.Close()
+		request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	set.record("outbound", map[string]interface{}{
+		"method":  request.Method,
+		"url":     request.URL.String(),
+		"headers": map[string][]string(request.Header.Clone()),
+		"body":    payloadOf(body),
+	})
+	return set
+}
+
+// 写出的消息可能被复用, 记录时即序列化
+func snapshotOf(msg interface{}) interface{} {
+	switch v := msg.(type) {
+	case string:
+		return v
+	case []byte:
+		return payloadOf(v)
+	}
+
+	chunk, err := json.Marshal(msg)
+	if err != nil {
+		return err.Error()
+	}
+	return json.RawMessage(chunk)
+}
+
+// JSON 内容原样保留, 否则记录为字符串
+func payloadOf(data []byte) interface{} {
+	if len(data) > captureMaxBytes {
+		return string(data[:captureMaxBytes]) + "...(truncated)"
+	}
+	if json.Valid(data) {
+		return json.RawMessage(bytes.Clone(data))
+	}
+	return string(data)
+}
+
+func listCaptures(ctx *fiber.Ctx) error {
+	captures.expire()
+	captures.mu.RLock()
+	items := make([]*capture, 0, len(captures.items))
+	for _, item := range captures.items {
+		items = append(items, item)
+	}
+	captures.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Created.Before(items[j].Created) })
+	result := make([]fiber.Map, 0, len(items))
+	for _, item := range items {
+		item.mu.Lock()
+		result = append(result, fiber.Map{
+			"id": item.Id, "client": item.Client, "model": item.Model,
+			"created": item.Created, "until": item.Until, "size": item.Size, "total": item.Total,
+		})
+		item.mu.Unlock()
+	}
+	return ctx.JSON(result)
+}
+
+func createCapture(ctx *fiber.Ctx) error {
+	var body struct {
+		Client string `json:"client"`
+		Model  string `json:"model"`
+		TTL    string `json:"ttl"`
+		Size   int    `json:"size"`
+	}
+	if err := ctx.BodyParser(&body); err != nil {
+		return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_body", err.Error())
+	}
+	if body.Client == "" && body.Model == "" {
+		return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_body",
+			"Either `client` or `model` is required.")
+	}
+
+	ttl := 10 * time.Minute
+	if body.TTL != "" {
+		d, err := time.ParseDuration(body.TTL)
+		if err != nil || d <= 0 {
+			return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_body",
+				"Invalid `ttl`, expected a duration such as 10m.")
+		}
+		ttl = min(d, 24*time.Hour)
+	}
+
+	size := body.Size
+	if size <= 0 {
+		size = 500
+	}
+
+	now := time.Now()
+	item := &capture{
+		Id:      uuid.NewString(),
+		Client:  body.Client,
+		Model:   body.Model,
+		Created: now,
+		Until:   now.Add(ttl),
+		Size:    min(size, 10000),
+	}
+
+	captures.expire()
+	captures.mu.Lock()
+	captures.items[item.Id] = item
+	captures.mu.Unlock()
+	return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{"id": item.Id, "until": item.Until, "size": item.Size})
+}
+
+// 下载记录, 到期后一小时内仍可下载
+func downloadCapture(ctx *fiber.Ctx) error {
+	captures.mu.RLock()
+	item, ok := captures.items[ctx.Params("id")]
+	captures.mu.RUnlock()
+	if !ok {
+		return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "capture_not_found", "Capture not found.")
+	}
+
+	ctx.Set("content-disposition", `attachment; filename="capture-`+item.Id+`.json"`)
+	return ctx.JSON(fiber.Map{
+		"id":     item.Id,
+		"client": item.Client,
+		"model":  item.Model,
+		"until":  item.Until,
+		"events": item.snapshot(),
+	})
+}
+
+func deleteCapture(ctx *fiber.Ctx) error {
+	captures.mu.Lock()
+	_, ok := captures.items[ctx.Params("id")]
+	delete(captures.items, ctx.Params("id"))
+	captures.mu.Unlock()
+	if !ok {
+		return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "capture_not_found", "Capture not found.")
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
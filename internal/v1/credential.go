@@ -0,0 +1,521 @@
+package v1
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/internal"
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	credentialActive  = "active"
+	credentialCooling = "cooling"
+	credentialInvalid = "invalid"
+)
+
+var (
+	credentials = &credentialPools{
+		pools: make(map[string]*credentialPool),
+	}
+
+	errNoCredential = errors.New("no credential available")
+)
+
+// 凭证状态
+type credentialState struct {
+	Id       string            `json:"id"`
+	Value    string            `json:"value"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Source   string            `json:"source"`
+	Status   string            `json:"status"`
+	Until    time.Time         `json:"until,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Used     int64             `json:"used"`
+	LastUsed time.Time         `json:"last_used,omitempty"`
+}
+
+// 凭证池
+//
+//	credentials:
+//	  state: tmp/credentials.json
+//	  pools:
+//	    claude:
+//	      strategy: round-robin   # round-robin | least-used | sticky
+//	      cooldown: 60s
+//	      values: [ "sessionKey=xxx" ]
+//	      file: claude.txt        # 每行一个凭证
+type credentialPool struct {
+	mu sync.Mutex
+
+	name     string
+	strategy string
+	cooldown time.Duration
+	items    []*credentialState
+	cursor   int
+	sticky   map[string]string
+}
+
+type credentialPools struct {
+	mu    sync.RWMutex
+	pools map[string]*credentialPool
+	dirty bool
+}
+
+// 添加凭证到凭证池
+func AddCredentials(pool string, values ...string) {
+	credentials.add(pool, "sdk", values...)
+}
+
+func credentialId(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:6])
+}
+
+// 是否可用, 冷却到期的凭证恢复为可用
+func (state *credentialState) available(now time.Time) bool {
+	switch state.Status {
+	case credentialInvalid:
+		return false
+	case credentialCooling:
+		if now.Before(state.Until) {
+			return false
+		}
+		state.Status = credentialActive
+		state.Until = time.Time{}
+	}
+	return true
+}
+
+// 脱敏展示
+func (state *credentialState) masked() credentialState {
+	cpy := *state
+	if len(cpy.Value) > 12 {
+		cpy.Value = cpy.Value[:4] + "..." + cpy.Value[len(cpy.Value)-4:]
+	} else {
+		cpy.Value = "***"
+	}
+	return cpy
+}
+
+func (pools *credentialPools) pool(name string, create bool) *credentialPool {
+	pools.mu.RLock()
+	pool, ok := pools.pools[name]
+	pools.mu.RUnlock()
+	if ok || !create {
+		return pool
+	}
+
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+	if pool, ok = pools.pools[name]; !ok {
+		pool = &credentialPool{
+			name:     name,
+			strategy: "round-robin",
+			cooldown: time.Minute,
+			sticky:   make(map[string]string),
+		}
+		pools.pools[name] = pool
+	}
+	return pool
+}
+
+// 添加凭证, 已存在的凭证保留状态
+func (pools *credentialPools) add(name, source string, values ...string) (added int) {
+	pool := pools.pool(name, true)
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+
+		id := credentialId(value)
+		if pool.find(id) != nil {
+			continue
+		}
+
+		pool.items = append(pool.items, &credentialState{
+			Id:     id,
+			Value:  value,
+			Source: source,
+			Status: credentialActive,
+		})
+		added++
+	}
+
+	if added > 0 {
+		pools.touch()
+	}
+	return
+}
+
+func (pools *credentialPools) remove(name, id string) bool {
+	pool := pools.pool(name, false)
+	if pool == nil {
+		return false
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for i, state := range pool.items {
+		if state.Id == id {
+			pool.items = append(pool.items[:i], pool.items[i+1:]...)
+			pools.touch()
+			return true
+		}
+	}
+	return false
+}
+
+// 重置为可用
+func (pools *credentialPools) reset(name, id string) bool {
+	pool := pools.pool(name, false)
+	if pool == nil {
+		return false
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if state := pool.find(id); state != nil {
+		state.Status = credentialActive
+		state.Until = time.Time{}
+		state.Reason = ""
+		pools.touch()
+		return true
+	}
+	return false
+}
+
+func (pools *credentialPools) touch() {
+	pools.mu.Lock()
+	pools.dirty = true
+	pools.mu.Unlock()
+}
+
+// 选取凭证
+func (pools *credentialPools) pick(name string, c *model.Ctx) (*model.Credential, error) {
+	pool := pools.pool(name, false)
+	if pool == nil {
+		return nil, errNoCredential
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	now := time.Now()
+	var available []*credentialState
+	for _, state := range pool.items {
+		if state.available(now) {
+			available = append(available, state)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, errNoCredential
+	}
+
+	var chosen *credentialState
+	switch pool.strategy {
+	case "least-used":
+		chosen = available[0]
+		for _, state := range available[1:] {
+			if state.Used < chosen.Used {
+				chosen = state
+			}
+		}
+
+	case "sticky":
+		conversation := conversationOf(c)
+		if id, ok := pool.sticky[conversation]; ok {
+			if state := pool.find(id); state != nil && state.available(now) {
+				chosen = state
+			}
+		}
+
+		if chosen == nil {
+			chosen = pool.next(available)
+			if len(pool.sticky) > 10000 {
+				pool.sticky = make(map[string]string)
+			}
+			pool.sticky[conversation] = chosen.Id
+		}
+
+	default:
+		chosen = pool.next(available)
+	}
+
+	chosen.Used++
+	chosen.LastUsed = now
+	pools.touch()
+
+	id := chosen.Id
+	return model.NewCredential(name, id, chosen.Value, chosen.Meta, func(status string, d time.Duration, reason string) {
+		pools.report(name, id, status, d, reason)
+	}), nil
+}
+
+// 凭证状态回报
+func (pools *credentialPools) report(name, id, status string, d time.Duration, reason string) {
+	pool := pools.pool(name, false)
+	if pool == nil {
+		return
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	state := pool.find(id)
+	if state == nil {
+		return
+	}
+
+	state.Status = status
+	state.Reason = reason
+	if status == credentialCooling {
+		if d <= 0 {
+			d = pool.cooldown
+		}
+		state.Until = time.Now().Add(d)
+		logger.Sugar().Warnf("credentials: [%s] %s cooling down for %s", name, id, d)
+	} else {
+		logger.Sugar().Warnf("credentials: [%s] %s marked %s: %s", name, id, status, reason)
+	}
+	pools.touch()
+}
+
+// 轮询, 需持有锁
+func (pool *credentialPool) next(available []*credentialState) *credentialState {
+	chosen := available[pool.cursor%len(available)]
+	pool.cursor++
+	return chosen
+}
+
+// 需持有锁
+func (pool *credentialPool) find(id string) *credentialState {
+	for _, state := range pool.items {
+		if state.Id == id {
+			return state
+		}
+	}
+	return nil
+}
+
+// 会话标识: 优先取请求头, 否则以首条消息摘要代替
+func conversationOf(c *model.Ctx) string {
+	if id := c.Ctx().Get("X-Conversation-Id"); id != "" {
+		return id
+	}
+
+	completion, ok := model.GetValue[string, *model.Completion](c.Record, "completion")
+	if !ok {
+		return ""
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(completion.System))
+	if len(completion.Messages) > 0 {
+		hash.Write([]byte(completion.Messages[0].String()))
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+// 从配置及文件加载凭证, 并恢复持久化的状态
+func initCredentials() {
+	if Env == nil {
+		return
+	}
+
+	for name := range Env.GetStringMap("credentials.pools") {
+		prefix := "credentials.pools." + name
+		pool := credentials.pool(name, true)
+		if strategy := Env.GetString(prefix + ".strategy"); strategy != "" {
+			pool.strategy = strategy
+		}
+		if cooldown := Env.GetDuration(prefix + ".cooldown"); cooldown > 0 {
+			pool.cooldown = cooldown
+		}
+
+		credentials.add(name, "config", Env.GetStringSlice(prefix+".values")...)
+		if file := Env.GetString(prefix + ".file"); file != "" {
+			values, err := readLines(file)
+			if err != nil {
+				logger.Sugar().Errorf("credentials: read [%s] failed: %v", file, err)
+			}
+			credentials.add(name, "file", values...)
+		}
+	}
+
+	credentials.restore()
+	internal.AddExited(credentials.save)
+	go func() {
+		for range time.Tick(30 * time.Second) {
+			credentials.save()
+		}
+	}()
+}
+
+func readLines(file string) (lines []string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	err = scanner.Err()
+	return
+}
+
+// 恢复持久化的状态, 合并到已加载的凭证上; 通过管理接口添加的凭证一并恢复
+func (pools *credentialPools) restore() {
+	file := Env.GetString("credentials.state")
+	if file == "" {
+		return
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Sugar().Errorf("credentials: read state failed: %v", err)
+		}
+		return
+	}
+
+	var persisted map[string][]*credentialState
+	if err = json.Unmarshal(data, &persisted); err != nil {
+		logger.Sugar().Errorf("credentials: parse state failed: %v", err)
+		return
+	}
+
+	for name, states := range persisted {
+		pool := pools.pool(name, true)
+		pool.mu.Lock()
+		for _, state := range states {
+			if exists := pool.find(state.Id); exists != nil {
+				exists.Status, exists.Until, exists.Reason = state.Status, state.Until, state.Reason
+				exists.Used, exists.LastUsed = state.Used, state.LastUsed
+			} else if state.Source == "api" {
+				pool.items = append(pool.items, state)
+			}
+		}
+		pool.mu.Unlock()
+	}
+}
+
+// 持久化状态
+func (pools *credentialPools) save() {
+	file := Env.GetString("credentials.state")
+	if file == "" {
+		return
+	}
+
+	pools.mu.Lock()
+	if !pools.dirty {
+		pools.mu.Unlock()
+		return
+	}
+	pools.dirty = false
+	names := make([]string, 0, len(pools.pools))
+	for name := range pools.pools {
+		names = append(names, name)
+	}
+	pools.mu.Unlock()
+
+	persisted := make(map[string][]credentialState)
+	for _, name := range names {
+		pool := pools.pool(name, false)
+		pool.mu.Lock()
+		for _, state := range pool.items {
+			persisted[name] = append(persisted[name], *state)
+		}
+		pool.mu.Unlock()
+	}
+
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		logger.Sugar().Errorf("credentials: marshal state failed: %v", err)
+		return
+	}
+
+	if err = writeFile(file, data); err != nil {
+		logger.Sugar().Errorf("credentials: save state failed: %v", err)
+	}
+}
+
+// 凭证池列表, 值已脱敏
+func (pools *credentialPools) list() map[string][]credentialState {
+	pools.mu.RLock()
+	names := make([]string, 0, len(pools.pools))
+	for name := range pools.pools {
+		names = append(names, name)
+	}
+	pools.mu.RUnlock()
+	sort.Strings(names)
+
+	result := make(map[string][]credentialState, len(names))
+	now := time.Now()
+	for _, name := range names {
+		pool := pools.pool(name, false)
+		pool.mu.Lock()
+		result[name] = make([]credentialState, 0, len(pool.items))
+		for _, state := range pool.items {
+			state.available(now)
+			result[name] = append(result[name], state.masked())
+		}
+		pool.mu.Unlock()
+	}
+	return result
+}
+
+func listCredentials(ctx *fiber.Ctx) error {
+	return ctx.JSON(credentials.list())
+}
+
+func addCredentials(ctx *fiber.Ctx) error {
+	return addPoolCredentials(ctx, ctx.Params("pool"))
+}
+
+func addPoolCredentials(ctx *fiber.Ctx, pool string) (err error) {
+	var body struct {
+		Values []string `json:"values"`
+	}
+	if err = ctx.BodyParser(&body); err != nil {
+		return
+	}
+
+	added := credentials.add(pool, "api", body.Values...)
+	return ctx.JSON(model.Record[string, any]{"added": added})
+}
+
+func removeCredential(ctx *fiber.Ctx) error {
+	return removePoolCredential(ctx, ctx.Params("pool"))
+}
+
+func removePoolCredential(ctx *fiber.Ctx, pool string) error {
+	if !credentials.remove(pool, ctx.Params("id")) {
+		return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "not_found", "credential not found")
+	}
+	return ctx.JSON(model.Record[string, any]{"removed": true})
+}
+
+func resetCredential(ctx *fiber.Ctx) error {
+	if !credentials.reset(ctx.Params("pool"), ctx.Params("id")) {
+		return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "not_found", "credential not found")
+	}
+	return ctx.JSON(model.Record[string, any]{"reset": true})
+}
@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+func newPools(strategy string, values ...string) *credentialPools {
+	pools := &credentialPools{pools: make(map[string]*credentialPool)}
+	pools.add("test", "config", values...)
+	pools.pool("test", false).strategy = strategy
+	return pools
+}
+
+// 按会话标识请求头构造上下文
+func conversationCtx(t *testing.T, conversation string) *model.Ctx {
+	app := fiber.New()
+	ctx := app.AcquireCtx(new(fasthttp.RequestCtx))
+	t.Cleanup(func() { app.ReleaseCtx(ctx) })
+	if conversation != "" {
+		ctx.Request().Header.Set("X-Conversation-Id", conversation)
+	}
+	return model.New(ctx)
+}
+
+func TestCredentialStrategies(t *testing.T) {
+	cases := []struct {
+		strategy      string
+		conversations []string
+		picks         []string
+	}{
+		{"round-robin", []string{"", "", "", ""}, []string{"a", "b", "c", "a"}},
+		{"least-used", []string{"", "", "", ""}, []string{"a", "b", "c", "a"}},
+		{"sticky", []string{"x", "y", "x", "y"}, []string{"a", "b", "a", "b"}},
+	}
+
+	for _, tc := range cases {
+		pools := newPools(tc.strategy, "a", "b", "c")
+		for i, conversation := range tc.conversations {
+			credential, err := pools.pick("test", conversationCtx(t, conversation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if credential.Value != tc.picks[i] {
+				t.Fatalf("%s: pick %d = %s, want %s", tc.strategy, i, credential.Value, tc.picks[i])
+			}
+		}
+	}
+}
+
+func TestCredentialCooldown(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	pools := newPools("round-robin", "a", "b")
+	c := conversationCtx(t, "")
+
+	first, _ := pools.pick("test", c)
+	first.Cooldown(50 * time.Millisecond)
+	for range 3 {
+		if credential, _ := pools.pick("test", c); credential.Value != "b" {
+			t.Fatalf("cooling credential should be skipped, got %s", credential.Value)
+		}
+	}
+
+	second, _ := pools.pick("test", c)
+	second.Invalid("unauthorized")
+	if _, err := pools.pick("test", c); !errors.Is(err, errNoCredential) {
+		t.Fatalf("no credential should be available: %v", err)
+	}
+
+	// 冷却到期后恢复, 失效的凭证需手动重置
+	time.Sleep(60 * time.Millisecond)
+	if credential, _ := pools.pick("test", c); credential.Value != "a" {
+		t.Fatalf("cooldown should expire, got %s", credential.Value)
+	}
+	if !pools.reset("test", second.Id) {
+		t.Fatal("reset failed")
+	}
+	seen := map[string]bool{}
+	for range 2 {
+		credential, _ := pools.pick("test", c)
+		seen[credential.Value] = true
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Fatalf("both credentials should be available after reset: %v", seen)
+	}
+}
@@ -0,0 +1,230 @@
+package v1
+
+import (
+	_ "embed"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	localActivity = "ago.activity"
+	// 保留的最近错误条数
+	recentErrors = 50
+)
+
+var (
+	//go:embed dashboard/index.html
+	dashboardPage []byte
+
+	activities = &activityStore{active: make(map[*activity]struct{})}
+	started    = time.Now()
+)
+
+// 进行中的请求
+type activity struct {
+	mu sync.Mutex
+	activityInfo
+
+	failed bool
+}
+
+type activityInfo struct {
+	RequestId string    `json:"request_id"`
+	Type      string    `json:"type"`
+	Model     string    `json:"model"`
+	Client    string    `json:"client,omitempty"`
+	Adapter   string    `json:"adapter,omitempty"`
+	Stream    bool      `json:"stream"`
+	Started   time.Time `json:"started"`
+}
+
+// 最近的错误
+type activityError struct {
+	Time      time.Time `json:"time"`
+	RequestId string    `json:"request_id"`
+	Type      string    `json:"type"`
+	Model     string    `json:"model"`
+	Client    string    `json:"client,omitempty"`
+	Adapter   string    `json:"adapter,omitempty"`
+	Status    int       `json:"status"`
+	Error     string    `json:"error"`
+}
+
+type activityStore struct {
+	mu sync.Mutex
+
+	active map[*activity]struct{}
+	errors []activityError
+	next   int
+}
+
+// 管理面板, 数据接口需在页面中填写 admin.key
+func index(ctx *fiber.Ctx) error {
+	ctx.Set("content-type", "text/html; charset=utf-8")
+	return ctx.Send(dashboardPage)
+}
+
+// 记录进行中的请求, 流式响应写完后移除
+func tracked(c *model.Ctx, mod string) {
+	item := &activity{activityInfo: activityInfo{
+		RequestId: requestIdOf(c.Ctx()),
+		Type:      c.Type,
+		Model:     mod,
+		Started:   time.Now(),
+	}}
+	if key, ok := c.Ctx().Locals(localKey).(*apiKey); ok {
+		item.Client = key.id()
+	}
+	c.Ctx().Locals(localActivity, item)
+
+	activities.mu.Lock()
+	activities.active[item] = struct{}{}
+	activities.mu.Unlock()
+
+	c.InterceptAt(model.StageCapture, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		item.mu.Lock()
+		item.Stream = c.Streaming()
+		item.mu.Unlock()
+		if err, ok := msg.(error); ok && err != io.EOF {
+			activities.fail(item, fiber.StatusOK, err.Error())
+		}
+		return next(msg)
+	})
+	c.OnDone(func() {
+		activities.finish(item)
+	})
+}
+
+// 非流式响应在处理函数返回后移除, 并记录错误响应
+func track(ctx *fiber.Ctx) (err error) {
+	defer func() {
+		item, ok := ctx.Locals(localActivity).(*activity)
+		if !ok || ctx.Response().IsBodyStream() {
+			return
+		}
+
+		if status := ctx.Response().StatusCode(); err != nil {
+			activities.fail(item, fiber.StatusInternalServerError, err.Error())
+		} else if status >= fiber.StatusBadRequest {
+			activities.fail(item, status, string(ctx.Response().Body()))
+		}
+		activities.finish(item)
+	}()
+	return ctx.Next()
+}
+
+// 记录分发的适配器
+func activityDispatched(c *model.Ctx, entry registered) {
+	if item, ok := c.Ctx().Locals(localActivity).(*activity); ok {
+		item.mu.Lock()
+		item.Adapter = entry.Id
+		item.mu.Unlock()
+	}
+}
+
+func (store *activityStore) finish(item *activity) {
+	store.mu.Lock()
+	delete(store.active, item)
+	store.mu.Unlock()
+}
+
+// 每个请求只记录第一个错误
+func (store *activityStore) fail(item *activity, status int, message string) {
+	item.mu.Lock()
+	if item.failed {
+		item.mu.Unlock()
+		return
+	}
+	item.failed = true
+	if len(message) > 1024 {
+		message = message[:1024] + "..."
+	}
+	record := activityError{
+		Time:      time.Now(),
+		RequestId: item.RequestId,
+		Type:      item.Type,
+		Model:     item.Model,
+		Client:    item.Client,
+		Adapter:   item.Adapter,
+		Status:    status,
+		Error:     strings.Clone(message),
+	}
+	item.mu.Unlock()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.errors) < recentErrors {
+		store.errors = append(store.errors, record)
+	} else {
+		store.errors[store.next] = record
+		store.next = (store.next + 1) % recentErrors
+	}
+}
+
+// 各适配器进行中的请求数
+func (store *activityStore) byAdapter() map[string]int {
+	store.mu.Lock()
+	items := make([]*activity, 0, len(store.active))
+	for item := range store.active {
+		items = append(items, item)
+	}
+	store.mu.Unlock()
+
+	inflight := make(map[string]int)
+	for _, item := range items {
+		item.mu.Lock()
+		inflight[item.Adapter]++
+		item.mu.Unlock()
+	}
+	return inflight
+}
+
+// 进行中的请求与最近的错误, 均按时间倒序
+func (store *activityStore) snapshot() (active []activityInfo, errors []activityError) {
+	store.mu.Lock()
+	items := make([]*activity, 0, len(store.active))
+	for item := range store.active {
+		items = append(items, item)
+	}
+	errors = append(errors, store.errors[store.next:]...)
+	errors = append(errors, store.errors[:store.next]...)
+	store.mu.Unlock()
+
+	active = make([]activityInfo, 0, len(items))
+	for _, item := range items {
+		item.mu.Lock()
+		active = append(active, item.activityInfo)
+		item.mu.Unlock()
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Started.After(active[j].Started) })
+	for i, j := 0, len(errors)-1; i < j; i, j = i+1, j-1 {
+		errors[i], errors[j] = errors[j], errors[i]
+	}
+	return
+}
+
+// 面板数据: 适配器与模型、进行中的请求、最近的错误、凭证池与浏览器实例
+func dashboard(ctx *fiber.Ctx) error {
+	counters, inflight := adapterStats()
+	entries := adapters.snapshot()
+	list := make([]fiber.Map, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, adapterInfo(entry, counters, inflight))
+	}
+
+	active, errors := activities.snapshot()
+	return ctx.JSON(fiber.Map{
+		"uptime":      time.Since(started).Round(time.Second).String(),
+		"adapters":    list,
+		"inflight":    active,
+		"errors":      errors,
+		"credentials": credentials.list(),
+		"browsers":    model.Browsers(),
+	})
+}
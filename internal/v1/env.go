@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -23,6 +24,11 @@ type Environ struct {
 	path string
 }
 
+// 开关类配置, 未配置时默认开启
+func enforced(key string) bool {
+	return Env == nil || !Env.IsSet(key) || Env.GetBool(key)
+}
+
 func InitEnviron() (err error) {
 	path := "config.yaml"
 	environ := os.Environ()
@@ -86,3 +92,33 @@ func readConfig(path string) (config []byte, err error) {
 	config, err = os.ReadFile(path)
 	return
 }
+
+// 写入文件, 先写同目录下唯一的临时文件再替换, 并发写入同一文件时互不破坏
+func writeFile(path string, data []byte) (err error) {
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0744); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
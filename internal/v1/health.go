@@ -0,0 +1,200 @@
+package v1
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/internal"
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	healthHealthy   = "healthy"
+	healthUnhealthy = "unhealthy"
+	healthUnchecked = "unchecked"
+)
+
+var (
+	healths = &healthStore{items: make(map[string]*healthState)}
+)
+
+// 适配器的健康状态, 按注册标识记录, 重名的适配器分别检查
+type healthState struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked,omitempty"`
+	Latency int64     `json:"latency_ms"`
+}
+
+type healthStore struct {
+	mu    sync.RWMutex
+	items map[string]*healthState
+	// 同一时刻只进行一轮检查
+	running sync.Mutex
+}
+
+// 存活、就绪与适配器健康检查
+//
+//	health:
+//	  interval: 30s           # 检查间隔, 结果在间隔内缓存
+//	  timeout: 10s            # 单次检查的超时
+//	  skip-unhealthy: true    # 路由时跳过不健康的适配器, 全部不健康时仍尝试
+func healthInterval() time.Duration {
+	if Env != nil && Env.GetDuration("health.interval") > 0 {
+		return Env.GetDuration("health.interval")
+	}
+	return 30 * time.Second
+}
+
+func healthTimeout() time.Duration {
+	if Env != nil && Env.GetDuration("health.timeout") > 0 {
+		return Env.GetDuration("health.timeout")
+	}
+	return 10 * time.Second
+}
+
+func healthRoutes(app *fiber.App) {
+	app.Get("/healthz", func(ctx *fiber.Ctx) error {
+		return ctx.JSON(fiber.Map{"status": "ok"})
+	})
+	app.Get("/readyz", readiness)
+	app.Get("/health/adapters", adapterHealth)
+}
+
+// 定期执行适配器的健康检查
+func initHealth() {
+	go func() {
+		for {
+			healths.check()
+			time.Sleep(healthInterval())
+		}
+	}()
+}
+
+// 适配器注册的健康检查
+func healthCheckOf(adapter model.Adapter) func(context.Context) error {
+	if checked, ok := adapter.(interface {
+		HealthCheck() func(context.Context) error
+	}); ok {
+		return checked.HealthCheck()
+	}
+	return nil
+}
+
+// 并发检查所有注册了健康检查的适配器
+func (store *healthStore) check() {
+	store.running.Lock()
+	defer store.running.Unlock()
+
+	var wg sync.WaitGroup
+	for _, entry := range adapters.enabled() {
+		check := healthCheckOf(entry.Adapter)
+		if check == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(id, name string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), healthTimeout())
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+			state := &healthState{
+				Id:      id,
+				Name:    name,
+				Status:  healthHealthy,
+				Checked: time.Now(),
+				Latency: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				state.Status, state.Error = healthUnhealthy, err.Error()
+			}
+
+			store.mu.Lock()
+			if previous, ok := store.items[id]; ok && previous.Status != state.Status {
+				if err != nil {
+					logger.Sugar().Warnf("health: adapter [%s] is unhealthy: %v", id, err)
+				} else {
+					logger.Sugar().Infof("health: adapter [%s] recovered", id)
+				}
+			}
+			store.items[id] = state
+			store.mu.Unlock()
+		}(entry.Id, nameOf(entry.Adapter))
+	}
+	wg.Wait()
+}
+
+// 缓存的健康状态, 未检查过时返回 unchecked
+func (store *healthStore) state(entry registered) healthState {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if state, ok := store.items[entry.Id]; ok {
+		return *state
+	}
+	return healthState{Id: entry.Id, Name: nameOf(entry.Adapter), Status: healthUnchecked}
+}
+
+// 路由时是否可用
+func healthy(entry registered) bool {
+	if !enforced("health.skip-unhealthy") {
+		return true
+	}
+	return healths.state(entry).Status != healthUnhealthy
+}
+
+// 跳过不健康的适配器, 全部不健康时原样返回
+func skipUnhealthy(supported []registered) []registered {
+	available := make([]registered, 0, len(supported))
+	for _, entry := range supported {
+		if healthy(entry) {
+			available = append(available, entry)
+		}
+	}
+	if len(available) == 0 {
+		return supported
+	}
+	return available
+}
+
+// 初始化回调执行完毕前, 转发请求返回 503
+func awaitReady(ctx *fiber.Ctx) error {
+	if internal.Ready() || exempt(ctx) {
+		return ctx.Next()
+	}
+	ctx.Set(fiber.HeaderRetryAfter, "5")
+	return writeErrorf(ctx, fiber.StatusServiceUnavailable, "server_error", "initializing",
+		"The server is initializing, please retry later.")
+}
+
+// 初始化回调执行完毕后就绪
+func readiness(ctx *fiber.Ctx) error {
+	if !internal.Ready() {
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "initializing"})
+	}
+	return ctx.JSON(fiber.Map{"status": "ready"})
+}
+
+// 各适配器缓存的健康状态, 上游的错误信息仅对管理密钥可见
+func adapterHealth(ctx *fiber.Ctx) error {
+	admin := isAdmin(ctx)
+	entries := adapters.enabled()
+	result := make([]healthState, 0, len(entries))
+	for _, entry := range entries {
+		state := healths.state(entry)
+		if !admin {
+			state.Error = ""
+		}
+		result = append(result, state)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return ctx.JSON(result)
+}
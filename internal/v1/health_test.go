@@ -0,0 +1,77 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/spf13/viper"
+)
+
+// 同名的适配器, 健康检查结果由 err 决定
+type checkedAdapter struct {
+	model.BasicAdapter
+	err error
+}
+
+func (checkedAdapter) Name() string                    { return "checked" }
+func (checkedAdapter) Support(*model.Ctx, string) bool { return true }
+func (checkedAdapter) Model() []model.Model            { return []model.Model{{Id: "checked"}} }
+
+func (adapter checkedAdapter) HealthCheck() func(context.Context) error {
+	return func(context.Context) error { return adapter.err }
+}
+
+func TestHealthById(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	vip := viper.New()
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	saved := healths
+	defer func() { healths = saved }()
+	healths = &healthStore{items: make(map[string]*healthState)}
+
+	AddAdapter(checkedAdapter{err: errors.New("upstream is down")})
+	AddAdapter(checkedAdapter{})
+	defer RemoveAdapter("checked")
+	defer RemoveAdapter("checked#2")
+
+	entries := adapters.enabled()
+	for _, entry := range entries {
+		if state := healths.state(entry); state.Status != healthUnchecked {
+			t.Fatalf("%s: status = %s before check", entry.Id, state.Status)
+		}
+	}
+	healths.check()
+
+	cases := []struct {
+		id     string
+		status string
+	}{
+		{"checked", healthUnhealthy},
+		{"checked#2", healthHealthy},
+	}
+	for _, tc := range cases {
+		entry, ok := adapters.get(tc.id)
+		if !ok {
+			t.Fatalf("adapter [%s] is not registered", tc.id)
+		}
+		if state := healths.state(entry); state.Status != tc.status || state.Id != tc.id || state.Name != "checked" {
+			t.Errorf("%s: state = %+v, want %s", tc.id, state, tc.status)
+		}
+	}
+
+	// 重名的适配器只跳过不健康的一个
+	supported := supports(conversationCtx(t, ""), "checked")
+	if len(supported) != 1 || supported[0].Id != "checked#2" {
+		t.Fatalf("supported = %+v", supported)
+	}
+
+	vip.Set("health.skip-unhealthy", false)
+	if supported = supports(conversationCtx(t, ""), "checked"); len(supported) != 2 {
+		t.Fatalf("skip-unhealthy disabled, supported = %+v", supported)
+	}
+}
@@ -0,0 +1,189 @@
+package v1
+
+import (
+	"path"
+	"time"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// 对冲事件
+type hedgeEvent struct {
+	index int
+	kind  string
+	msg   interface{}
+
+	done bool
+	err  error
+}
+
+// 是否开启对冲请求
+//
+//	hedging:
+//	  enabled: true
+//	  delay: 3s
+//	  models: [ "gpt-*" ]
+func hedging(mod string) bool {
+	if Env == nil || !Env.GetBool("hedging.enabled") {
+		return false
+	}
+
+	models := Env.GetStringSlice("hedging.models")
+	if len(models) == 0 {
+		return true
+	}
+
+	for _, pattern := range models {
+		if pattern == mod {
+			return true
+		}
+		if ok, _ := path.Match(pattern, mod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hedgeDelay() time.Duration {
+	if delay := Env.GetDuration("hedging.delay"); delay > 0 {
+		return delay
+	}
+	return 3 * time.Second
+}
+
+// 对冲请求: 首个适配器在延迟内未产出首个 token 时, 以克隆的上下文请求下一个适配器,
+// 取最先产出的结果写出, 并取消其余请求. 派生上下文脱离 fiber 请求, 处理函数返回后仍可安全运行
+func hedge(c *model.Ctx, entries ...registered) (err error) {
+	events := make(chan hedgeEvent)
+	forks := make([]*model.Ctx, 0, len(entries))
+
+	launch := func() {
+		index := len(forks)
+		entry := entries[index]
+
+		fork := c.Fork()
+		release := fork.Detach()
+		fork.Annotate("attempt", index+1)
+		// 适配器 After 由胜者写出时在原上下文中执行, 保持 核心处理 -> 适配器 After -> 全局 After 的顺序
+		fork.RedirectRaw(func(kind string, msg interface{}) error {
+			select {
+			case events <- hedgeEvent{index: index, kind: kind, msg: msg}:
+				return nil
+			case <-fork.Context().Done():
+				return fork.Context().Err()
+			}
+		})
+		forks = append(forks, fork)
+
+		go func() {
+			defer release()
+			e := mount(fork, entry)
+			if e == nil {
+				e = traceCall(fork, entry.Adapter, "relay", entry.Adapter.Relay)
+			}
+			select {
+			case events <- hedgeEvent{index: index, done: true, err: e}:
+			case <-fork.Context().Done():
+			}
+		}()
+	}
+
+	cancel := func(skip int) {
+		for i, fork := range forks {
+			if i != skip {
+				fork.Cancel()
+			}
+		}
+	}
+
+	delay := hedgeDelay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	launch()
+	running := 1
+	for {
+		select {
+		case <-timer.C:
+			if len(forks) < len(entries) {
+				c.Logger().Infof("hedging: [%s] no response after %s, launch [%s]",
+					entries[0].Id, delay, entries[len(forks)].Id)
+				launch()
+				running++
+			}
+
+		case event := <-events:
+			if !event.done {
+				cancel(event.index)
+				return forward(c, forks[event.index], events, event)
+			}
+
+			running--
+			if event.err != nil {
+				err = event.err
+				c.Logger().Errorf("hedging: [%s] relay failed: %v", entries[event.index].Id, event.err)
+			}
+
+			if running > 0 {
+				continue
+			}
+
+			// 已结束却未产出, 立即启用下一个
+			if len(forks) < len(entries) {
+				launch()
+				running++
+				continue
+			}
+			if err != nil {
+				return
+			}
+			return writeErrorf(c.Ctx(), fiber.StatusBadGateway, "server_error", "empty_response",
+				"All adapters finished without output.")
+
+		case <-c.Context().Done():
+			cancel(-1)
+			return c.Context().Err()
+		}
+	}
+}
+
+// 写出胜者的结果
+func forward(c, winner *model.Ctx, events chan hedgeEvent, first hedgeEvent) error {
+	c.Adopt(winner)
+	if first.kind == "json" {
+		defer winner.Cancel()
+		return c.JSON(first.msg)
+	}
+
+	c.SSE(func(writer func(interface{}) error) {
+		defer winner.Cancel()
+		if writer(first.msg) != nil {
+			return
+		}
+
+		for {
+			select {
+			case event := <-events:
+				if event.index != first.index {
+					continue
+				}
+
+				if event.done {
+					if event.err != nil {
+						c.Logger().Errorf("hedging: relay failed: %v", event.err)
+					}
+					return
+				}
+
+				if writer(event.msg) != nil {
+					return
+				}
+
+			case <-winner.Context().Done():
+				return
+			}
+		}
+	})
+	return nil
+}
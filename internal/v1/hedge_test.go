@@ -0,0 +1,121 @@
+package v1
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+// 延迟 delay 后输出 content, 并在写出前添加适配器 After
+type hedgedAdapter struct {
+	model.BasicAdapter
+	content string
+	delay   time.Duration
+	order   *hedgeOrder
+}
+
+func (hedgedAdapter) Support(*model.Ctx, string) bool { return true }
+func (hedgedAdapter) Model() []model.Model            { return nil }
+
+func (adapter hedgedAdapter) Relay(c *model.Ctx) error {
+	select {
+	case <-time.After(adapter.delay):
+	case <-c.Context().Done():
+		return c.Context().Err()
+	}
+	if adapter.content == "" {
+		return nil
+	}
+
+	c.InterceptAt(model.StageAdapter, adapter.order.interceptor("adapter"))
+	c.SSE(func(writer func(interface{}) error) {
+		_ = writer(&model.Response{Choices: []model.Choice{{Delta: &model.ChoiceDelta{Content: adapter.content}}}})
+		_ = writer(io.EOF)
+	})
+	return nil
+}
+
+// 记录首条消息经过各阶段的顺序
+type hedgeOrder struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (order *hedgeOrder) interceptor(step string) model.Interceptor {
+	return func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if _, ok := msg.(*model.Response); ok {
+			order.mu.Lock()
+			order.steps = append(order.steps, step)
+			order.mu.Unlock()
+		}
+		return next(msg)
+	}
+}
+
+func hedgeApp(order *hedgeOrder, adapters ...model.Adapter) *fiber.App {
+	entries := make([]registered, 0, len(adapters))
+	for _, adapter := range adapters {
+		entries = append(entries, entryOf(adapter))
+	}
+
+	app := fiber.New()
+	app.Post("/", func(ctx *fiber.Ctx) error {
+		c := model.New(ctx)
+		c.Intercept(order.interceptor("process"))
+		c.InterceptAt(model.StageGlobal, order.interceptor("global"))
+		return hedge(c, entries...)
+	})
+	return app
+}
+
+func TestHedge(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	vip := viper.New()
+	vip.Set("hedging.delay", "20ms")
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	order := new(hedgeOrder)
+	slow := hedgedAdapter{content: "slow", delay: time.Second, order: order}
+	fast := hedgedAdapter{content: "fast", order: order}
+	response, err := hedgeApp(order, slow, fast).Test(httptest.NewRequest("POST", "/", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if !strings.Contains(string(data), "fast") || strings.Contains(string(data), "slow") {
+		t.Fatalf("body = %s", data)
+	}
+
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	if strings.Join(order.steps, ",") != "process,adapter,global" {
+		t.Fatalf("order = %v", order.steps)
+	}
+}
+
+func TestHedgeEmpty(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	vip := viper.New()
+	vip.Set("hedging.delay", "20ms")
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	order := new(hedgeOrder)
+	response, err := hedgeApp(order, hedgedAdapter{order: order}, hedgedAdapter{order: order}).
+		Test(httptest.NewRequest("POST", "/", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != fiber.StatusBadGateway {
+		t.Fatalf("status = %d", response.StatusCode)
+	}
+}
@@ -0,0 +1,90 @@
+package v1
+
+import (
+	"github.com/bincooo/ago/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	localRequestId = "ago.request-id"
+)
+
+// 请求标识: 沿用客户端的 x-request-id, 否则生成新的标识, 并写入响应头
+func identify(ctx *fiber.Ctx) error {
+	id := ctx.Get("x-request-id")
+	if id == "" || len(id) > 128 {
+		id = uuid.NewString()
+	} else {
+		id = string([]byte(id))
+	}
+
+	ctx.Locals(localRequestId, id)
+	ctx.Set("x-request-id", id)
+	return ctx.Next()
+}
+
+func requestIdOf(ctx *fiber.Ctx) string {
+	id, _ := ctx.Locals(localRequestId).(string)
+	return id
+}
+
+// 访问日志附带请求与追踪标识
+func logFields(ctx *fiber.Ctx) (fields []zap.Field) {
+	if id := requestIdOf(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx.UserContext()); spanContext.IsValid() {
+		fields = append(fields, zap.String("trace_id", spanContext.TraceID().String()))
+	}
+	return
+}
+
+func getLogLevel(ctx *fiber.Ctx) error {
+	packages := make(map[string]string)
+	for pkg, level := range logger.PackageLevels() {
+		packages[pkg] = level.String()
+	}
+	return ctx.JSON(fiber.Map{"level": logger.GetLevel().String(), "packages": packages})
+}
+
+// 运行时修改日志级别, packages 不为空时替换按包覆盖的级别
+//
+//	PUT /admin/log-level  {"level": "debug", "packages": {"internal/v1": "debug"}}
+func setLogLevel(ctx *fiber.Ctx) error {
+	var body struct {
+		Level    string            `json:"level"`
+		Packages map[string]string `json:"packages"`
+	}
+	if err := ctx.BodyParser(&body); err != nil {
+		return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_body", err.Error())
+	}
+
+	var global logger.Level
+	if body.Level != "" {
+		var err error
+		if global, err = logger.ParseLevel(body.Level); err != nil {
+			return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_level", err.Error())
+		}
+	}
+
+	packages := make(map[string]logger.Level, len(body.Packages))
+	for pkg, text := range body.Packages {
+		level, err := logger.ParseLevel(text)
+		if err != nil {
+			return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_level", err.Error())
+		}
+		packages[pkg] = level
+	}
+
+	if body.Level != "" {
+		logger.SetLevel(global)
+	}
+	if body.Packages != nil {
+		logger.SetPackageLevels(packages)
+	}
+	logger.Sugar().Infof("admin: log level changed to %s", logger.GetLevel())
+	return getLogLevel(ctx)
+}
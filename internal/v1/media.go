@@ -0,0 +1,531 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	errImagePixels = errors.New("image is too large")
+	errImageHost   = errors.New("image host is not allowed")
+
+	mediaClients   = make(map[string]*http.Client)
+	mediaClientMu  sync.Mutex
+	mediaPruneOnce sync.Once
+)
+
+// 多模态输入归一化, 仅对声明了图片偏好 (media) 的适配器与 media.models 匹配的模型开启
+//
+//	media:
+//	  enabled: true           # 为 false 时全部关闭
+//	  models: [ "gpt-4o*" ]   # 需要归一化的模型, 支持通配符, 为空时仅按适配器声明
+//	  fetch: false            # 总是下载远程图片, 否则仅在适配器需要 data URI 时下载
+//	  proxies: ""
+//	  timeout: 30s
+//	  max-bytes: 20971520
+//	  max-dimension: 2048     # 最长边超出时缩小
+//	  max-pixels: 40000000    # 宽 × 高超出时拒绝, 避免解码时占用过多内存
+//	  allow-hosts: []         # 允许下载的域名, 支持通配符, 为空时不限制
+//	  allow-private: false    # 允许下载内网、回环与链路本地地址
+//	  cache-dir: tmp/media
+//	  cache-ttl: 24h
+//	  cache-max-bytes: 536870912
+type mediaOptions struct {
+	fetch        bool
+	inline       bool
+	maxBytes     int
+	maxDimension int
+	maxPixels    int64
+}
+
+// 解析消息中的图片: 下载远程地址、解码 data URI、限制大小并缩小过大的图片,
+// 按适配器偏好改写内容段, 并以 model.ImagesOf 提供统一的图片列表
+func normalizeMedia(c *model.Ctx, completion *model.Completion, adapter model.Adapter) (err error) {
+	var preference string
+	if preferred, ok := adapter.(interface{ Media() string }); ok {
+		preference = preferred.Media()
+	}
+	if !mediaEnabled(preference, completion.Model) {
+		return
+	}
+
+	opts := mediaOptions{maxBytes: 20 << 20, maxDimension: 2048, maxPixels: 40_000_000}
+	opts.inline = preference == "data-uri"
+	if Env != nil {
+		opts.fetch = Env.GetBool("media.fetch")
+		if n := Env.GetInt("media.max-bytes"); n > 0 {
+			opts.maxBytes = n
+		}
+		if n := Env.GetInt("media.max-dimension"); n > 0 {
+			opts.maxDimension = n
+		}
+		if n := Env.GetInt64("media.max-pixels"); n > 0 {
+			opts.maxPixels = n
+		}
+	}
+
+	var images []model.Image
+	for i, message := range completion.Messages {
+		if _, ok := message["content"].(string); ok {
+			continue
+		}
+
+		parts := model.PartsOf(message)
+		changed := false
+		for j, part := range parts {
+			img, ok := imageOf(part)
+			if !ok {
+				continue
+			}
+
+			img.Message, img.Part = i, j
+			embedded := strings.HasPrefix(img.URL, "data:")
+			resized, e := resolveImage(c.Context(), &img, opts)
+			if e != nil {
+				return e
+			}
+
+			if (opts.inline && len(img.Data) > 0) || (embedded && resized) {
+				parts[j] = inlineImage(part, img)
+				changed = true
+			}
+			images = append(images, img)
+		}
+
+		if changed {
+			message["content"] = parts
+		}
+	}
+
+	if len(images) > 0 {
+		c.Put("images", images)
+	}
+	return
+}
+
+// 适配器声明了图片偏好, 或模型匹配 media.models 时归一化
+func mediaEnabled(preference, mod string) bool {
+	if Env != nil && Env.IsSet("media.enabled") && !Env.GetBool("media.enabled") {
+		return false
+	}
+	if preference != "" {
+		return true
+	}
+	if Env == nil {
+		return false
+	}
+
+	for _, pattern := range Env.GetStringSlice("media.models") {
+		if ok, _ := path.Match(pattern, mod); ok || pattern == mod {
+			return true
+		}
+	}
+	return false
+}
+
+// 兼容 OpenAI 的 image_url 与 Anthropic 的 image 内容段
+func imageOf(part model.Record[string, any]) (img model.Image, ok bool) {
+	switch part["type"] {
+	case "image_url":
+		switch v := part["image_url"].(type) {
+		case string:
+			img.URL = v
+		case map[string]interface{}:
+			img.URL, _ = v["url"].(string)
+			img.Detail, _ = v["detail"].(string)
+		}
+		return img, img.URL != ""
+
+	case "image":
+		source, _ := part["source"].(map[string]interface{})
+		switch source["type"] {
+		case "base64":
+			img.MIME, _ = source["media_type"].(string)
+			data, _ := source["data"].(string)
+			img.URL = "data:" + img.MIME + ";base64," + data
+		case "url":
+			img.URL, _ = source["url"].(string)
+		}
+		return img, img.URL != ""
+	}
+	return
+}
+
+// 以 data URI 改写内容段
+func inlineImage(part model.Record[string, any], img model.Image) model.Record[string, any] {
+	if part["type"] == "image" {
+		return model.Record[string, any]{
+			"type": "image",
+			"source": map[string]interface{}{
+				"type":       "base64",
+				"media_type": img.MIME,
+				"data":       img.Base64(),
+			},
+		}
+	}
+
+	imageUrl := map[string]interface{}{"url": img.DataURI()}
+	if img.Detail != "" {
+		imageUrl["detail"] = img.Detail
+	}
+	return model.Record[string, any]{"type": "image_url", "image_url": imageUrl}
+}
+
+// 解析图片内容, resized 表示已缩小
+func resolveImage(ctx context.Context, img *model.Image, opts mediaOptions) (resized bool, err error) {
+	if strings.HasPrefix(img.URL, "data:") {
+		if img.MIME, img.Data, err = decodeDataURI(img.URL); err != nil {
+			return false, fmt.Errorf("invalid image data URI: %v", err)
+		}
+		img.URL = ""
+	} else if opts.fetch || opts.inline {
+		if img.Data, err = fetchImage(ctx, img.URL, opts.maxBytes); err != nil {
+			return false, fmt.Errorf("fetch image [%s] failed: %v", img.URL, err)
+		}
+		img.MIME = http.DetectContentType(img.Data)
+	} else {
+		return
+	}
+
+	if !strings.HasPrefix(img.MIME, "image/") {
+		img.MIME = http.DetectContentType(img.Data)
+	}
+
+	data, mime, err := downscale(img.Data, opts.maxDimension, opts.maxPixels)
+	if errors.Is(err, errImagePixels) {
+		return false, err
+	} else if err != nil {
+		logger.Sugar().Warnf("media: decode image failed: %v", err)
+		err = nil
+	} else if data != nil {
+		img.Data, img.MIME = data, mime
+		resized = true
+	}
+
+	if len(img.Data) > opts.maxBytes {
+		return resized, fmt.Errorf("image exceeds the %d bytes limit", opts.maxBytes)
+	}
+	return
+}
+
+// 解码 data URI, 返回 MIME 与内容
+func decodeDataURI(uri string) (mime string, data []byte, err error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok {
+		return "", nil, errors.New("missing payload")
+	}
+
+	mime, _, _ = strings.Cut(header, ";")
+	if strings.HasSuffix(header, ";base64") {
+		data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+		return
+	}
+
+	unescaped, err := url.PathUnescape(payload)
+	return mime, []byte(unescaped), err
+}
+
+// 下载远程图片, 以地址摘要缓存到本地
+func fetchImage(ctx context.Context, uri string, maxBytes int) (data []byte, err error) {
+	dir, ttl := "tmp/media", 24*time.Hour
+	if Env != nil {
+		if d := Env.GetString("media.cache-dir"); d != "" {
+			dir = d
+		}
+		if t := Env.GetDuration("media.cache-ttl"); t > 0 {
+			ttl = t
+		}
+	}
+
+	hash := sha256.Sum256([]byte(uri))
+	key := hex.EncodeToString(hash[:])
+	file := filepath.Join(dir, key[:2], key)
+	if info, e := os.Stat(file); e == nil && time.Since(info.ModTime()) < ttl {
+		if data, e = os.ReadFile(file); e == nil {
+			return
+		}
+	}
+
+	timeout := 30 * time.Second
+	if Env != nil {
+		if t := Env.GetDuration("media.timeout"); t > 0 {
+			timeout = t
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return
+	}
+
+	mediaPruneOnce.Do(initMedia)
+	response, err := mediaClient().Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", response.Status)
+	}
+
+	data, err = io.ReadAll(io.LimitReader(response.Body, int64(maxBytes)+1))
+	if err != nil {
+		return
+	}
+	if len(data) > maxBytes {
+		return nil, fmt.Errorf("image exceeds the %d bytes limit", maxBytes)
+	}
+
+	if e := writeFile(file, data); e != nil {
+		logger.Sugar().Warnf("media: cache image failed: %v", e)
+	}
+	return
+}
+
+// 最长边超出限制时等比缩小, 未缩小时返回 nil; 像素数超出限制时在解码前拒绝
+func downscale(data []byte, maxDimension int, maxPixels int64) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, "", fmt.Errorf("%w: %dx%d exceeds %d pixels", errImagePixels, config.Width, config.Height, maxPixels)
+	}
+
+	longest := max(config.Width, config.Height)
+	if longest <= maxDimension {
+		return nil, "", nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	width := config.Width * maxDimension / longest
+	height := config.Height * maxDimension / longest
+	dst := image.NewRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buffer bytes.Buffer
+	if format == "png" || format == "gif" {
+		err = png.Encode(&buffer, dst)
+		return buffer.Bytes(), "image/png", err
+	}
+
+	err = jpeg.Encode(&buffer, dst, &jpeg.Options{Quality: 90})
+	return buffer.Bytes(), "image/jpeg", err
+}
+
+// 下载图片的客户端, 按代理配置复用: 校验域名白名单, 请求前解析域名拒绝内网地址, 重定向时重新校验.
+// TLS 连接由指纹传输层建立, 直连的 http 请求另在建立连接时校验实际连接的地址
+func mediaClient() *http.Client {
+	proxies := ""
+	if Env != nil {
+		proxies = Env.GetString("media.proxies")
+	}
+
+	mediaClientMu.Lock()
+	defer mediaClientMu.Unlock()
+	if client, ok := mediaClients[proxies]; ok {
+		return client
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if proxies == "" {
+		dialer := &net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				addr, err := netip.ParseAddr(host)
+				if err != nil {
+					return err
+				}
+				return publicAddr(addr)
+			},
+		}
+		base.DialContext = dialer.DialContext
+	}
+
+	check := func(request *http.Request) error {
+		if err := allowedHost(request.URL); err != nil {
+			return err
+		}
+		return resolvePublic(request.Context(), request.URL.Hostname())
+	}
+
+	client := &http.Client{
+		Transport: checkedTransport{newTransport(proxies, base), check},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("stopped after 5 redirects")
+			}
+			return nil
+		},
+	}
+	mediaClients[proxies] = client
+	return client
+}
+
+// 每次请求 (含重定向) 前校验
+type checkedTransport struct {
+	http.RoundTripper
+	check func(*http.Request) error
+}
+
+func (transport checkedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := transport.check(request); err != nil {
+		return nil, err
+	}
+	return transport.RoundTripper.RoundTrip(request)
+}
+
+func allowedHost(uri *url.URL) error {
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", errImageHost, uri.Scheme)
+	}
+
+	var hosts []string
+	if Env != nil {
+		hosts = Env.GetStringSlice("media.allow-hosts")
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	host := strings.ToLower(uri.Hostname())
+	for _, pattern := range hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errImageHost, host)
+}
+
+// 解析域名, 任一地址为内网地址时拒绝
+func resolvePublic(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err = publicAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// 回环、RFC1918、链路本地 (含 169.254.169.254)、IPv6 ULA 等地址需显式开启 media.allow-private
+func publicAddr(addr netip.Addr) error {
+	if Env != nil && Env.GetBool("media.allow-private") {
+		return nil
+	}
+
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("%w: %s is a private address", errImageHost, addr)
+	}
+	return nil
+}
+
+// 首次下载图片时开始定期清理图片缓存: 删除过期文件, 总大小超出 media.cache-max-bytes 时从最旧的开始删除
+func initMedia() {
+	go func() {
+		for {
+			pruneMedia()
+			time.Sleep(10 * time.Minute)
+		}
+	}()
+}
+
+func pruneMedia() {
+	dir, ttl, maxBytes := "tmp/media", 24*time.Hour, int64(512<<20)
+	if Env != nil {
+		if d := Env.GetString("media.cache-dir"); d != "" {
+			dir = d
+		}
+		if t := Env.GetDuration("media.cache-ttl"); t > 0 {
+			ttl = t
+		}
+		if n := Env.GetInt64("media.cache-max-bytes"); n > 0 {
+			maxBytes = n
+		}
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var files []cached
+	var total int64
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if time.Since(info.ModTime()) >= ttl {
+			_ = os.Remove(p)
+			return nil
+		}
+		files = append(files, cached{p, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, file := range files {
+		if total <= maxBytes {
+			break
+		}
+		if os.Remove(file.path) == nil {
+			total -= file.size
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/bincooo/ago/model"
+	"github.com/spf13/viper"
+)
+
+// 声明需要 data URI 的适配器
+type mediaAdapter struct{ windowAdapter }
+
+func (mediaAdapter) Media() string { return "data-uri" }
+
+func encodeImage(t *testing.T, format string, width, height int) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.White, color.Black})
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buffer, img)
+	case "jpeg":
+		err = jpeg.Encode(&buffer, img, nil)
+	case "gif":
+		err = gif.Encode(&buffer, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestPublicAddr(t *testing.T) {
+	cases := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tc := range cases {
+		err := publicAddr(netip.MustParseAddr(tc.addr))
+		if (err == nil) != tc.public {
+			t.Errorf("publicAddr(%s) = %v", tc.addr, err)
+		}
+	}
+
+	vip := viper.New()
+	vip.Set("media.allow-private", true)
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+	if err := publicAddr(netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("allow-private should allow loopback: %v", err)
+	}
+}
+
+func TestAllowedHost(t *testing.T) {
+	vip := viper.New()
+	vip.Set("media.allow-hosts", []string{"*.example.com", "cdn.test"})
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	cases := []struct {
+		uri     string
+		allowed bool
+	}{
+		{"https://img.example.com/a.png", true},
+		{"http://CDN.test/a.png", true},
+		{"https://example.org/a.png", false},
+		{"file:///etc/passwd", false},
+		{"gopher://img.example.com/", false},
+	}
+
+	for _, tc := range cases {
+		uri, _ := url.Parse(tc.uri)
+		if err := allowedHost(uri); (err == nil) != tc.allowed {
+			t.Errorf("allowedHost(%s) = %v", tc.uri, err)
+		}
+	}
+}
+
+func TestFetchImagePrivate(t *testing.T) {
+	data := encodeImage(t, "png", 2, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	// 不启动缓存清理
+	mediaPruneOnce.Do(func() {})
+	vip := viper.New()
+	vip.Set("media.cache-dir", t.TempDir())
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	if _, err := fetchImage(context.Background(), server.URL+"/a.png", 1<<20); !errors.Is(err, errImageHost) {
+		t.Fatalf("loopback should be rejected: %v", err)
+	}
+
+	vip.Set("media.allow-private", true)
+	fetched, err := fetchImage(context.Background(), server.URL+"/b.png", 1<<20)
+	if err != nil || !bytes.Equal(fetched, data) {
+		t.Fatalf("allow-private fetch failed: %v", err)
+	}
+
+	// 重定向到的地址同样校验
+	vip.Set("media.allow-private", false)
+	vip.Set("media.allow-hosts", []string{"127.0.0.1"})
+	if _, err = fetchImage(context.Background(), server.URL+"/redirect", 1<<20); !errors.Is(err, errImageHost) {
+		t.Fatalf("redirect should be rejected: %v", err)
+	}
+
+	if mediaClient() != mediaClient() {
+		t.Fatal("media client should be shared")
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	cases := []struct {
+		name      string
+		format    string
+		width     int
+		height    int
+		mime      string
+		resized   bool
+		tooLarge  bool
+		outWidth  int
+		outHeight int
+	}{
+		{"small png", "png", 100, 50, "", false, false, 0, 0},
+		{"large png", "png", 400, 200, "image/png", true, false, 200, 100},
+		{"large jpeg", "jpeg", 200, 400, "image/jpeg", true, false, 100, 200},
+		{"large gif", "gif", 400, 400, "image/png", true, false, 200, 200},
+		{"too many pixels", "png", 1000, 1000, "", false, true, 0, 0},
+	}
+
+	for _, tc := range cases {
+		data, mime, err := downscale(encodeImage(t, tc.format, tc.width, tc.height), 200, 500_000)
+		if tc.tooLarge {
+			if !errors.Is(err, errImagePixels) {
+				t.Errorf("%s: err = %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if (data != nil) != tc.resized || mime != tc.mime {
+			t.Errorf("%s: resized = %v, mime = %q", tc.name, data != nil, mime)
+			continue
+		}
+		if data == nil {
+			continue
+		}
+
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || config.Width != tc.outWidth || config.Height != tc.outHeight {
+			t.Errorf("%s: %dx%d, err = %v", tc.name, config.Width, config.Height, err)
+		}
+	}
+}
+
+func TestDecodeDataURI(t *testing.T) {
+	cases := []struct {
+		uri  string
+		mime string
+		data string
+		ok   bool
+	}{
+		{"data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("abc")), "image/png", "abc", true},
+		{"data:image/png;base64," + base64.RawStdEncoding.EncodeToString([]byte("abcd")), "image/png", "abcd", true},
+		{"data:text/plain,a%20b", "text/plain", "a b", true},
+		{"data:image/png;base64", "", "", false},
+		{"data:image/png;base64,!!", "", "", false},
+	}
+
+	for _, tc := range cases {
+		mime, data, err := decodeDataURI(tc.uri)
+		if (err == nil) != tc.ok {
+			t.Errorf("decodeDataURI(%q) err = %v", tc.uri, err)
+			continue
+		}
+		if tc.ok && (mime != tc.mime || string(data) != tc.data) {
+			t.Errorf("decodeDataURI(%q) = %q, %q", tc.uri, mime, data)
+		}
+	}
+}
+
+func TestNormalizeMedia(t *testing.T) {
+	large := "data:image/png;base64," + base64.StdEncoding.EncodeToString(encodeImage(t, "png", 4000, 10))
+	request := func() *model.Completion {
+		return &model.Completion{Model: "m", Messages: []model.CompletionMessage{
+			{"role": "user", "content": []interface{}{
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": large}},
+			}},
+		}}
+	}
+
+	vip := viper.New()
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	cases := []struct {
+		name    string
+		models  []string
+		adapter model.Adapter
+		images  int
+	}{
+		// 默认不归一化, 由模型或适配器声明开启
+		{"off by default", nil, windowAdapter{}, 0},
+		{"model opt-in", []string{"m*"}, windowAdapter{}, 1},
+		{"other model", []string{"x"}, windowAdapter{}, 0},
+		{"adapter preference", nil, mediaAdapter{}, 1},
+	}
+
+	for _, tc := range cases {
+		vip.Set("media.models", tc.models)
+		c := conversationCtx(t, "")
+		completion := request()
+		if err := normalizeMedia(c, completion, tc.adapter); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		images := model.ImagesOf(c)
+		if len(images) != tc.images {
+			t.Fatalf("%s: images = %d", tc.name, len(images))
+		}
+		part := model.PartsOf(completion.Messages[0])[0]
+		url := part["image_url"].(map[string]interface{})["url"].(string)
+		if (url != large) != (tc.images > 0) {
+			t.Errorf("%s: oversized image should only be rewritten when normalized", tc.name)
+		}
+	}
+}
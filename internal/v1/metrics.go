@@ -0,0 +1,375 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	localMetrics = "ago.metrics"
+)
+
+// Prometheus 指标, 默认关闭; 开启后指标路径不经过鉴权, 应仅在内网暴露.
+// 模型标签取适配器声明的模型, 未声明的模型记为 unknown, 避免客户端传入任意名称产生无限的序列
+//
+//	metrics:
+//	  enabled: true
+//	  path: /metrics
+var (
+	registry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ago",
+		Name:      "requests_total",
+		Help:      "Relay requests by type, model, adapter and HTTP status.",
+	}, []string{"type", "model", "adapter", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ago",
+		Name:      "request_duration_seconds",
+		Help:      "Total request duration, including the streamed response.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"type", "model", "adapter"})
+
+	firstToken = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ago",
+		Name:      "time_to_first_token_seconds",
+		Help:      "Time until the first streamed chunk is written.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"model", "adapter"})
+
+	tokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ago",
+		Name:      "tokens_total",
+		Help:      "Tokens reported in usage, by direction (input or output).",
+	}, []string{"model", "adapter", "direction"})
+
+	tokenRate = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ago",
+		Name:      "output_tokens_per_second",
+		Help:      "Output tokens per second of generation time.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"model", "adapter"})
+
+	inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ago",
+		Name:      "requests_in_flight",
+		Help:      "Requests currently being processed.",
+	}, []string{"type"})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ago",
+		Name:      "queue_depth",
+		Help:      "Accepted requests not yet dispatched to an adapter.",
+	}, []string{"type"})
+
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ago",
+		Name:      "upstream_errors_total",
+		Help:      "Upstream failures by error class.",
+	}, []string{"model", "adapter", "class"})
+
+	browsers = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "ago",
+		Name:      "browser_instances",
+		Help:      "Browser instances currently running.",
+	}, func() float64 { return float64(model.Browsers()) })
+
+	credentialDesc = prometheus.NewDesc("ago_credentials", "Credentials in each pool by status.",
+		[]string{"pool", "status"}, nil)
+
+	statusCode = regexp.MustCompile(`\b([45]\d\d)\b`)
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal, requestDuration, firstToken, tokensTotal, tokenRate,
+		inFlight, queueDepth, upstreamErrors, browsers,
+		credentialCollector{},
+	)
+}
+
+// 单个请求的指标状态
+type requestMetrics struct {
+	mu sync.Mutex
+
+	typ       string
+	requested string
+	model     string
+	adapter   string
+	start     time.Time
+	first     time.Duration
+	output    int64
+
+	dispatched bool
+	failed     bool
+	done       bool
+}
+
+func metricsEnabled() bool {
+	return Env != nil && Env.GetBool("metrics.enabled")
+}
+
+func metricsPath() string {
+	if Env != nil && Env.GetString("metrics.path") != "" {
+		return Env.GetString("metrics.path")
+	}
+	return "/metrics"
+}
+
+func metricsRoutes(app *fiber.App) {
+	if !metricsEnabled() {
+		return
+	}
+	app.Get(metricsPath(), adaptor.HTTPHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+}
+
+// 记录请求指标, 流式响应在结束信号时完成统计
+func observe(c *model.Ctx, mod string) {
+	if !metricsEnabled() {
+		return
+	}
+
+	m := &requestMetrics{
+		typ:       c.Type,
+		requested: mod,
+		model:     "unknown",
+		start:     time.Now(),
+	}
+	c.Ctx().Locals(localMetrics, m)
+	inFlight.WithLabelValues(m.typ).Inc()
+	queueDepth.WithLabelValues(m.typ).Inc()
+
+	c.InterceptAt(model.StageCapture, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if usage, ok := model.UsageOf(msg); ok {
+			m.usage(usage)
+		}
+
+		switch v := msg.(type) {
+		case error:
+			if v != io.EOF {
+				m.fail(v)
+			}
+		default:
+			if msg == model.Flush {
+				defer m.finish(fiber.StatusOK, true)
+			} else {
+				m.touch()
+			}
+		}
+		return next(msg)
+	})
+}
+
+// 非流式响应在处理函数返回后完成统计
+func measure(ctx *fiber.Ctx) (err error) {
+	defer func() {
+		m, ok := ctx.Locals(localMetrics).(*requestMetrics)
+		if !ok || ctx.Response().IsBodyStream() {
+			return
+		}
+
+		status := ctx.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var e *fiber.Error
+			if errors.As(err, &e) {
+				status = e.Code
+			}
+			m.fail(err)
+		}
+
+		if status >= fiber.StatusInternalServerError {
+			class := "server"
+			if status == fiber.StatusServiceUnavailable {
+				class = "unavailable"
+			}
+			m.failWith(class)
+		}
+		m.finish(status, false)
+	}()
+	return ctx.Next()
+}
+
+// 记录分发的适配器, 并写入请求级日志字段、审计记录、用量统计与面板
+func dispatched(c *model.Ctx, entry registered) {
+	c.Annotate("adapter", entry.Id)
+	auditDispatched(c, entry)
+	usageDispatched(c, entry)
+	activityDispatched(c, entry)
+	m, ok := c.Ctx().Locals(localMetrics).(*requestMetrics)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dispatched {
+		return
+	}
+	m.dispatched = true
+	m.adapter = entry.Id
+	m.model = declaredModel(entry.Adapter, m.requested)
+	queueDepth.WithLabelValues(m.typ).Dec()
+}
+
+// 适配器声明的模型 (支持通配符), 未声明时为 unknown
+func declaredModel(adapter model.Adapter, mod string) string {
+	for _, declared := range adapter.Model() {
+		if declared.Id == mod {
+			return mod
+		}
+	}
+	for _, declared := range adapter.Model() {
+		if ok, _ := path.Match(declared.Id, mod); ok {
+			return declared.Id
+		}
+	}
+	return "unknown"
+}
+
+func (m *requestMetrics) labels() (string, string) {
+	adapter := m.adapter
+	if adapter == "" {
+		adapter = "none"
+	}
+	return m.model, adapter
+}
+
+func (m *requestMetrics) touch() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.first == 0 {
+		m.first = time.Since(m.start)
+	}
+}
+
+func (m *requestMetrics) usage(usage model.ResponseUsage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mod, adapter := m.labels()
+	tokensTotal.WithLabelValues(mod, adapter, "input").Add(float64(usage.Prompt()))
+	tokensTotal.WithLabelValues(mod, adapter, "output").Add(float64(usage.Completion()))
+	m.output += usage.Completion()
+}
+
+func (m *requestMetrics) fail(err error) {
+	m.failWith(errorClass(err))
+}
+
+// 每个请求只计入一次上游错误
+func (m *requestMetrics) failWith(class string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed || m.done {
+		return
+	}
+	m.failed = true
+	mod, adapter := m.labels()
+	upstreamErrors.WithLabelValues(mod, adapter, class).Inc()
+}
+
+func (m *requestMetrics) finish(status int, streamed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done {
+		return
+	}
+	m.done = true
+
+	mod, adapter := m.labels()
+	elapsed := time.Since(m.start)
+	requestsTotal.WithLabelValues(m.typ, mod, adapter, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(m.typ, mod, adapter).Observe(elapsed.Seconds())
+	inFlight.WithLabelValues(m.typ).Dec()
+	if !m.dispatched {
+		queueDepth.WithLabelValues(m.typ).Dec()
+	}
+
+	generation := elapsed
+	if streamed && m.first > 0 {
+		firstToken.WithLabelValues(mod, adapter).Observe(m.first.Seconds())
+		generation -= m.first
+	}
+	if m.output > 0 && generation > 0 {
+		tokenRate.WithLabelValues(mod, adapter).Observe(float64(m.output) / generation.Seconds())
+	}
+}
+
+// 上游错误分类
+func errorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, errNoCredential):
+		return "no_credential"
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+
+	msg := strings.ToLower(err.Error())
+	if match := statusCode.FindStringSubmatch(msg); match != nil {
+		switch code := match[1]; {
+		case code == "429":
+			return "rate_limit"
+		case code == "401" || code == "403":
+			return "auth"
+		case code[0] == '5':
+			return "server"
+		default:
+			return "client"
+		}
+	}
+
+	switch {
+	case strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests"):
+		return "rate_limit"
+	case strings.Contains(msg, "timeout") || strings.Contains(msg, "timed out"):
+		return "timeout"
+	case strings.Contains(msg, "unauthorized") || strings.Contains(msg, "forbidden"):
+		return "auth"
+	}
+	return "other"
+}
+
+// 凭证池状态, 采集时读取
+type credentialCollector struct{}
+
+func (credentialCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- credentialDesc
+}
+
+func (credentialCollector) Collect(ch chan<- prometheus.Metric) {
+	for pool, states := range credentials.list() {
+		counts := make(map[string]int)
+		for _, state := range states {
+			counts[state.Status]++
+		}
+		for status, n := range counts {
+			ch <- prometheus.MustNewConstMetric(credentialDesc, prometheus.GaugeValue, float64(n), pool, status)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err   error
+		class string
+	}{
+		{context.DeadlineExceeded, "timeout"},
+		{fmt.Errorf("relay: %w", context.Canceled), "canceled"},
+		{errNoCredential, "no_credential"},
+		{errors.New("status 429"), "rate_limit"},
+		{errors.New("upstream returned 401"), "auth"},
+		{errors.New("upstream returned 502 bad gateway"), "server"},
+		{errors.New("status 404"), "client"},
+		{errors.New("Too Many Requests"), "rate_limit"},
+		{errors.New("request timed out"), "timeout"},
+		{errors.New("forbidden"), "auth"},
+		{errors.New("boom"), "other"},
+	}
+
+	for _, tc := range cases {
+		if got := errorClass(tc.err); got != tc.class {
+			t.Errorf("errorClass(%v) = %s, want %s", tc.err, got, tc.class)
+		}
+	}
+}
+
+func TestDeclaredModel(t *testing.T) {
+	adapter := patternAdapter{ids: []string{"gpt-4o", "claude-*"}}
+	cases := []struct {
+		mod   string
+		label string
+	}{
+		{"gpt-4o", "gpt-4o"},
+		{"claude-3-opus", "claude-*"},
+		{"anything-the-client-sends", "unknown"},
+	}
+
+	for _, tc := range cases {
+		if got := declaredModel(adapter, tc.mod); got != tc.label {
+			t.Errorf("declaredModel(%s) = %s, want %s", tc.mod, got, tc.label)
+		}
+	}
+}
+
+// 声明多个模型的适配器
+type patternAdapter struct {
+	model.BasicAdapter
+	ids []string
+}
+
+func (patternAdapter) Support(*model.Ctx, string) bool { return true }
+
+func (adapter patternAdapter) Model() (models []model.Model) {
+	for _, id := range adapter.ids {
+		models = append(models, model.Model{Id: id})
+	}
+	return
+}
+
+func scrape(t *testing.T, app *fiber.App) (int, string) {
+	response, err := app.Test(httptest.NewRequest("GET", "/metrics", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(data)
+}
+
+func TestMetricsRoutes(t *testing.T) {
+	app := fiber.New()
+	metricsRoutes(app)
+	if status, _ := scrape(t, app); status != fiber.StatusNotFound {
+		t.Fatalf("metrics should be disabled by default, status = %d", status)
+	}
+	ctx := conversationCtx(t, "").Ctx()
+	ctx.Path("/metrics")
+	if exempt(ctx) {
+		t.Fatal("disabled metrics path should not be exempt")
+	}
+
+	vip := viper.New()
+	vip.Set("metrics.enabled", true)
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	requestsTotal.Reset()
+	app = fiber.New()
+	metricsRoutes(app)
+	for _, mod := range []string{"gpt-4o", "random-1", "random-2"} {
+		c := conversationCtx(t, "")
+		c.Type = "metrics-test"
+		observe(c, mod)
+		dispatched(c, entryOf(patternAdapter{ids: []string{"gpt-4o"}}))
+		c.Ctx().Locals(localMetrics).(*requestMetrics).finish(fiber.StatusOK, false)
+	}
+
+	status, body := scrape(t, app)
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d", status)
+	}
+	for _, want := range []string{
+		`ago_requests_total{adapter="v1.patternAdapter",model="gpt-4o",status="200",type="metrics-test"} 1`,
+		`ago_requests_total{adapter="v1.patternAdapter",model="unknown",status="200",type="metrics-test"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(body, "random-1") {
+		t.Fatal("undeclared models should not be used as labels")
+	}
+}
@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	befores []func(*model.Ctx) error
+	afters  []model.Interceptor
+	mwMu    sync.RWMutex
+)
+
+// 添加全局前置中间件, 解析请求后、选择适配器前执行
+func AddBefore(before ...func(*model.Ctx) error) {
+	mwMu.Lock()
+	defer mwMu.Unlock()
+	befores = append(befores, before...)
+}
+
+// 添加全局后置中间件, 在适配器中间件之后执行
+func AddAfter(after ...model.Interceptor) {
+	mwMu.Lock()
+	defer mwMu.Unlock()
+	afters = append(afters, after...)
+}
+
+// 执行全局中间件, handled 为 true 时已写出响应
+//
+// 执行顺序: 全局 Before -> 适配器 Before -> 适配器处理;
+// 写出的消息依次经过: 核心输出处理 -> 适配器 After -> 全局 After -> 用量计量 -> 缓存与审计
+func middleware(c *model.Ctx) (handled bool, err error) {
+	mwMu.RLock()
+	list, interceptors := befores, afters
+	mwMu.RUnlock()
+
+	c.InterceptAt(model.StageGlobal, interceptors...)
+	for _, before := range list {
+		if err = before(c); err == nil {
+			continue
+		}
+
+		if errors.Is(err, model.ErrHandled) {
+			return true, nil
+		}
+
+		status := fiber.StatusBadRequest
+		var e *fiber.Error
+		if errors.As(err, &e) {
+			status = e.Code
+		}
+		return true, writeErrorf(c.Ctx(), status, "invalid_request_error", "middleware_rejected", err.Error())
+	}
+	return
+}
@@ -0,0 +1,301 @@
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/spf13/viper"
+)
+
+var (
+	prompts = &promptStore{}
+)
+
+// 提示词规则, 按顺序对匹配的请求生效, 配置文件修改后自动重新加载
+//
+//	prompt-rules:
+//	  - models: [ "gpt-*" ]
+//	    keys: [ "team-a" ]        # 客户端密钥或其名称
+//	    system:
+//	      mode: prepend           # prepend | append | replace
+//	      content: "Today is {{.Date}}, you are {{.Model}}."
+//	    roles: { developer: system }
+//	    merge: true               # 合并相邻的同角色消息
+//	    redact:
+//	      - pattern: "sk-[A-Za-z0-9]{20,}"
+//	        replace: "[REDACTED]"
+//
+// 模版变量: .Date .Time .Model .User
+type promptRule struct {
+	Models []string `mapstructure:"models"`
+	Keys   []string `mapstructure:"keys"`
+	System struct {
+		Mode    string `mapstructure:"mode"`
+		Content string `mapstructure:"content"`
+	} `mapstructure:"system"`
+	Roles  map[string]string `mapstructure:"roles"`
+	Merge  bool              `mapstructure:"merge"`
+	Redact []struct {
+		Pattern string `mapstructure:"pattern"`
+		Replace string `mapstructure:"replace"`
+	} `mapstructure:"redact"`
+
+	tmpl     *template.Template
+	patterns []*regexp.Regexp
+}
+
+type promptStore struct {
+	mu sync.RWMutex
+
+	rules   []*promptRule
+	modTime time.Time
+	checked time.Time
+	loaded  bool
+}
+
+// 匹配模型与客户端密钥
+func (rule *promptRule) match(mod string, key *apiKey) bool {
+	if len(rule.Models) > 0 && !slices.ContainsFunc(rule.Models, func(pattern string) bool {
+		ok, _ := path.Match(pattern, mod)
+		return ok || pattern == mod
+	}) {
+		return false
+	}
+
+	if len(rule.Keys) > 0 {
+		if key == nil {
+			return false
+		}
+		return slices.ContainsFunc(rule.Keys, func(k string) bool {
+			return k == key.Key || (key.Name != "" && k == key.Name)
+		})
+	}
+	return true
+}
+
+// 编译模版与正则
+func (rule *promptRule) compile() (err error) {
+	if rule.System.Content != "" {
+		if rule.tmpl, err = template.New("system").Parse(rule.System.Content); err != nil {
+			return fmt.Errorf("parse system template failed: %v", err)
+		}
+	}
+
+	for _, item := range rule.Redact {
+		re, e := regexp.Compile(item.Pattern)
+		if e != nil {
+			return fmt.Errorf("compile redact pattern [%s] failed: %v", item.Pattern, e)
+		}
+		rule.patterns = append(rule.patterns, re)
+	}
+	return
+}
+
+// 当前生效的规则
+func (store *promptStore) current() []*promptRule {
+	store.reload()
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.rules
+}
+
+// 本地配置文件修改后重新读取 prompt-rules, 远程配置仅在启动时加载
+func (store *promptStore) reload() {
+	store.mu.RLock()
+	fresh := store.loaded && time.Since(store.checked) < 10*time.Second
+	store.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.checked = time.Now()
+
+	vip := Env.Viper
+	var modTime time.Time
+	if file := Env.path; file != "" && !strings.HasPrefix(file, "http://") && !strings.HasPrefix(file, "https://") {
+		if info, err := os.Stat(file); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+
+	if store.loaded && modTime.Equal(store.modTime) {
+		return
+	}
+
+	if store.loaded {
+		config, err := readConfig(Env.path)
+		if err != nil {
+			logger.Sugar().Errorf("prompt-rules: read config failed: %v", err)
+			return
+		}
+
+		vip = viper.New()
+		vip.SetConfigType("yaml")
+		if err = vip.ReadConfig(bytes.NewReader(config)); err != nil {
+			logger.Sugar().Errorf("prompt-rules: parse config failed: %v", err)
+			return
+		}
+	}
+
+	store.loaded = true
+	store.modTime = modTime
+
+	var rules []*promptRule
+	if err := vip.UnmarshalKey("prompt-rules", &rules); err != nil {
+		logger.Sugar().Errorf("prompt-rules: load rules failed: %v", err)
+		return
+	}
+
+	compiled := make([]*promptRule, 0, len(rules))
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			logger.Sugar().Errorf("prompt-rules: rule %d: %v", i, err)
+			continue
+		}
+		compiled = append(compiled, rule)
+	}
+	store.rules = compiled
+}
+
+// 在分发到适配器前按规则改写请求: 角色映射、合并相邻消息、注入系统提示、脱敏
+func rewritePrompt(c *model.Ctx, completion *model.Completion) (err error) {
+	if Env == nil {
+		return
+	}
+
+	key, _ := c.Ctx().Locals(localKey).(*apiKey)
+	for _, rule := range prompts.current() {
+		if !rule.match(completion.Model, key) {
+			continue
+		}
+
+		if len(rule.Roles) > 0 {
+			for _, message := range completion.Messages {
+				if role, ok := rule.Roles[model.RoleOf(message)]; ok {
+					message["role"] = role
+				}
+			}
+		}
+
+		if rule.Merge {
+			completion.Messages = mergeMessages(completion.Messages)
+		}
+
+		if rule.tmpl != nil {
+			user := ""
+			if key != nil {
+				user = key.id()
+			}
+
+			now := time.Now()
+			var buffer strings.Builder
+			if err = rule.tmpl.Execute(&buffer, map[string]interface{}{
+				"Date":  now.Format(time.DateOnly),
+				"Time":  now.Format(time.TimeOnly),
+				"Model": completion.Model,
+				"User":  user,
+			}); err != nil {
+				return fmt.Errorf("prompt-rules: render system prompt failed: %v", err)
+			}
+			injectSystem(completion, rule.System.Mode, buffer.String())
+		}
+
+		for i, re := range rule.patterns {
+			redactMessages(completion, re, rule.Redact[i].Replace)
+		}
+	}
+	return
+}
+
+// 按模式写入系统提示
+func injectSystem(completion *model.Completion, mode, prompt string) {
+	switch mode {
+	case "append":
+		appendSystem(completion, prompt)
+
+	case "replace":
+		completion.System = ""
+		completion.Messages = slices.DeleteFunc(completion.Messages, func(message model.CompletionMessage) bool {
+			return model.RoleOf(message) == "system"
+		})
+		completion.Messages = slices.Insert(completion.Messages, 0, model.CompletionMessage{"role": "system", "content": prompt})
+
+	default:
+		if completion.System != "" {
+			completion.System = prompt + "\n\n" + completion.System
+		} else if len(completion.Messages) > 0 && model.RoleOf(completion.Messages[0]) == "system" {
+			if content, ok := completion.Messages[0]["content"].(string); ok {
+				completion.Messages[0]["content"] = prompt + "\n\n" + content
+				return
+			}
+			completion.Messages = slices.Insert(completion.Messages, 0, model.CompletionMessage{"role": "system", "content": prompt})
+		} else {
+			completion.Messages = slices.Insert(completion.Messages, 0, model.CompletionMessage{"role": "system", "content": prompt})
+		}
+	}
+}
+
+// 合并相邻的同角色消息, 工具调用与工具结果保持原样
+func mergeMessages(messages []model.CompletionMessage) (merged []model.CompletionMessage) {
+	mergeable := func(message model.CompletionMessage) bool {
+		role := model.RoleOf(message)
+		return role != "tool" && len(model.ToolCallsOf(message)) == 0 && message["name"] == nil
+	}
+
+	for _, message := range messages {
+		if len(merged) == 0 {
+			merged = append(merged, message)
+			continue
+		}
+
+		last := merged[len(merged)-1]
+		if model.RoleOf(last) != model.RoleOf(message) || !mergeable(last) || !mergeable(message) {
+			merged = append(merged, message)
+			continue
+		}
+
+		prev, ok1 := last["content"].(string)
+		next, ok2 := message["content"].(string)
+		if ok1 && ok2 {
+			last["content"] = prev + "\n\n" + next
+			continue
+		}
+		last["content"] = append(model.PartsOf(last), model.PartsOf(message)...)
+	}
+	return
+}
+
+// 替换系统提示与消息文本中的匹配内容
+func redactMessages(completion *model.Completion, re *regexp.Regexp, replace string) {
+	completion.System = re.ReplaceAllString(completion.System, replace)
+	for _, message := range completion.Messages {
+		if content, ok := message["content"].(string); ok {
+			message["content"] = re.ReplaceAllString(content, replace)
+			continue
+		}
+
+		parts := model.PartsOf(message)
+		if len(parts) == 0 {
+			continue
+		}
+		for _, part := range parts {
+			if text, ok := part["text"].(string); ok && part["type"] == "text" {
+				part["text"] = re.ReplaceAllString(text, replace)
+			}
+		}
+		message["content"] = parts
+	}
+}
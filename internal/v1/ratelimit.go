@@ -0,0 +1,425 @@
+package v1
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	localLimits = "ago.rate-limits"
+
+	limitWindow = time.Minute
+)
+
+var (
+	limitStore LimitStore = NewMemoryStore()
+)
+
+// 限流状态存储, 多实例部署时替换为共享实现
+type LimitStore interface {
+	// 读取计数, 不存在时返回 0
+	Get(key string) (int64, error)
+	// 累加计数并刷新过期时间, 返回累加后的值
+	Incr(key string, n int64, ttl time.Duration) (int64, error)
+	// 比较并交换
+	CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error)
+}
+
+// 替换限流状态存储
+func SetLimitStore(store LimitStore) {
+	limitStore = store
+}
+
+// 限流规则
+//
+//	rate-limit:
+//	  enabled: true
+//	  algorithm: token-bucket   # token-bucket | sliding-window
+//	  key: { rpm: 60, tpm: 100000 }
+//	  ip: { rpm: 120 }
+//	  model:
+//	    - match: "gpt-*"
+//	      rpm: 600
+//	      tpm: 1000000
+type limitRule struct {
+	Match string `mapstructure:"match"`
+	RPM   int64  `mapstructure:"rpm"`
+	TPM   int64  `mapstructure:"tpm"`
+}
+
+// 限流结果
+type decision struct {
+	allowed   bool
+	limit     int64
+	remaining int64
+	reset     time.Duration
+}
+
+// 限流算法, n 为 0 时仅查询不消费, force 为 true 时只记录不拒绝
+type limiter interface {
+	take(store LimitStore, key string, limit, n int64, force bool) (decision, error)
+	// 退还已消费的额度, 用于其它维度拒绝时回滚
+	refund(store LimitStore, key string, limit, n int64) error
+}
+
+// 单个维度的限流桶
+type bucket struct {
+	dimension string
+	name      string
+	rpm       int64
+	tpm       int64
+}
+
+func limitEnabled() bool {
+	return Env != nil && Env.GetBool("rate-limit.enabled")
+}
+
+func newLimiter() limiter {
+	if Env.GetString("rate-limit.algorithm") == "sliding-window" {
+		return slidingWindow{}
+	}
+	return tokenBucket{}
+}
+
+// 令牌桶 (GCRA), 存储理论到达时间
+type tokenBucket struct{}
+
+func (tokenBucket) take(store LimitStore, key string, limit, n int64, force bool) (result decision, err error) {
+	result.limit = limit
+	interval := int64(limitWindow) / limit
+	tolerance := int64(limitWindow)
+
+	for range 8 {
+		now := time.Now().UnixNano()
+		stored, e := store.Get(key)
+		if e != nil {
+			return result, e
+		}
+
+		tat := max(stored, now)
+		next := tat + n*interval
+		if need := tat + max(n, 1)*interval; !force && need-now > tolerance {
+			result.reset = time.Duration(need - tolerance - now)
+			result.remaining = max(0, (tolerance-(tat-now))/interval)
+			return
+		}
+
+		if n == 0 {
+			result.allowed = true
+			result.remaining = max(0, (tolerance-(tat-now))/interval)
+			result.reset = time.Duration(tat - now)
+			return
+		}
+
+		ok, e := store.CompareAndSwap(key, stored, next, limitWindow+time.Duration(next-now))
+		if e != nil {
+			return result, e
+		}
+
+		if ok {
+			result.allowed = true
+			result.remaining = max(0, (tolerance-(next-now))/interval)
+			result.reset = time.Duration(next - now)
+			return
+		}
+	}
+	return result, fmt.Errorf("rate limit: state of [%s] is contended", key)
+}
+
+func (tokenBucket) refund(store LimitStore, key string, limit, n int64) error {
+	interval := int64(limitWindow) / limit
+	for range 8 {
+		stored, err := store.Get(key)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano()
+		if stored <= now {
+			return nil
+		}
+
+		next := max(stored-n*interval, now)
+		ok, err := store.CompareAndSwap(key, stored, next, limitWindow+time.Duration(next-now))
+		if err != nil || ok {
+			return err
+		}
+	}
+	return fmt.Errorf("rate limit: state of [%s] is contended", key)
+}
+
+// 滑动窗口, 以前一窗口计数按剩余比例加权估算.
+// 先累加再判定, 超出时回滚, 并发请求或多实例共享存储时不会同时通过
+type slidingWindow struct{}
+
+func (slidingWindow) take(store LimitStore, key string, limit, n int64, force bool) (result decision, err error) {
+	result.limit = limit
+	now := time.Now()
+	index := now.UnixNano() / int64(limitWindow)
+	elapsed := float64(now.UnixNano()%int64(limitWindow)) / float64(limitWindow)
+
+	previous, err := store.Get(key + ":" + strconv.FormatInt(index-1, 10))
+	if err != nil {
+		return
+	}
+
+	var current int64
+	window := key + ":" + strconv.FormatInt(index, 10)
+	if n > 0 {
+		if current, err = store.Incr(window, n, 2*limitWindow); err != nil {
+			return
+		}
+		current -= n
+	} else if current, err = store.Get(window); err != nil {
+		return
+	}
+
+	estimate := int64(math.Floor(float64(previous)*(1-elapsed))) + current
+	result.reset = time.Duration(float64(limitWindow) * (1 - elapsed))
+	if !force && estimate+max(n, 1) > limit {
+		if n > 0 {
+			_, err = store.Incr(window, -n, 2*limitWindow)
+		}
+		result.remaining = max(0, limit-estimate)
+		return
+	}
+
+	result.allowed = true
+	result.remaining = max(0, limit-estimate-n)
+	return
+}
+
+func (slidingWindow) refund(store LimitStore, key string, _, n int64) error {
+	index := time.Now().UnixNano() / int64(limitWindow)
+	_, err := store.Incr(key+":"+strconv.FormatInt(index, 10), -n, 2*limitWindow)
+	return err
+}
+
+// 当前请求的限流桶
+func buckets(ctx *fiber.Ctx) (list []bucket) {
+	var rule limitRule
+	if key, ok := ctx.Locals(localKey).(*apiKey); ok {
+		_ = Env.UnmarshalKey("rate-limit.key", &rule)
+		if key.RPM > 0 {
+			rule.RPM = key.RPM
+		}
+		if key.TPM > 0 {
+			rule.TPM = key.TPM
+		}
+		list = append(list, bucket{"key", key.Key, rule.RPM, rule.TPM})
+	}
+
+	rule = limitRule{}
+	_ = Env.UnmarshalKey("rate-limit.ip", &rule)
+	list = append(list, bucket{"ip", ctx.IP(), rule.RPM, rule.TPM})
+
+	if mod := peekModel(ctx); mod != "" {
+		var rules []limitRule
+		_ = Env.UnmarshalKey("rate-limit.model", &rules)
+		for _, rule = range rules {
+			if ok, _ := path.Match(rule.Match, mod); ok || rule.Match == mod {
+				list = append(list, bucket{"model", mod, rule.RPM, rule.TPM})
+				break
+			}
+		}
+	}
+	return
+}
+
+// 限流中间件
+func rateLimit(ctx *fiber.Ctx) (err error) {
+	if !limitEnabled() || exempt(ctx) {
+		return ctx.Next()
+	}
+
+	algorithm := newLimiter()
+	list := buckets(ctx)
+
+	// 先查询所有维度, 均通过后再消费请求数, 被拒绝的请求不占用其它维度的额度
+	var requests, tokens *decision
+	for _, b := range list {
+		if b.rpm > 0 {
+			result, e := algorithm.take(limitStore, "rpm:"+b.dimension+":"+b.name, b.rpm, 0, false)
+			if e != nil {
+				return e
+			}
+			if !result.allowed {
+				setLimitHeaders(ctx, &result, tokens)
+				return writeLimited(ctx, "requests", "RPM", b, result)
+			}
+		}
+
+		if b.tpm > 0 {
+			result, e := algorithm.take(limitStore, "tpm:"+b.dimension+":"+b.name, b.tpm, 0, false)
+			if e != nil {
+				return e
+			}
+			if tokens == nil || !result.allowed || result.remaining < tokens.remaining {
+				tokens = &result
+			}
+			if !result.allowed {
+				setLimitHeaders(ctx, requests, tokens)
+				return writeLimited(ctx, "tokens", "TPM", b, result)
+			}
+		}
+	}
+
+	var taken []bucket
+	for _, b := range list {
+		if b.rpm <= 0 {
+			continue
+		}
+
+		result, e := algorithm.take(limitStore, "rpm:"+b.dimension+":"+b.name, b.rpm, 1, false)
+		if e == nil && result.allowed {
+			taken = append(taken, b)
+			if requests == nil || result.remaining < requests.remaining {
+				requests = &result
+			}
+			continue
+		}
+
+		// 查询与消费之间被并发请求占满, 退还已消费的维度
+		for _, t := range taken {
+			_ = algorithm.refund(limitStore, "rpm:"+t.dimension+":"+t.name, t.rpm, 1)
+		}
+		if e != nil {
+			return e
+		}
+		setLimitHeaders(ctx, &result, tokens)
+		return writeLimited(ctx, "requests", "RPM", b, result)
+	}
+
+	setLimitHeaders(ctx, requests, tokens)
+	ctx.Locals(localLimits, list)
+	return ctx.Next()
+}
+
+// 依据上报的用量消费 token 限额
+func throttle(c *model.Ctx) {
+	list, ok := c.Ctx().Locals(localLimits).([]bucket)
+	if !ok {
+		return
+	}
+
+	algorithm := newLimiter()
+	c.InterceptAt(model.StageMeter, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if usage, ok := model.UsageOf(msg); ok {
+			for _, b := range list {
+				if b.tpm > 0 {
+					_, _ = algorithm.take(limitStore, "tpm:"+b.dimension+":"+b.name, b.tpm, usage.Total(), true)
+				}
+			}
+		}
+		return next(msg)
+	})
+}
+
+func setLimitHeaders(ctx *fiber.Ctx, requests, tokens *decision) {
+	if requests != nil {
+		ctx.Set("x-ratelimit-limit-requests", strconv.FormatInt(requests.limit, 10))
+		ctx.Set("x-ratelimit-remaining-requests", strconv.FormatInt(requests.remaining, 10))
+		ctx.Set("x-ratelimit-reset-requests", requests.reset.Round(time.Millisecond).String())
+	}
+	if tokens != nil {
+		ctx.Set("x-ratelimit-limit-tokens", strconv.FormatInt(tokens.limit, 10))
+		ctx.Set("x-ratelimit-remaining-tokens", strconv.FormatInt(tokens.remaining, 10))
+		ctx.Set("x-ratelimit-reset-tokens", tokens.reset.Round(time.Millisecond).String())
+	}
+}
+
+func writeLimited(ctx *fiber.Ctx, typ, unit string, b bucket, result decision) error {
+	name := b.name
+	if b.dimension == "key" {
+		if key, ok := ctx.Locals(localKey).(*apiKey); ok {
+			name = key.id()
+		}
+	}
+
+	retry := result.reset.Round(time.Millisecond)
+	ctx.Set("retry-after", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	return writeErrorf(ctx, fiber.StatusTooManyRequests, typ, "rate_limit_exceeded",
+		fmt.Sprintf("Rate limit reached for %s per min (%s) on %s %s: Limit %d, Remaining %d. Please try again in %s.",
+			typ, unit, b.dimension, name, result.limit, result.remaining, retry))
+}
+
+// 内存存储
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	ops     int
+}
+
+type memoryEntry struct {
+	value   int64
+	expires time.Time
+}
+
+// 内存限流存储, 多个实例共用同一存储时可模拟多节点部署
+func NewMemoryStore() LimitStore {
+	return &memoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (store *memoryStore) Get(key string) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if entry := store.entry(key); entry != nil {
+		return entry.value, nil
+	}
+	return 0, nil
+}
+
+func (store *memoryStore) Incr(key string, n int64, ttl time.Duration) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry := store.entry(key)
+	if entry == nil {
+		entry = &memoryEntry{}
+		store.entries[key] = entry
+	}
+	entry.value += n
+	entry.expires = time.Now().Add(ttl)
+	return entry.value, nil
+}
+
+func (store *memoryStore) CompareAndSwap(key string, old, new int64, ttl time.Duration) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var value int64
+	entry := store.entry(key)
+	if entry != nil {
+		value = entry.value
+	}
+
+	if value != old {
+		return false, nil
+	}
+
+	store.entries[key] = &memoryEntry{value: new, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+// 读取未过期的条目, 并定期清理, 需持有锁
+func (store *memoryStore) entry(key string) *memoryEntry {
+	now := time.Now()
+	if store.ops++; store.ops%1024 == 0 {
+		for k, entry := range store.entries {
+			if now.After(entry.expires) {
+				delete(store.entries, k)
+			}
+		}
+	}
+
+	entry, ok := store.entries[key]
+	if !ok || now.After(entry.expires) {
+		return nil
+	}
+	return entry
+}
@@ -0,0 +1,172 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+func TestTokenBucketSharedStore(t *testing.T) {
+	store := NewMemoryStore()
+	instances := []limiter{tokenBucket{}, tokenBucket{}}
+
+	for i := range 3 {
+		result, err := instances[i%2].take(store, "rpm:key:a", 3, 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	result, err := instances[1].take(store, "rpm:key:a", 3, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.allowed || result.remaining != 0 || result.reset <= 0 {
+		t.Fatalf("request should be limited across instances: %+v", result)
+	}
+
+	// 其它维度互不影响
+	if result, _ = instances[0].take(store, "rpm:key:b", 3, 1, false); !result.allowed {
+		t.Fatal("other key should be allowed")
+	}
+}
+
+func TestTokenBucketTokens(t *testing.T) {
+	store := NewMemoryStore()
+	algorithm := tokenBucket{}
+
+	if result, _ := algorithm.take(store, "tpm:ip:x", 1000, 0, false); !result.allowed || result.remaining != 1000 {
+		t.Fatalf("empty bucket should be allowed: %+v", result)
+	}
+
+	// 上报用量超出限额后拒绝后续请求
+	_, _ = algorithm.take(store, "tpm:ip:x", 1000, 1500, true)
+	if result, _ := algorithm.take(store, "tpm:ip:x", 1000, 0, false); result.allowed {
+		t.Fatalf("exhausted bucket should be limited: %+v", result)
+	}
+}
+
+func TestSlidingWindowSharedStore(t *testing.T) {
+	store := NewMemoryStore()
+	instances := []limiter{slidingWindow{}, slidingWindow{}}
+
+	for i := range 2 {
+		if result, _ := instances[i].take(store, "rpm:ip:1", 2, 1, false); !result.allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	if result, _ := instances[0].take(store, "rpm:ip:1", 2, 1, false); result.allowed {
+		t.Fatalf("request should be limited: %+v", result)
+	}
+}
+
+func TestSlidingWindowConcurrent(t *testing.T) {
+	store := NewMemoryStore()
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, _ := (slidingWindow{}).take(store, "rpm:ip:2", 10, 1, false); result.allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 10 {
+		t.Fatalf("allowed = %d, want 10", allowed.Load())
+	}
+}
+
+func TestRateLimitRejectedNotConsumed(t *testing.T) {
+	vip := viper.New()
+	vip.Set("rate-limit.enabled", true)
+	vip.Set("rate-limit.ip.rpm", 5)
+	vip.Set("rate-limit.model", []map[string]any{{"match": "m", "rpm": 1}})
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+	store := NewMemoryStore()
+	SetLimitStore(store)
+
+	app := fiber.New()
+	app.Use(rateLimit)
+	app.Post("/v1/chat/completions", func(ctx *fiber.Ctx) error {
+		return ctx.SendString("ok")
+	})
+
+	for i, want := range []int{fiber.StatusOK, fiber.StatusTooManyRequests, fiber.StatusTooManyRequests} {
+		response, err := app.Test(httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"m"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != want {
+			t.Fatalf("request %d status = %d, want %d", i, response.StatusCode, want)
+		}
+	}
+
+	// 被模型维度拒绝的请求不消费 ip 维度
+	result, _ := (tokenBucket{}).take(store, "rpm:ip:0.0.0.0", 5, 0, false)
+	if result.remaining != 4 {
+		t.Fatalf("ip remaining = %d, want 4", result.remaining)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	vip := viper.New()
+	vip.Set("rate-limit.enabled", true)
+	vip.Set("rate-limit.ip.rpm", 1)
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+	SetLimitStore(NewMemoryStore())
+
+	app := fiber.New()
+	app.Use(rateLimit)
+	app.Post("/v1/chat/completions", func(ctx *fiber.Ctx) error {
+		return ctx.SendString("ok")
+	})
+
+	request := func() *http.Response {
+		response, err := app.Test(httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"m"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := request(); response.StatusCode != fiber.StatusOK {
+		t.Fatalf("first request status = %d", response.StatusCode)
+	}
+
+	response := request()
+	if response.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("second request status = %d", response.StatusCode)
+	}
+
+	for _, header := range []string{"x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests", "retry-after"} {
+		if response.Header.Get(header) == "" {
+			t.Fatalf("missing header %s", header)
+		}
+	}
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error.Code != "rate_limit_exceeded" {
+		t.Fatalf("unexpected body: %v %+v", err, body)
+	}
+}
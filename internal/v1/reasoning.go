@@ -0,0 +1,285 @@
+package v1
+
+import (
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bincooo/ago/model"
+)
+
+// 思考内容提取, 将 <think> 标签内的文本转为 reasoning_content
+//
+//	reasoning:
+//	  enabled: true
+//	  mode: split         # split | strip | inline | anthropic
+//	  tags: [ think ]
+//	  implicit: false     # 输出以思考内容开头且省略了起始标签
+//	  models: [ "deepseek-r1*" ]
+//
+// split 拆分到 reasoning_content; strip 丢弃思考内容; inline 保留在正文中,
+// 并将上游原生的 reasoning_content 以标签包裹并入正文; anthropic 以 type 为 thinking 的分片写出
+func extractReasoning(c *model.Ctx, mod string) {
+	if Env == nil || !Env.GetBool("reasoning.enabled") {
+		return
+	}
+
+	if models := Env.GetStringSlice("reasoning.models"); len(models) > 0 {
+		if !slices.ContainsFunc(models, func(pattern string) bool {
+			ok, _ := path.Match(pattern, mod)
+			return ok || pattern == mod
+		}) {
+			return
+		}
+	}
+
+	tags := Env.GetStringSlice("reasoning.tags")
+	if len(tags) == 0 {
+		tags = []string{"think"}
+	}
+
+	splitter := &reasoningSplitter{mode: Env.GetString("reasoning.mode")}
+	for _, tag := range tags {
+		tag = strings.Trim(tag, "<>/ ")
+		splitter.tags = append(splitter.tags, &marker{"<" + tag + ">", "</" + tag + ">"})
+	}
+
+	if Env.GetBool("reasoning.implicit") {
+		splitter.inside = splitter.tags[0]
+	}
+	c.Intercept(reasoningInterceptor(splitter))
+}
+
+func reasoningInterceptor(splitter *reasoningSplitter) model.Interceptor {
+	var last *model.Response
+	return func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		switch v := msg.(type) {
+		case *model.Response:
+			if v == nil || len(v.Choices) == 0 {
+				break
+			}
+
+			response := *v
+			response.Choices = slices.Clone(v.Choices)
+			choice := &response.Choices[0]
+			if choice.Message != nil {
+				message := *choice.Message
+				reasoning, text := splitter.split(message.ReasoningContent, message.Content, true)
+				message.ReasoningContent, message.Content = "", text
+				switch splitter.mode {
+				case "strip":
+				case "inline":
+					message.Content = splitter.inline(reasoning, text, true)
+				default:
+					message.ReasoningContent = reasoning
+				}
+				choice.Message = &message
+				return next(&response)
+			}
+
+			if choice.Delta == nil {
+				break
+			}
+
+			last = &response
+			delta := *choice.Delta
+			reasoning, text := splitter.split(delta.ReasoningContent, delta.Content, choice.FinishReason != nil)
+			deltas := splitter.render(delta, reasoning, text, choice.FinishReason != nil)
+			if len(deltas) == 0 {
+				if choice.FinishReason == nil && len(response.Usage) == 0 && len(delta.ToolCalls) == 0 {
+					if delta.Content != "" || delta.ReasoningContent != "" {
+						return nil
+					}
+				}
+				delta.Content, delta.ReasoningContent = "", ""
+				deltas = append(deltas, delta)
+			}
+
+			// 结束原因、用量与工具调用附在最后一个分片上
+			for i := range deltas {
+				chunk := response
+				chunk.Choices = slices.Clone(response.Choices)
+				chunk.Choices[0].Delta = &deltas[i]
+				if i < len(deltas)-1 {
+					chunk.Choices[0].FinishReason = nil
+					chunk.Usage = nil
+					deltas[i].ToolCalls = nil
+				}
+				if err := next(&chunk); err != nil {
+					return err
+				}
+			}
+			return nil
+
+		case error:
+			if v == io.EOF {
+				if err := flushReasoning(splitter, last, next); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if msg == model.Flush {
+				if err := flushReasoning(splitter, last, next); err != nil {
+					return err
+				}
+			}
+		}
+		return next(msg)
+	}
+}
+
+// 写出拆分器中剩余的内容
+func flushReasoning(splitter *reasoningSplitter, last *model.Response, next func(interface{}) error) error {
+	reasoning, text := splitter.split("", "", true)
+	deltas := splitter.render(model.ChoiceDelta{Type: "text", Role: "assistant"}, reasoning, text, true)
+	for i := range deltas {
+		response := &model.Response{Object: "chat.completion.chunk"}
+		if last != nil {
+			response.Id, response.Model, response.Created = last.Id, last.Model, last.Created
+		}
+
+		response.Choices = []model.Choice{{Index: 0, Delta: &deltas[i]}}
+		if err := next(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 流式思考标签拆分器, 标签跨分片时暂缓写出
+type reasoningSplitter struct {
+	mode    string
+	tags    []*marker
+	pending string
+	inside  *marker
+
+	// 思考结束后去除正文开头的空行
+	trim bool
+	// inline 模式下已写出起始标签
+	opened bool
+}
+
+// 拆分出思考内容与正文, native 为上游原生的思考内容; final 为 true 时写出全部缓冲
+func (splitter *reasoningSplitter) split(native, content string, final bool) (reasoning, text string) {
+	if splitter.mode == "inline" {
+		return native, content
+	}
+
+	var r, t strings.Builder
+	r.WriteString(native)
+	writeText := func(s string) {
+		if splitter.trim {
+			s = strings.TrimLeft(s, "\r\n")
+			if s == "" {
+				return
+			}
+			splitter.trim = false
+		}
+		t.WriteString(s)
+	}
+
+	splitter.pending += content
+	for splitter.pending != "" {
+		if splitter.inside == nil {
+			index, block := -1, (*marker)(nil)
+			for _, m := range splitter.tags {
+				if i := strings.Index(splitter.pending, m.start); i >= 0 && (index < 0 || i < index) {
+					index, block = i, m
+				}
+			}
+
+			if block == nil {
+				keep := 0
+				if !final {
+					keep = partialSuffix(splitter.pending, startsOf(splitter.tags)...)
+				}
+				writeText(splitter.pending[:len(splitter.pending)-keep])
+				splitter.pending = splitter.pending[len(splitter.pending)-keep:]
+				break
+			}
+
+			writeText(splitter.pending[:index])
+			splitter.pending = splitter.pending[index+len(block.start):]
+			splitter.inside = block
+			continue
+		}
+
+		i := strings.Index(splitter.pending, splitter.inside.end)
+		if i < 0 {
+			keep := 0
+			if !final {
+				keep = partialSuffix(splitter.pending, splitter.inside.end)
+			}
+			r.WriteString(splitter.pending[:len(splitter.pending)-keep])
+			splitter.pending = splitter.pending[len(splitter.pending)-keep:]
+			break
+		}
+
+		r.WriteString(splitter.pending[:i])
+		splitter.pending = splitter.pending[i+len(splitter.inside.end):]
+		splitter.inside = nil
+		splitter.trim = true
+	}
+	return r.String(), t.String()
+}
+
+// 按模式生成分片, 内容为空时返回空
+func (splitter *reasoningSplitter) render(base model.ChoiceDelta, reasoning, text string, final bool) (deltas []model.ChoiceDelta) {
+	base.Content, base.ReasoningContent = "", ""
+	switch splitter.mode {
+	case "strip":
+		if text != "" {
+			base.Content = text
+			deltas = append(deltas, base)
+		}
+
+	case "inline":
+		if content := splitter.inline(reasoning, text, final); content != "" {
+			base.Content = content
+			deltas = append(deltas, base)
+		}
+
+	case "anthropic":
+		if reasoning != "" {
+			delta := base
+			delta.Type = "thinking"
+			delta.ReasoningContent = reasoning
+			deltas = append(deltas, delta)
+		}
+		if text != "" {
+			delta := base
+			delta.Type = "text"
+			delta.Content = text
+			deltas = append(deltas, delta)
+		}
+
+	default:
+		if reasoning != "" || text != "" {
+			base.ReasoningContent, base.Content = reasoning, text
+			deltas = append(deltas, base)
+		}
+	}
+	return
+}
+
+// 以首个标签包裹思考内容并入正文
+func (splitter *reasoningSplitter) inline(reasoning, text string, final bool) string {
+	var out strings.Builder
+	tag := splitter.tags[0]
+	if reasoning != "" {
+		if !splitter.opened {
+			out.WriteString(tag.start)
+			splitter.opened = true
+		}
+		out.WriteString(reasoning)
+	}
+
+	if splitter.opened && (text != "" || final) {
+		out.WriteString(tag.end + "\n\n")
+		splitter.opened = false
+	}
+	out.WriteString(text)
+	return out.String()
+}
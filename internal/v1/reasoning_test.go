@@ -0,0 +1,141 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bincooo/ago/model"
+)
+
+func newSplitter(mode string, implicit bool, tags ...string) *reasoningSplitter {
+	splitter := &reasoningSplitter{mode: mode}
+	for _, tag := range tags {
+		splitter.tags = append(splitter.tags, &marker{"<" + tag + ">", "</" + tag + ">"})
+	}
+	if implicit {
+		splitter.inside = splitter.tags[0]
+	}
+	return splitter
+}
+
+// 在每个字节处切分为两段, 并逐字节输入
+func splitsOf(content string) [][]string {
+	splits := [][]string{{content}, strings.Split(content, "")}
+	for i := 1; i < len(content); i++ {
+		splits = append(splits, []string{content[:i], content[i:]})
+	}
+	return splits
+}
+
+func TestReasoningSplitter(t *testing.T) {
+	cases := []struct {
+		name      string
+		content   string
+		implicit  bool
+		tags      []string
+		reasoning string
+		text      string
+	}{
+		{
+			name:      "think",
+			content:   "<think>Let me reason.</think>\n\nThe answer is 4.",
+			reasoning: "Let me reason.",
+			text:      "The answer is 4.",
+		},
+		{
+			name:      "leading text",
+			content:   "Hi <think>hmm</think>there",
+			reasoning: "hmm",
+			text:      "Hi there",
+		},
+		{
+			name:      "implicit",
+			content:   "reasoning first</think>\nanswer",
+			implicit:  true,
+			reasoning: "reasoning first",
+			text:      "answer",
+		},
+		{
+			name:      "custom tags",
+			content:   "<reason>a</reason><think>b</think>c",
+			tags:      []string{"think", "reason"},
+			reasoning: "ab",
+			text:      "c",
+		},
+		{
+			name:    "similar tag",
+			content: "a <thinking> b </think",
+			text:    "a <thinking> b </think",
+		},
+		{
+			name:      "unclosed",
+			content:   "<think>still thinking <",
+			reasoning: "still thinking <",
+		},
+		{
+			name:    "no tags",
+			content: "1 < 2 and 3 > 2",
+			text:    "1 < 2 and 3 > 2",
+		},
+	}
+
+	for _, tc := range cases {
+		tags := tc.tags
+		if len(tags) == 0 {
+			tags = []string{"think"}
+		}
+
+		for _, chunks := range splitsOf(tc.content) {
+			splitter := newSplitter("split", tc.implicit, tags...)
+			var reasoning, text strings.Builder
+			for i, chunk := range chunks {
+				r, t := splitter.split("", chunk, i == len(chunks)-1)
+				reasoning.WriteString(r)
+				text.WriteString(t)
+			}
+
+			if reasoning.String() != tc.reasoning || text.String() != tc.text {
+				t.Fatalf("%s %q: reasoning = %q, text = %q", tc.name, chunks, reasoning.String(), text.String())
+			}
+		}
+	}
+}
+
+func TestReasoningInterceptor(t *testing.T) {
+	content := "<think>plan</think>\nresult"
+	want := map[string][2]string{
+		"split":     {"plan", "result"},
+		"strip":     {"", "result"},
+		"inline":    {"", content},
+		"anthropic": {"plan", "result"},
+	}
+
+	for mode, expected := range want {
+		for _, chunks := range splitsOf(content) {
+			interceptor := reasoningInterceptor(newSplitter(mode, false, "think"))
+			var reasoning, text strings.Builder
+			next := func(msg interface{}) error {
+				if response, ok := msg.(*model.Response); ok {
+					delta := response.Choices[0].Delta
+					reasoning.WriteString(delta.ReasoningContent)
+					text.WriteString(delta.Content)
+				}
+				return nil
+			}
+
+			for _, chunk := range chunks {
+				response := &model.Response{Choices: []model.Choice{{Delta: &model.ChoiceDelta{Content: chunk}}}}
+				if err := interceptor(nil, response, next); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := interceptor(nil, model.Flush, next); err != nil {
+				t.Fatal(err)
+			}
+
+			if reasoning.String() != expected[0] || text.String() != expected[1] {
+				t.Fatalf("%s %q: reasoning = %q, text = %q", mode, chunks, reasoning.String(), text.String())
+			}
+		}
+	}
+}
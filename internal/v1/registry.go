@@ -0,0 +1,364 @@
+package v1
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	adapters = &adapterRegistry{}
+)
+
+// 注册的适配器与运行时的调整
+type registered struct {
+	// 唯一标识, 即适配器名称, 重名时追加序号如 name#2
+	Id       string
+	Adapter  model.Adapter
+	Disabled bool
+	// 运行时增加、移除的模型
+	Added   []model.Model
+	Removed []string
+}
+
+type adapterRegistry struct {
+	mu      sync.RWMutex
+	entries []*registered
+}
+
+// 注册适配器, 按注册顺序参与路由; 重名的适配器同样追加, 以 name#N 区分.
+// 插件通常在 init 中注册, 此时日志尚未初始化, 重名在 Initialized 时告警
+func AddAdapter(adapter model.Adapter) {
+	adapters.mu.Lock()
+	defer adapters.mu.Unlock()
+
+	name := nameOf(adapter)
+	id := name
+	for n := 2; adapters.find(id) != nil; n++ {
+		id = fmt.Sprintf("%s#%d", name, n)
+	}
+	adapters.entries = append(adapters.entries, &registered{Id: id, Adapter: adapter})
+}
+
+// 替换标识为 id 的适配器, 保留启用状态与模型调整
+func ReplaceAdapter(id string, adapter model.Adapter) bool {
+	return adapters.update(id, func(entry *registered) {
+		entry.Adapter = adapter
+	})
+}
+
+// 移除适配器
+func RemoveAdapter(id string) bool {
+	adapters.mu.Lock()
+	defer adapters.mu.Unlock()
+
+	for i, entry := range adapters.entries {
+		if entry.Id == id {
+			adapters.entries = slices.Delete(adapters.entries, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// 启用或禁用适配器, 禁用后不参与路由与模型列表
+func EnableAdapter(id string, enabled bool) bool {
+	return adapters.update(id, func(entry *registered) {
+		entry.Disabled = !enabled
+	})
+}
+
+// 需持有锁
+func (reg *adapterRegistry) find(id string) *registered {
+	for _, entry := range reg.entries {
+		if entry.Id == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (reg *adapterRegistry) update(id string, apply func(*registered)) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	entry := reg.find(id)
+	if entry == nil {
+		return false
+	}
+	apply(entry)
+	return true
+}
+
+// 注册表的快照, 按注册顺序
+func (reg *adapterRegistry) snapshot() []registered {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	list := make([]registered, 0, len(reg.entries))
+	for _, entry := range reg.entries {
+		list = append(list, registered{
+			Id:       entry.Id,
+			Adapter:  entry.Adapter,
+			Disabled: entry.Disabled,
+			Added:    slices.Clone(entry.Added),
+			Removed:  slices.Clone(entry.Removed),
+		})
+	}
+	return list
+}
+
+// 启用的适配器
+func (reg *adapterRegistry) enabled() []registered {
+	return slices.DeleteFunc(reg.snapshot(), func(entry registered) bool { return entry.Disabled })
+}
+
+// 按标识查找, 返回快照
+func (reg *adapterRegistry) get(id string) (entry registered, ok bool) {
+	for _, entry = range reg.snapshot() {
+		if entry.Id == id {
+			return entry, true
+		}
+	}
+	return registered{}, false
+}
+
+// 重名的适配器告警, 日志初始化后调用
+func (reg *adapterRegistry) warnDuplicates() {
+	for _, entry := range reg.snapshot() {
+		if name := nameOf(entry.Adapter); entry.Id != name {
+			logger.Sugar().Warnf("registry: adapter [%s] is registered more than once, addressed as [%s]", name, entry.Id)
+		}
+	}
+}
+
+// 增加模型, 返回实际增加的数量
+func (reg *adapterRegistry) addModels(id string, ids ...string) (added int, ok bool) {
+	ok = reg.update(id, func(entry *registered) {
+		for _, id := range ids {
+			if id == "" {
+				continue
+			}
+			entry.Removed = slices.DeleteFunc(entry.Removed, func(removed string) bool { return removed == id })
+			if slices.ContainsFunc(entry.Added, func(mod model.Model) bool { return mod.Id == id }) ||
+				slices.ContainsFunc(entry.Adapter.Model(), func(mod model.Model) bool { return mod.Id == id }) {
+				continue
+			}
+			entry.Added = append(entry.Added, model.Model{
+				Id:      id,
+				Object:  "model",
+				Created: int(time.Now().Unix()),
+				By:      "adapter",
+			})
+			added++
+		}
+	})
+	return
+}
+
+// 移除模型, 适配器自带的模型记录为已移除
+func (reg *adapterRegistry) removeModels(id string, ids ...string) (removed int, ok bool) {
+	ok = reg.update(id, func(entry *registered) {
+		for _, id := range ids {
+			size := len(entry.Added)
+			entry.Added = slices.DeleteFunc(entry.Added, func(mod model.Model) bool { return mod.Id == id })
+			if size != len(entry.Added) {
+				removed++
+				continue
+			}
+			if !slices.Contains(entry.Removed, id) &&
+				slices.ContainsFunc(entry.Adapter.Model(), func(mod model.Model) bool { return mod.Id == id }) {
+				entry.Removed = append(entry.Removed, id)
+				removed++
+			}
+		}
+	})
+	return
+}
+
+// 模型列表: 适配器自带的模型去除已移除的, 加上运行时增加的
+func (entry registered) models() []model.Model {
+	models := make([]model.Model, 0)
+	for _, mod := range entry.Adapter.Model() {
+		if !slices.Contains(entry.Removed, mod.Id) {
+			models = append(models, mod)
+		}
+	}
+	return append(models, entry.Added...)
+}
+
+// 是否支持该模型, 运行时的调整优先于适配器的判定函数
+func (entry registered) support(c *model.Ctx, mod string) bool {
+	if slices.Contains(entry.Removed, mod) {
+		return false
+	}
+	for _, added := range entry.Added {
+		if added.Id == mod {
+			return true
+		}
+		if ok, err := path.Match(added.Id, mod); err == nil && ok {
+			return true
+		}
+	}
+	return entry.Adapter.Support(c, mod)
+}
+
+// 各适配器的累计用量与进行中的请求数, 按注册标识汇总
+func adapterStats() (counters map[string]usageCounter, inflight map[string]int) {
+	return usages.byAdapter(), activities.byAdapter()
+}
+
+func adapterInfo(entry registered, counters map[string]usageCounter, inflight map[string]int) fiber.Map {
+	name := nameOf(entry.Adapter)
+	models := make([]string, 0)
+	for _, mod := range entry.models() {
+		models = append(models, mod.Id)
+	}
+
+	info := fiber.Map{
+		"id":       entry.Id,
+		"name":     name,
+		"enabled":  !entry.Disabled,
+		"models":   models,
+		"health":   healths.state(entry),
+		"usage":    counters[entry.Id],
+		"inflight": inflight[entry.Id],
+	}
+	if pool := poolOf(entry.Adapter); pool != "" {
+		info["pool"] = pool
+	}
+	return info
+}
+
+func poolOf(adapter model.Adapter) string {
+	if pooled, ok := adapter.(interface{ Pool() string }); ok {
+		return pooled.Pool()
+	}
+	return ""
+}
+
+// 适配器列表, 含模型、启用状态、健康状态与用量
+func listAdapters(ctx *fiber.Ctx) error {
+	counters, inflight := adapterStats()
+	entries := adapters.snapshot()
+	list := make([]fiber.Map, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, adapterInfo(entry, counters, inflight))
+	}
+	return ctx.JSON(list)
+}
+
+func getAdapter(ctx *fiber.Ctx) error {
+	entry, ok := adapters.get(adapterId(ctx))
+	if !ok {
+		return adapterNotFound(ctx)
+	}
+	counters, inflight := adapterStats()
+	return ctx.JSON(adapterInfo(entry, counters, inflight))
+}
+
+func enableAdapter(ctx *fiber.Ctx) error {
+	return toggleAdapter(ctx, true)
+}
+
+func disableAdapter(ctx *fiber.Ctx) error {
+	return toggleAdapter(ctx, false)
+}
+
+func toggleAdapter(ctx *fiber.Ctx, enabled bool) error {
+	id := adapterId(ctx)
+	if !EnableAdapter(id, enabled) {
+		return adapterNotFound(ctx)
+	}
+	logger.Sugar().Infof("registry: adapter [%s] enabled=%v", id, enabled)
+	return ctx.JSON(fiber.Map{"enabled": enabled})
+}
+
+func deleteAdapter(ctx *fiber.Ctx) error {
+	id := adapterId(ctx)
+	if !RemoveAdapter(id) {
+		return adapterNotFound(ctx)
+	}
+	logger.Sugar().Infof("registry: adapter [%s] removed", id)
+	return ctx.JSON(fiber.Map{"removed": true})
+}
+
+// 请求体: {"models": ["id", ...]}
+func modelIds(ctx *fiber.Ctx) (ids []string, err error) {
+	var body struct {
+		Models []string `json:"models"`
+	}
+	err = ctx.BodyParser(&body)
+	return body.Models, err
+}
+
+func addAdapterModels(ctx *fiber.Ctx) error {
+	ids, err := modelIds(ctx)
+	if err != nil {
+		return err
+	}
+	added, ok := adapters.addModels(adapterId(ctx), ids...)
+	if !ok {
+		return adapterNotFound(ctx)
+	}
+	return ctx.JSON(fiber.Map{"added": added})
+}
+
+func removeAdapterModels(ctx *fiber.Ctx) error {
+	ids, err := modelIds(ctx)
+	if err != nil {
+		return err
+	}
+	removed, ok := adapters.removeModels(adapterId(ctx), ids...)
+	if !ok {
+		return adapterNotFound(ctx)
+	}
+	return ctx.JSON(fiber.Map{"removed": removed})
+}
+
+// 适配器的凭证池, 未配置凭证池时返回错误
+func adapterPool(ctx *fiber.Ctx) (pool string, err error) {
+	entry, ok := adapters.get(adapterId(ctx))
+	if !ok {
+		return "", adapterNotFound(ctx)
+	}
+	if pool = poolOf(entry.Adapter); pool == "" {
+		err = writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "no_credential_pool",
+			"Adapter has no credential pool.")
+	}
+	return
+}
+
+func addAdapterCredentials(ctx *fiber.Ctx) error {
+	pool, err := adapterPool(ctx)
+	if pool == "" {
+		return err
+	}
+	return addPoolCredentials(ctx, pool)
+}
+
+func removeAdapterCredential(ctx *fiber.Ctx) error {
+	pool, err := adapterPool(ctx)
+	if pool == "" {
+		return err
+	}
+	return removePoolCredential(ctx, pool)
+}
+
+// 路径中的适配器标识, 重名适配器的 # 需转义
+func adapterId(ctx *fiber.Ctx) string {
+	id := ctx.Params("adapter")
+	if unescaped, err := url.PathUnescape(id); err == nil {
+		return unescaped
+	}
+	return id
+}
+
+func adapterNotFound(ctx *fiber.Ctx) error {
+	return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "not_found", "adapter not found")
+}
@@ -0,0 +1,117 @@
+package v1
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+// 以适配器名称为标识的注册项
+func entryOf(adapter model.Adapter) registered {
+	return registered{Id: nameOf(adapter), Adapter: adapter}
+}
+
+// 同名的适配器
+type registryAdapter struct {
+	model.BasicAdapter
+	pool string
+}
+
+func (registryAdapter) Name() string                    { return "registry" }
+func (registryAdapter) Support(*model.Ctx, string) bool { return false }
+func (registryAdapter) Model() []model.Model            { return []model.Model{{Id: "r"}} }
+func (adapter registryAdapter) Pool() string            { return adapter.pool }
+
+func TestAdminAdapters(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	vip := viper.New()
+	vip.Set("admin.key", "admin")
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+	defer usageFixture(nil, nil)()
+
+	AddAdapter(registryAdapter{})
+	AddAdapter(registryAdapter{pool: "registry"})
+	defer RemoveAdapter("registry")
+	defer RemoveAdapter("registry#2")
+	defer func() {
+		credentials.mu.Lock()
+		delete(credentials.pools, "registry")
+		credentials.mu.Unlock()
+	}()
+
+	// 用量与进行中的请求按注册标识统计, 重名的适配器互不影响
+	usages.add(usageKey{Day: time.Now().Format(usageDay), Adapter: "registry#2"}, usageCounter{Requests: 3})
+	entry, _ := adapters.get("registry#2")
+	c := conversationCtx(t, "")
+	tracked(c, "r")
+	dispatched(c, entry)
+	defer activities.finish(c.Ctx().Locals(localActivity).(*activity))
+
+	app := fiber.New()
+	adminRoutes(app)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+		want   string
+	}{
+		{"unauthorized", "GET", "/admin/adapters", "wrong", "", fiber.StatusUnauthorized, ""},
+		{"get", "GET", "/admin/adapters/registry", "admin", "", fiber.StatusOK,
+			`"id":"registry","inflight":0,"models":["r"],"name":"registry"`},
+		{"get duplicate", "GET", "/admin/adapters/registry%232", "admin", "", fiber.StatusOK,
+			`"id":"registry#2","inflight":1,`},
+		{"duplicate usage", "GET", "/admin/adapters/registry%232", "admin", "", fiber.StatusOK,
+			`"usage":{"requests":3,`},
+		{"not found", "GET", "/admin/adapters/missing", "admin", "", fiber.StatusNotFound, "not_found"},
+		{"disable", "POST", "/admin/adapters/registry/disable", "admin", "", fiber.StatusOK, `{"enabled":false}`},
+		{"disabled", "GET", "/admin/adapters/registry", "admin", "", fiber.StatusOK, `"enabled":false`},
+		{"enable", "POST", "/admin/adapters/registry/enable", "admin", "", fiber.StatusOK, `{"enabled":true}`},
+		{"add models", "POST", "/admin/adapters/registry/models", "admin", `{"models":["r","x"]}`, fiber.StatusOK, `{"added":1}`},
+		{"remove models", "DELETE", "/admin/adapters/registry/models", "admin", `{"models":["r","y"]}`, fiber.StatusOK, `{"removed":1}`},
+		{"models", "GET", "/admin/adapters/registry", "admin", "", fiber.StatusOK, `"models":["x"]`},
+		{"no pool", "POST", "/admin/adapters/registry/credentials", "admin", `{"values":["a"]}`, fiber.StatusBadRequest, "no_credential_pool"},
+		{"add credentials", "POST", "/admin/adapters/registry%232/credentials", "admin", `{"values":["a"]}`, fiber.StatusOK, `{"added":1}`},
+		{"delete", "DELETE", "/admin/adapters/registry", "admin", "", fiber.StatusOK, `{"removed":true}`},
+		{"deleted", "GET", "/admin/adapters/registry", "admin", "", fiber.StatusNotFound, "not_found"},
+	}
+
+	for _, tc := range cases {
+		request := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		request.Header.Set("Authorization", "Bearer "+tc.key)
+		request.Header.Set("Content-Type", "application/json")
+		response, err := app.Test(request, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		if response.StatusCode != tc.status || !strings.Contains(string(data), tc.want) {
+			t.Errorf("%s: %d %s, want %d containing %s", tc.name, response.StatusCode, data, tc.status, tc.want)
+		}
+	}
+
+	request := httptest.NewRequest("GET", "/admin/adapters", nil)
+	request.Header.Set("Authorization", "Bearer admin")
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []struct {
+		Id string `json:"id"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&list); err != nil || len(list) != 1 || list[0].Id != "registry#2" {
+		t.Fatalf("adapters = %+v, err = %v", list, err)
+	}
+}
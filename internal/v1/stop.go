@@ -0,0 +1,195 @@
+package v1
+
+import (
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/bincooo/ago/model"
+	"github.com/bincooo/ago/tokenizer"
+)
+
+// 在输出中执行停止词与 max_tokens 限制, 上游忽略这两个参数时兜底; 截断后取消上游请求
+//
+//	enforcement:
+//	  stop: true
+//	  max-tokens: true
+func enforceLimits(c *model.Ctx, completion *model.Completion) {
+	var stops []string
+	if enforced("enforcement.stop") {
+		stops = slices.DeleteFunc(slices.Clone(completion.StopSequences), func(stop string) bool { return stop == "" })
+	}
+
+	budget := 0
+	if enforced("enforcement.max-tokens") {
+		budget = completion.MaxTokens
+	}
+
+	if len(stops) == 0 && budget <= 0 {
+		return
+	}
+	c.Intercept(limitInterceptor(&truncator{stops: stops, budget: budget}))
+}
+
+func limitInterceptor(limiter *truncator) model.Interceptor {
+	var last *model.Response
+	// 已写出的内容, 截断后据此估算用量
+	agg := new(model.Aggregator)
+	finished := false
+	return func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if finished {
+			// 已截断并写出结束标记, 丢弃上游后续输出; 结束信号仍需传递供后续阶段收尾
+			if msg == model.Flush {
+				return next(msg)
+			}
+			return nil
+		}
+
+		switch v := msg.(type) {
+		case *model.Response:
+			if v == nil || len(v.Choices) == 0 {
+				break
+			}
+
+			response := *v
+			response.Choices = slices.Clone(v.Choices)
+			choice := &response.Choices[0]
+			if choice.Message != nil {
+				message := *choice.Message
+				reasoning, text, reason := limiter.feed(message.ReasoningContent, message.Content, true)
+				message.ReasoningContent, message.Content = reasoning, text
+				if reason != "" {
+					choice.FinishReason = &reason
+				}
+				choice.Message = &message
+				return next(&response)
+			}
+
+			if choice.Delta == nil {
+				break
+			}
+
+			last = &response
+			delta := *choice.Delta
+			reasoning, text, reason := limiter.feed(delta.ReasoningContent, delta.Content, choice.FinishReason != nil)
+			swallowed := (delta.Content != "" || delta.ReasoningContent != "") && reasoning == "" && text == ""
+			delta.ReasoningContent, delta.Content = reasoning, text
+			choice.Delta = &delta
+
+			if reason == "" {
+				if swallowed && choice.FinishReason == nil && len(response.Usage) == 0 && len(delta.ToolCalls) == 0 {
+					return nil
+				}
+				agg.Add(&response)
+				return next(&response)
+			}
+
+			// 以本地估算的用量结束, 并取消上游请求
+			finished = true
+			choice.FinishReason = &reason
+			defer c.Cancel()
+			if err := next(&response); err != nil {
+				return err
+			}
+			agg.Add(&response)
+			if err := fillUsage(c, agg, next); err != nil {
+				return err
+			}
+			return next(io.EOF)
+
+		case error:
+			if v == io.EOF {
+				if err := flushLimiter(limiter, last, next); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if msg == model.Flush {
+				if err := flushLimiter(limiter, last, next); err != nil {
+					return err
+				}
+			}
+		}
+		return next(msg)
+	}
+}
+
+// 写出暂缓的内容
+func flushLimiter(limiter *truncator, last *model.Response, next func(interface{}) error) error {
+	_, text, _ := limiter.feed("", "", true)
+	if text == "" {
+		return nil
+	}
+
+	response := &model.Response{Object: "chat.completion.chunk"}
+	if last != nil {
+		response.Id, response.Model, response.Created = last.Id, last.Model, last.Created
+	}
+
+	response.Choices = []model.Choice{
+		{
+			Index: 0,
+			Delta: &model.ChoiceDelta{Type: "text", Role: "assistant", Content: text},
+		},
+	}
+	return next(response)
+}
+
+// 停止词与 token 预算, 停止词可能跨分片时暂缓末尾
+type truncator struct {
+	stops   []string
+	budget  int
+	counter tokenizer.Counter
+	pending string
+}
+
+// 返回可写出的内容; reason 非空时表示需结束: stop | length
+func (limiter *truncator) feed(reasoning, content string, final bool) (r, t, reason string) {
+	r, exceeded := limiter.take(reasoning)
+	if exceeded {
+		limiter.pending = ""
+		return r, "", "length"
+	}
+
+	limiter.pending += content
+	index := -1
+	for _, stop := range limiter.stops {
+		if i := strings.Index(limiter.pending, stop); i >= 0 && (index < 0 || i < index) {
+			index = i
+		}
+	}
+
+	if index >= 0 {
+		t, reason = limiter.pending[:index], "stop"
+		limiter.pending = ""
+	} else {
+		keep := 0
+		if !final {
+			keep = partialSuffix(limiter.pending, limiter.stops...)
+		}
+		t = limiter.pending[:len(limiter.pending)-keep]
+		limiter.pending = limiter.pending[len(limiter.pending)-keep:]
+	}
+
+	if t, exceeded = limiter.take(t); exceeded {
+		limiter.pending = ""
+		reason = "length"
+	}
+	return
+}
+
+// 计入预算, 超出时截断
+func (limiter *truncator) take(content string) (string, bool) {
+	if limiter.budget <= 0 {
+		return content, false
+	}
+
+	for i, r := range content {
+		limiter.counter.Add(r)
+		if limiter.counter.Tokens() > limiter.budget {
+			return content[:i], true
+		}
+	}
+	return content, false
+}
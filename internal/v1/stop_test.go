@@ -0,0 +1,155 @@
+package v1
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+	"github.com/valyala/fasthttp"
+)
+
+func TestTruncatorStop(t *testing.T) {
+	cases := []struct {
+		content string
+		stops   []string
+		text    string
+		reason  string
+	}{
+		{"Hello END world", []string{"END"}, "Hello ", "stop"},
+		{"a\n\nObservation: b", []string{"\n\nObservation:", "STOP"}, "a", "stop"},
+		{"xSTOPyEND", []string{"END", "STOP"}, "x", "stop"},
+		{"no stop here EN", []string{"END"}, "no stop here EN", ""},
+		{"ENENDD", []string{"END"}, "EN", "stop"},
+	}
+
+	for _, tc := range cases {
+		for _, chunks := range splitsOf(tc.content) {
+			limiter := &truncator{stops: tc.stops}
+			var text strings.Builder
+			reason := ""
+			for i, chunk := range chunks {
+				_, out, r := limiter.feed("", chunk, i == len(chunks)-1)
+				text.WriteString(out)
+				if r != "" {
+					reason = r
+					break
+				}
+			}
+
+			if text.String() != tc.text || reason != tc.reason {
+				t.Fatalf("%q: text = %q, reason = %q", chunks, text.String(), reason)
+			}
+		}
+	}
+}
+
+func TestTruncatorBudget(t *testing.T) {
+	content := strings.Repeat("hello world ", 20)
+	for _, chunks := range splitsOf(content) {
+		limiter := &truncator{budget: 5}
+		var text strings.Builder
+		reason := ""
+		for i, chunk := range chunks {
+			_, out, r := limiter.feed("", chunk, i == len(chunks)-1)
+			text.WriteString(out)
+			if r != "" {
+				reason = r
+				break
+			}
+		}
+
+		if reason != "length" || text.Len() == 0 || text.Len() >= len(content) {
+			t.Fatalf("%d chunks: text = %q, reason = %q", len(chunks), text.String(), reason)
+		}
+	}
+}
+
+func TestLimitInterceptorAfterTruncation(t *testing.T) {
+	app := fiber.New()
+	ctx := app.AcquireCtx(new(fasthttp.RequestCtx))
+	defer app.ReleaseCtx(ctx)
+	c := model.New(ctx)
+	c.Put("completion", &model.Completion{Model: "gpt-4o", Messages: []model.CompletionMessage{{"role": "user", "content": "hi"}}})
+
+	interceptor := limitInterceptor(&truncator{stops: []string{"END"}})
+	var text strings.Builder
+	var received []interface{}
+	next := func(msg interface{}) error {
+		received = append(received, msg)
+		if response, ok := msg.(*model.Response); ok && len(response.Choices) > 0 {
+			text.WriteString(response.Choices[0].Delta.Content)
+		}
+		return nil
+	}
+
+	call := model.ChoiceToolCall{"id": "call_1", "type": "function", "function": map[string]interface{}{"name": "f", "arguments": "{}"}}
+	chunks := []interface{}{
+		&model.Response{Choices: []model.Choice{{Delta: &model.ChoiceDelta{Content: "done E"}}}},
+		&model.Response{Choices: []model.Choice{{Delta: &model.ChoiceDelta{Content: "ND ignored", ToolCalls: []model.ChoiceToolCall{call}}}}},
+		&model.Response{Choices: []model.Choice{{Delta: &model.ChoiceDelta{Content: "more"}}}},
+		&model.Response{Choices: []model.Choice{{Delta: &model.ChoiceDelta{}}}, Usage: map[string]interface{}{"total_tokens": 7}},
+		io.EOF,
+		model.Flush,
+	}
+	for _, chunk := range chunks {
+		if err := interceptor(c, chunk, next); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if text.String() != "done " {
+		t.Fatalf("text = %q", text.String())
+	}
+	if c.Context().Err() == nil {
+		t.Fatal("upstream request should be cancelled after truncation")
+	}
+
+	truncated := received[1].(*model.Response).Choices[0]
+	if *truncated.FinishReason != "stop" || len(truncated.Delta.ToolCalls) != 1 {
+		t.Fatalf("truncated chunk = %+v", truncated)
+	}
+
+	// 用量为本地估算, 不等待上游的用量分片
+	usage, ok := received[2].(*model.Response)
+	if !ok || len(usage.Choices) != 0 || usage.Usage["completion_tokens"] == nil || usage.Usage["total_tokens"] == 7 {
+		t.Fatalf("usage chunk = %#v", received[2])
+	}
+	if received[3] != io.EOF || received[4] != model.Flush || len(received) != 5 {
+		t.Fatalf("received = %#v", received)
+	}
+}
+
+func TestEnforceLimitsDefault(t *testing.T) {
+	app := fiber.New()
+	output := func(enabled interface{}) string {
+		vip := viper.New()
+		if enabled != nil {
+			vip.Set("enforcement.stop", enabled)
+		}
+		Env = &Environ{Viper: vip}
+		defer func() { Env = nil }()
+
+		ctx := app.AcquireCtx(new(fasthttp.RequestCtx))
+		defer app.ReleaseCtx(ctx)
+		c := model.New(ctx)
+		enforceLimits(c, &model.Completion{StopSequences: []string{"END"}})
+
+		var content string
+		c.Redirect(func(kind string, msg interface{}) error {
+			content = msg.(*model.Response).Choices[0].Message.Content
+			return nil
+		})
+		_ = c.JSON(&model.Response{Choices: []model.Choice{{Message: &model.ChoiceMessage{Content: "a END b"}}}})
+		return content
+	}
+
+	if content := output(nil); content != "a " {
+		t.Fatalf("limits should be enforced by default: %q", content)
+	}
+	if content := output(false); content != "a END b" {
+		t.Fatalf("limits should be disabled by config: %q", content)
+	}
+}
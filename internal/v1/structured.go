@@ -0,0 +1,272 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// 结构化输出要求
+type outputFormat struct {
+	typ    string
+	schema *jsonschema.Schema
+}
+
+// 解析 response_format, 为不支持原生结构化输出的适配器注入提示词
+//
+//	structured-output:
+//	  enabled: true
+//	  repair: true      # 修复代码块、尾随逗号、未闭合的括号
+//	  retries: 2        # 校验失败时携带错误信息重新请求的次数
+func formatOf(completion *model.Completion, adapter model.Adapter) (format *outputFormat, err error) {
+	if len(completion.ResponseFormat) == 0 || !enforced("structured-output.enabled") {
+		return
+	}
+
+	typ, _ := completion.ResponseFormat["type"].(string)
+	var prompt string
+	switch typ {
+	case "json_object":
+		format = &outputFormat{typ: typ}
+		prompt = "Respond with a single valid JSON object only. Do not wrap it in markdown code fences or add any other text."
+
+	case "json_schema":
+		spec, _ := completion.ResponseFormat["json_schema"].(map[string]interface{})
+		schema := spec["schema"]
+		if schema == nil {
+			return nil, errors.New("response_format.json_schema.schema is required")
+		}
+
+		chunk, _ := json.Marshal(schema)
+		format = &outputFormat{typ: typ}
+		if format.schema, err = compileSchema(chunk); err != nil {
+			return nil, fmt.Errorf("invalid response_format.json_schema.schema: %v", err)
+		}
+
+		prompt = "Respond with a single valid JSON value only. Do not wrap it in markdown code fences or add any other text. " +
+			"The JSON must conform to the following JSON Schema:\n" + string(chunk)
+
+	default:
+		return
+	}
+
+	if native, ok := adapter.(interface{ StructuredOutput() bool }); ok && native.StructuredOutput() {
+		return
+	}
+
+	appendSystem(completion, prompt)
+	completion.ResponseFormat = nil
+	return
+}
+
+func compileSchema(chunk []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err = compiler.AddResource("mem:///response_format.json", doc); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("mem:///response_format.json")
+}
+
+// 校验输出, 返回修复后的内容
+func (format *outputFormat) check(content string) (string, error) {
+	if enforced("structured-output.repair") {
+		content = repairJSON(content)
+	}
+
+	value, err := jsonschema.UnmarshalJSON(strings.NewReader(content))
+	if err != nil {
+		return content, fmt.Errorf("output is not valid JSON: %v", err)
+	}
+
+	if format.typ == "json_object" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return content, errors.New("output is not a JSON object")
+		}
+		return content, nil
+	}
+
+	if err = format.schema.Validate(value); err != nil {
+		return content, err
+	}
+	return content, nil
+}
+
+// 结构化输出: 缓冲完整输出并校验, 失败时携带错误信息重新请求, 通过后一次性写出.
+// 输出处理与适配器 After 已在派生上下文中执行, 写出时仍经过全局 After、计量与审计
+func enforceFormat(c *model.Ctx, entry registered, completion *model.Completion, format *outputFormat, tools map[string]bool) (err error) {
+	retries := 2
+	if Env != nil && Env.IsSet("structured-output.retries") {
+		retries = Env.GetInt("structured-output.retries")
+	}
+
+	usage := make(model.ResponseUsage)
+	for attempt := 0; ; attempt++ {
+		fork := c.Fork()
+		fork.Annotate("attempt", attempt+1)
+		fork.Put("completion", completion)
+		postprocess(fork, completion, tools)
+
+		agg := new(model.Aggregator)
+		fork.Redirect(func(kind string, msg interface{}) error {
+			agg.Add(msg)
+			return nil
+		})
+
+		if err = mount(fork, entry); err != nil {
+			return writeUnavailable(c.Ctx(), err)
+		}
+		err = traceCall(fork, entry.Adapter, "relay", entry.Adapter.Relay)
+		// 写出拦截器中暂缓的内容
+		fork.SSE(func(writer func(interface{}) error) { _ = writer(model.Flush) })
+		fork.Cancel()
+		if err == nil {
+			err = agg.Err
+		}
+		if err != nil {
+			return writeUnavailable(c.Ctx(), err)
+		}
+
+		response := agg.Response()
+		sumUsage(usage, response.Usage)
+		message := response.Choices[0].Message
+		if len(message.ToolCalls) > 0 {
+			response.Usage = usage
+			return replay(c, response, completion.Stream, model.StageProcess, model.StageAdapter)
+		}
+
+		content, e := format.check(message.Content)
+		if e == nil {
+			message.Content = content
+			response.Usage = usage
+			return replay(c, response, completion.Stream, model.StageProcess, model.StageAdapter)
+		}
+
+		if attempt >= retries {
+			return writeErrorf(c.Ctx(), fiber.StatusBadGateway, "server_error", "json_validate_failed",
+				fmt.Sprintf("Model output failed response_format validation after %d attempts: %v", attempt+1, e))
+		}
+
+		fork.Logger().Warnf("structured-output: attempt failed: %v", e)
+		retry := *completion
+		retry.Messages = append(append([]model.CompletionMessage{}, completion.Messages...),
+			model.CompletionMessage{"role": "assistant", "content": message.Content},
+			model.CompletionMessage{"role": "user", "content": "Your previous reply did not satisfy the required JSON format:\n" +
+				e.Error() + "\n\nReply again with only the corrected JSON."},
+		)
+		completion = &retry
+	}
+}
+
+// 累加多次请求的用量
+func sumUsage(total, usage model.ResponseUsage) {
+	for key, value := range usage {
+		var n int64
+		switch v := value.(type) {
+		case float64:
+			n = int64(v)
+		case int:
+			n = int64(v)
+		case int64:
+			n = v
+		default:
+			continue
+		}
+
+		switch v := total[key].(type) {
+		case int64:
+			total[key] = v + n
+		default:
+			total[key] = n
+		}
+	}
+}
+
+// 修复常见的 JSON 缺陷: 代码块包裹、前后多余文本、尾随逗号、未闭合的字符串与括号
+func repairJSON(content string) string {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```")
+		if i := strings.IndexByte(content, '\n'); i >= 0 {
+			content = content[i+1:]
+		}
+		content = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+	}
+
+	if json.Valid([]byte(content)) {
+		return content
+	}
+
+	// 截取首个对象或数组
+	if i := strings.IndexAny(content, "{["); i > 0 {
+		content = content[i:]
+	}
+	if end := jsonEnd(content); end > 0 {
+		content = content[:end]
+	}
+
+	var out strings.Builder
+	var stack []byte
+	quoted, escaped := false, false
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+		if quoted {
+			out.WriteByte(ch)
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				quoted = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			quoted = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			trimTrailingComma(&out)
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+		out.WriteByte(ch)
+	}
+
+	if quoted {
+		if escaped {
+			out.WriteByte('\\')
+		}
+		out.WriteByte('"')
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		trimTrailingComma(&out)
+		out.WriteByte(stack[i])
+	}
+	return out.String()
+}
+
+func trimTrailingComma(out *strings.Builder) {
+	s := strings.TrimRight(out.String(), " \t\r\n")
+	if strings.HasSuffix(s, ",") {
+		s = s[:len(s)-1]
+		out.Reset()
+		out.WriteString(s)
+	}
+}
@@ -0,0 +1,111 @@
+package v1
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRepairJSON(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"valid", `{"a":1}`, `{"a":1}`},
+		{"fenced", "```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"surrounding text", `Sure! {"a":1} Hope it helps.`, `{"a":1}`},
+		{"trailing comma", `{"a":[1,2,],}`, `{"a":[1,2]}`},
+		{"unclosed bracket", `{"a":[1,2`, `{"a":[1,2]}`},
+		{"unclosed string", `{"a":"b`, `{"a":"b"}`},
+		{"comma in string", `{"a":"x,]"}`, `{"a":"x,]"}`},
+	}
+
+	for _, tc := range cases {
+		got := repairJSON(tc.content)
+		if got != tc.want {
+			t.Errorf("%s: repairJSON(%q) = %q, want %q", tc.name, tc.content, got, tc.want)
+		}
+		if !json.Valid([]byte(got)) {
+			t.Errorf("%s: %q is not valid JSON", tc.name, got)
+		}
+	}
+}
+
+func TestOutputFormatCheck(t *testing.T) {
+	schema, err := compileSchema([]byte(`{
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "age": {"type": "integer"}},
+		"required": ["name"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		format  *outputFormat
+		content string
+		valid   bool
+	}{
+		{"object", &outputFormat{typ: "json_object"}, `{"a":1}`, true},
+		{"array is not object", &outputFormat{typ: "json_object"}, `[1]`, false},
+		{"invalid json", &outputFormat{typ: "json_object"}, `hello`, false},
+		{"repaired object", &outputFormat{typ: "json_object"}, "```\n{\"a\":1,}\n```", true},
+		{"schema pass", &outputFormat{typ: "json_schema", schema: schema}, `{"name":"x","age":1}`, true},
+		{"schema missing required", &outputFormat{typ: "json_schema", schema: schema}, `{"age":1}`, false},
+		{"schema wrong type", &outputFormat{typ: "json_schema", schema: schema}, `{"name":"x","age":"1"}`, false},
+	}
+
+	for _, tc := range cases {
+		content, err := tc.format.check(tc.content)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: check(%q) err = %v", tc.name, tc.content, err)
+		}
+		if tc.valid && !json.Valid([]byte(content)) {
+			t.Errorf("%s: content %q is not valid JSON", tc.name, content)
+		}
+	}
+
+	if _, err = compileSchema([]byte(`{"type": 1}`)); err == nil {
+		t.Fatal("invalid schema should fail to compile")
+	}
+}
+
+func TestEnforceFormatMetered(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	order := new(hedgeOrder)
+	adapter := hedgedAdapter{content: "```json\n{\"a\":1,}\n```", order: order}
+	completion := &model.Completion{Model: "m", Messages: []model.CompletionMessage{{"role": "user", "content": "hi"}}}
+
+	app := fiber.New()
+	app.Post("/", func(ctx *fiber.Ctx) error {
+		c := model.New(ctx)
+		c.Intercept(order.interceptor("process"))
+		c.InterceptAt(model.StageGlobal, order.interceptor("global"))
+		c.InterceptAt(model.StageMeter, order.interceptor("meter"))
+		return enforceFormat(c, entryOf(adapter), completion, &outputFormat{typ: "json_object"}, nil)
+	})
+
+	response, err := app.Test(httptest.NewRequest("POST", "/", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if !strings.Contains(string(data), `{\"a\":1}`) {
+		t.Fatalf("body = %s", data)
+	}
+
+	// 适配器 After 在派生上下文执行一次, 写出时仍经过全局 After 与计量
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	if strings.Join(order.steps, ",") != "adapter,global,meter" {
+		t.Fatalf("order = %v", order.steps)
+	}
+}
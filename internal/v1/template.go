@@ -0,0 +1,24 @@
+package v1
+
+import (
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+)
+
+// 注册配置中的自定义对话模版, 供适配器通过 model.Render 使用
+//
+//	templates:
+//	  vicuna: |
+//	    {{range .Messages}}{{.Role}}: {{.Content}}
+//	    {{end}}{{if .Generation}}assistant: {{end}}
+func initTemplates() {
+	if Env == nil {
+		return
+	}
+
+	for name, text := range Env.GetStringMapString("templates") {
+		if err := model.RegisterTemplate(name, text); err != nil {
+			logger.Sugar().Errorf("templates: %v", err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bincooo/ago/model"
+	"github.com/bincooo/ago/tokenizer"
+	"github.com/gofiber/fiber/v2"
+)
+
+// 离线分词
+//
+//	tokenizer:
+//	  heuristic: false   # 仅使用启发式估算, 不加载 BPE 词表
+//	  fill-usage: true   # 上游未返回用量时估算并补全 usage
+func initTokenizer() {
+	if Env != nil {
+		tokenizer.UseHeuristic(Env.GetBool("tokenizer.heuristic"))
+	}
+}
+
+// 上游未返回用量时, 结束前补全估算的 usage; 需先于其它用量拦截器添加.
+// 位于输出处理之后, 估算的是截断、解析后实际写出的内容
+func meter(c *model.Ctx) {
+	if !enforced("tokenizer.fill-usage") {
+		return
+	}
+
+	agg := new(model.Aggregator)
+	reported := false
+	c.InterceptAt(model.StageMeter, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if _, ok := model.UsageOf(msg); ok {
+			reported = true
+		}
+
+		if reported {
+			return next(msg)
+		}
+
+		switch v := msg.(type) {
+		case *model.Response:
+			if v == nil || len(v.Choices) == 0 || v.Choices[0].Message == nil {
+				agg.Add(msg)
+				break
+			}
+
+			reported = true
+			agg.Add(msg)
+			response := *v
+			response.Usage = estimateUsage(c, agg)
+			return next(&response)
+
+		case error:
+			if v == io.EOF {
+				if err := fillUsage(c, agg, next); err != nil {
+					return err
+				}
+				reported = true
+			}
+
+		default:
+			if msg == model.Flush {
+				if err := fillUsage(c, agg, next); err != nil {
+					return err
+				}
+				reported = true
+			}
+		}
+		return next(msg)
+	})
+}
+
+// 写出仅包含 usage 的分片
+func fillUsage(c *model.Ctx, agg *model.Aggregator, next func(interface{}) error) error {
+	created := agg.Created
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+
+	return next(&model.Response{
+		Id:      agg.Id,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   agg.Model,
+		Choices: []model.Choice{},
+		Usage:   estimateUsage(c, agg),
+	})
+}
+
+func estimateUsage(c *model.Ctx, agg *model.Aggregator) model.ResponseUsage {
+	prompt := 0
+	mod := agg.Model
+	if completion, ok := model.GetValue[string, *model.Completion](c.Record, "completion"); ok {
+		prompt = tokenizer.Prompt(completion)
+		mod = completion.Model
+	}
+
+	output := tokenizer.Output(mod, agg.Content.String(), agg.Reasoning.String(), agg.ToolCalls)
+	return model.ResponseUsage{
+		"prompt_tokens":     prompt,
+		"completion_tokens": output,
+		"total_tokens":      prompt + output,
+	}
+}
+
+// 计算请求的输入 token 数, system 兼容字符串与 Anthropic 的内容块数组
+func countTokens(ctx *fiber.Ctx) error {
+	var body struct {
+		model.Completion
+		System json.RawMessage `json:"system"`
+	}
+	if err := json.Unmarshal(ctx.Body(), &body); err != nil {
+		return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "", err.Error())
+	}
+
+	completion := &body.Completion
+	completion.System = systemText(body.System)
+	return ctx.JSON(model.Record[string, any]{
+		"input_tokens": tokenizer.Prompt(completion),
+	})
+}
+
+// 系统提示词: 字符串或 [{"type": "text", "text": "..."}]
+func systemText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+
+	var blocks []model.Record[string, any]
+	if json.Unmarshal(raw, &blocks) != nil {
+		return ""
+	}
+
+	var texts []string
+	for _, block := range blocks {
+		if text, ok := block["text"].(string); ok {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
@@ -0,0 +1,569 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/bincooo/ago/model"
+	"github.com/google/uuid"
+)
+
+// 默认的工具提示词模版, 可通过 tool-emulation.template 覆盖
+//
+//	.Tools     工具列表: Name、Description、Parameters(JSON Schema 字符串)
+//	.Required  是否必须调用工具
+//	.Name      必须调用的工具名
+const toolTemplate = `# Tools
+
+You can call the tools listed below. To call a tool, reply with a block in exactly this format:
+
+<tool_call>
+{"name": "<tool name>", "arguments": {<arguments as a JSON object>}}
+</tool_call>
+
+You may reply with several <tool_call> blocks to call several tools. Tool results will be sent back to you inside <tool_result> blocks.
+{{- if .Name}}
+You MUST call the tool "{{.Name}}" in this reply.
+{{- else if .Required}}
+You MUST call at least one tool in this reply.
+{{- else}}
+Only call a tool when it is needed, otherwise answer normally.
+{{- end}}
+
+Available tools:
+{{- range .Tools}}
+
+## {{.Name}}
+{{- if .Description}}
+{{.Description}}
+{{- end}}
+Parameters: {{.Parameters}}
+{{- end}}`
+
+// 起止标记
+type marker struct {
+	start string
+	end   string
+}
+
+var (
+	toolMarkers = []*marker{
+		{"<tool_call>", "</tool_call>"},
+		{"```tool_call", "```"},
+		{"```json", "```"},
+	}
+
+	// 回复开头的裸 JSON
+	jsonMarker = &marker{}
+
+	xmlTagRegexp = regexp.MustCompile(`(?s)<(name|arguments|parameters)>(.*?)</(?:name|arguments|parameters)>`)
+)
+
+type emulatedTool struct {
+	Name        string
+	Description string
+	Parameters  string
+}
+
+// 为不支持原生函数调用的适配器模拟工具调用: 工具定义渲染为系统提示词,
+// 历史中的工具调用与结果转为文本; 返回需从输出中解析的工具名
+func emulateTools(completion *model.Completion, adapter model.Adapter) (names map[string]bool, err error) {
+	emulator, ok := adapter.(interface{ ToolEmulation() bool })
+	if !ok || !emulator.ToolEmulation() {
+		return
+	}
+
+	completion.Messages = flattenToolMessages(completion.Messages)
+	if len(completion.Tools) == 0 {
+		return
+	}
+
+	tools := toolsOf(completion.Tools)
+	choice, name := toolChoiceOf(completion.ToolChoice)
+	completion.Tools = nil
+	completion.ToolChoice = nil
+	if choice == "none" || len(tools) == 0 {
+		return
+	}
+
+	if name != "" {
+		tools = slices.DeleteFunc(tools, func(tool emulatedTool) bool { return tool.Name != name })
+	}
+
+	prompt, err := renderToolPrompt(tools, choice == "required", name)
+	if err != nil {
+		return
+	}
+
+	appendSystem(completion, prompt)
+	names = make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		names[tool.Name] = true
+	}
+	return
+}
+
+// 追加系统提示词
+func appendSystem(completion *model.Completion, prompt string) {
+	if completion.System != "" {
+		completion.System += "\n\n" + prompt
+	} else if len(completion.Messages) > 0 && model.RoleOf(completion.Messages[0]) == "system" {
+		if content, ok := completion.Messages[0]["content"].(string); ok {
+			completion.Messages[0]["content"] = content + "\n\n" + prompt
+		} else {
+			completion.Messages = slices.Insert(completion.Messages, 1, model.CompletionMessage{"role": "system", "content": prompt})
+		}
+	} else {
+		completion.Messages = slices.Insert(completion.Messages, 0, model.CompletionMessage{"role": "system", "content": prompt})
+	}
+}
+
+func renderToolPrompt(tools []emulatedTool, required bool, name string) (string, error) {
+	text := toolTemplate
+	if Env != nil && Env.GetString("tool-emulation.template") != "" {
+		text = Env.GetString("tool-emulation.template")
+	}
+
+	tmpl, err := template.New("tools").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("tool-emulation: parse template failed: %v", err)
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, map[string]interface{}{
+		"Tools":    tools,
+		"Required": required,
+		"Name":     name,
+	})
+	return buffer.String(), err
+}
+
+// 兼容 OpenAI 与 Anthropic 的工具定义
+func toolsOf(tools []model.CompletionTool) (result []emulatedTool) {
+	for _, tool := range tools {
+		fn := model.Record[string, any](tool)
+		if function := model.FunctionOf(model.ChoiceToolCall(tool)); len(function) > 0 {
+			fn = function
+		}
+
+		name, _ := fn["name"].(string)
+		if name == "" {
+			continue
+		}
+
+		description, _ := fn["description"].(string)
+		parameters := fn["parameters"]
+		if parameters == nil {
+			parameters = fn["input_schema"]
+		}
+		chunk, _ := json.Marshal(parameters)
+		result = append(result, emulatedTool{name, description, string(chunk)})
+	}
+	return
+}
+
+// 解析 tool_choice: none | auto | required | 指定工具
+func toolChoiceOf(choice interface{}) (mode, name string) {
+	switch v := choice.(type) {
+	case string:
+		if v == "any" {
+			return "required", ""
+		}
+		return v, ""
+	case map[string]interface{}:
+		record := model.Record[string, any](v)
+		if fn := model.FunctionOf(model.ChoiceToolCall(record)); fn["name"] != nil {
+			name, _ = fn["name"].(string)
+			return "required", name
+		}
+		if typ, _ := record["type"].(string); typ == "tool" {
+			name, _ = record["name"].(string)
+			return "required", name
+		}
+		if typ, _ := record["type"].(string); typ != "" {
+			return toolChoiceOf(typ)
+		}
+	}
+	return "auto", ""
+}
+
+// 历史消息中的工具调用与结果转为文本
+func flattenToolMessages(messages []model.CompletionMessage) []model.CompletionMessage {
+	names := make(map[string]string)
+	result := make([]model.CompletionMessage, 0, len(messages))
+	for _, message := range messages {
+		switch model.RoleOf(message) {
+		case "assistant":
+			calls := model.ToolCallsOf(message)
+			if len(calls) == 0 {
+				result = append(result, message)
+				continue
+			}
+
+			var content strings.Builder
+			content.WriteString(model.TextOf(message))
+			for _, call := range calls {
+				name, _ := model.FunctionOf(call)["name"].(string)
+				if id, _ := call["id"].(string); id != "" {
+					names[id] = name
+				}
+				if content.Len() > 0 {
+					content.WriteString("\n")
+				}
+				chunk, _ := json.Marshal(map[string]interface{}{
+					"name":      name,
+					"arguments": model.ArgumentsValue(call),
+				})
+				content.WriteString("<tool_call>\n" + string(chunk) + "\n</tool_call>")
+			}
+			result = append(result, model.CompletionMessage{"role": "assistant", "content": content.String()})
+
+		case "tool":
+			id, _ := message["tool_call_id"].(string)
+			name, _ := message["name"].(string)
+			if name == "" {
+				name = names[id]
+			}
+			result = append(result, model.CompletionMessage{
+				"role":    "user",
+				"content": fmt.Sprintf("<tool_result id=\"%s\" name=\"%s\">\n%s\n</tool_result>", id, name, model.TextOf(message)),
+			})
+
+		default:
+			result = append(result, message)
+		}
+	}
+	return result
+}
+
+// 解析输出中的工具调用, 并从内容中移除调用标记
+func toolInterceptor(names map[string]bool) model.Interceptor {
+	parser := &toolParser{names: names}
+	var last *model.Response
+	return func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		switch v := msg.(type) {
+		case *model.Response:
+			if v == nil || len(v.Choices) == 0 {
+				break
+			}
+
+			response := *v
+			response.Choices = slices.Clone(v.Choices)
+			choice := &response.Choices[0]
+			if choice.Message != nil {
+				message := *choice.Message
+				text, calls := parser.feed(message.Content, true)
+				for _, call := range calls {
+					delete(call, "index")
+				}
+				message.Content = text
+				message.ToolCalls = append(message.ToolCalls, calls...)
+				choice.Message = &message
+				if parser.index > 0 {
+					finishReason := "tool_calls"
+					choice.FinishReason = &finishReason
+				}
+				return next(&response)
+			}
+
+			if choice.Delta == nil {
+				break
+			}
+
+			delta := *choice.Delta
+			text, calls := parser.feed(delta.Content, choice.FinishReason != nil)
+			swallowed := delta.Content != "" && text == ""
+			delta.Content = text
+			delta.ToolCalls = append(delta.ToolCalls, calls...)
+			choice.Delta = &delta
+			last = &response
+
+			if choice.FinishReason != nil && parser.index > 0 {
+				finishReason := "tool_calls"
+				choice.FinishReason = &finishReason
+			}
+
+			if swallowed && len(delta.ToolCalls) == 0 && delta.ReasoningContent == "" &&
+				choice.FinishReason == nil && len(response.Usage) == 0 {
+				return nil
+			}
+			return next(&response)
+
+		case error:
+			if v == io.EOF {
+				if err := flushToolCalls(parser, last, next); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if msg == model.Flush {
+				if err := flushToolCalls(parser, last, next); err != nil {
+					return err
+				}
+			}
+		}
+		return next(msg)
+	}
+}
+
+// 写出解析器中剩余的内容
+func flushToolCalls(parser *toolParser, last *model.Response, next func(interface{}) error) error {
+	text, calls := parser.feed("", true)
+	if text == "" && len(calls) == 0 {
+		return nil
+	}
+
+	response := &model.Response{Object: "chat.completion.chunk"}
+	if last != nil {
+		response.Id, response.Model, response.Created = last.Id, last.Model, last.Created
+	}
+
+	response.Choices = []model.Choice{
+		{
+			Index: 0,
+			Delta: &model.ChoiceDelta{Type: "text", Role: "assistant", Content: text, ToolCalls: calls},
+		},
+	}
+	return next(response)
+}
+
+// 流式工具调用解析器, 支持 <tool_call> 标签、代码块与回复开头的裸 JSON
+type toolParser struct {
+	names   map[string]bool
+	pending string
+	marker  *marker
+	emitted bool
+	index   int
+}
+
+// 输入一段内容, 返回可写出的文本与解析出的工具调用; final 为 true 时写出全部缓冲
+func (parser *toolParser) feed(content string, final bool) (text string, calls []model.ChoiceToolCall) {
+	parser.pending += content
+	var out strings.Builder
+	write := func(s string) {
+		out.WriteString(s)
+		if strings.TrimSpace(s) != "" {
+			parser.emitted = true
+		}
+	}
+
+	for {
+		if parser.marker == nil {
+			if !parser.emitted {
+				trimmed := strings.TrimLeft(parser.pending, " \t\r\n")
+				if trimmed == "" {
+					if final {
+						write(parser.pending)
+						parser.pending = ""
+					}
+					break
+				}
+
+				if trimmed[0] == '{' || trimmed[0] == '[' {
+					parser.pending = trimmed
+					parser.marker = jsonMarker
+					continue
+				}
+			}
+
+			index, block := -1, (*marker)(nil)
+			for _, m := range toolMarkers {
+				if i := strings.Index(parser.pending, m.start); i >= 0 && (index < 0 || i < index) {
+					index, block = i, m
+				}
+			}
+
+			if block == nil {
+				keep := 0
+				if !final {
+					keep = partialSuffix(parser.pending, startsOf(toolMarkers)...)
+				}
+				write(parser.pending[:len(parser.pending)-keep])
+				parser.pending = parser.pending[len(parser.pending)-keep:]
+				break
+			}
+
+			write(parser.pending[:index])
+			parser.pending = parser.pending[index+len(block.start):]
+			parser.marker = block
+			continue
+		}
+
+		body, rest, found := "", "", false
+		if parser.marker == jsonMarker {
+			if end := jsonEnd(parser.pending); end > 0 {
+				body, rest, found = parser.pending[:end], parser.pending[end:], true
+			}
+		} else if i := strings.Index(parser.pending, parser.marker.end); i >= 0 {
+			body, rest, found = parser.pending[:i], parser.pending[i+len(parser.marker.end):], true
+		}
+
+		if !found {
+			if final {
+				// 未闭合的调用块, 尽量解析, 失败则原样写出
+				if parsed := parser.parse(parser.pending); parsed != nil {
+					calls = append(calls, parsed...)
+				} else {
+					write(parser.marker.start + parser.pending)
+				}
+				parser.pending = ""
+				parser.marker = nil
+			}
+			break
+		}
+
+		if parsed := parser.parse(body); parsed != nil {
+			calls = append(calls, parsed...)
+		} else {
+			write(parser.marker.start + body + parser.marker.end)
+		}
+		parser.pending = rest
+		parser.marker = nil
+	}
+	return out.String(), calls
+}
+
+// 解析调用块, 任一工具名不在定义中则视为普通文本
+func (parser *toolParser) parse(body string) (calls []model.ChoiceToolCall) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil
+	}
+
+	var values []interface{}
+	if strings.HasPrefix(body, "<") {
+		matches := xmlTagRegexp.FindAllStringSubmatch(body, -1)
+		value := make(map[string]interface{})
+		for _, match := range matches {
+			value[match[1]] = strings.TrimSpace(match[2])
+		}
+		values = append(values, value)
+	} else {
+		var value interface{}
+		if err := json.Unmarshal([]byte(body), &value); err != nil {
+			return nil
+		}
+		values = append(values, value)
+	}
+
+	var collect func(value interface{}) bool
+	collect = func(value interface{}) bool {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				if !collect(item) {
+					return false
+				}
+			}
+			return len(v) > 0
+
+		case map[string]interface{}:
+			if list, ok := v["tool_calls"]; ok {
+				return collect(list)
+			}
+			if fn, ok := v["function"].(map[string]interface{}); ok {
+				v = fn
+			}
+
+			name, _ := v["name"].(string)
+			if !parser.names[name] {
+				return false
+			}
+
+			arguments := v["arguments"]
+			if arguments == nil {
+				arguments = v["parameters"]
+			}
+			if arguments == nil {
+				arguments = v["input"]
+			}
+
+			var chunk string
+			switch args := arguments.(type) {
+			case string:
+				chunk = args
+			case nil:
+				chunk = "{}"
+			default:
+				data, _ := json.Marshal(args)
+				chunk = string(data)
+			}
+
+			calls = append(calls, model.ChoiceToolCall{
+				"index": parser.index + len(calls),
+				"id":    "call_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:24],
+				"type":  "function",
+				"function": model.Record[string, any]{
+					"name":      name,
+					"arguments": chunk,
+				},
+			})
+			return true
+		}
+		return false
+	}
+
+	for _, value := range values {
+		if !collect(value) {
+			return nil
+		}
+	}
+
+	parser.index += len(calls)
+	return
+}
+
+// 首个完整 JSON 值的结束位置, 未闭合时返回 -1
+func jsonEnd(content string) int {
+	depth, quoted, escaped := 0, false, false
+	for i, r := range content {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted:
+			switch r {
+			case '\\':
+				escaped = true
+			case '"':
+				quoted = false
+			}
+		case r == '"':
+			quoted = true
+		case r == '{' || r == '[':
+			depth++
+		case r == '}' || r == ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// 末尾可能是某个标记前缀的长度, 需暂缓写出
+func partialSuffix(content string, tokens ...string) (n int) {
+	for _, token := range tokens {
+		for size := min(len(token)-1, len(content)); size > n; size-- {
+			if strings.HasSuffix(content, token[:size]) {
+				n = size
+				break
+			}
+		}
+	}
+	return
+}
+
+func startsOf(markers []*marker) (starts []string) {
+	for _, m := range markers {
+		starts = append(starts, m.start)
+	}
+	return
+}
@@ -0,0 +1,167 @@
+package v1
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bincooo/ago/model"
+)
+
+// 逐段输入解析器, 返回写出的文本与工具调用
+func parseTools(chunks ...string) (string, []model.ChoiceToolCall) {
+	parser := &toolParser{names: map[string]bool{"get_weather": true}}
+	var out strings.Builder
+	var calls []model.ChoiceToolCall
+	for i, chunk := range chunks {
+		text, parsed := parser.feed(chunk, false)
+		out.WriteString(text)
+		calls = append(calls, parsed...)
+		if i == len(chunks)-1 {
+			text, parsed = parser.feed("", true)
+			out.WriteString(text)
+			calls = append(calls, parsed...)
+		}
+	}
+	return out.String(), calls
+}
+
+func TestToolParser(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		text    string
+		calls   []string
+	}{
+		{
+			name:    "tag",
+			content: "Let me check.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>",
+			text:    "Let me check.\n",
+			calls:   []string{`get_weather {"city":"Paris"}`},
+		},
+		{
+			name:    "multiple tags",
+			content: "<tool_call>{\"name\":\"get_weather\",\"arguments\":{\"city\":\"A\"}}</tool_call><tool_call>{\"name\":\"get_weather\",\"arguments\":{\"city\":\"B\"}}</tool_call>",
+			calls:   []string{`get_weather {"city":"A"}`, `get_weather {"city":"B"}`},
+		},
+		{
+			name:    "tool_call fence",
+			content: "ok\n```tool_call\n{\"name\": \"get_weather\", \"arguments\": {}}\n```\ndone",
+			text:    "ok\n\ndone",
+			calls:   []string{`get_weather {}`},
+		},
+		{
+			name:    "json fence",
+			content: "```json\n{\"name\": \"get_weather\", \"parameters\": {\"city\": \"Rome\"}}\n```",
+			calls:   []string{`get_weather {"city":"Rome"}`},
+		},
+		{
+			name:    "json fence without tool",
+			content: "Example:\n```json\n{\"a\": 1}\n```",
+			text:    "Example:\n```json\n{\"a\": 1}\n```",
+		},
+		{
+			name:    "bare json",
+			content: "  {\"name\": \"get_weather\", \"arguments\": \"{\\\"city\\\":\\\"Oslo\\\"}\"}",
+			calls:   []string{`get_weather {"city":"Oslo"}`},
+		},
+		{
+			name:    "bare array",
+			content: "[{\"function\": {\"name\": \"get_weather\", \"arguments\": {}}}]",
+			calls:   []string{`get_weather {}`},
+		},
+		{
+			name:    "text starting with brace",
+			content: "{braces} are used for sets",
+			text:    "{braces} are used for sets",
+		},
+		{
+			name:    "json that is not a call",
+			content: "{\"answer\": 42} is the result",
+			text:    "{\"answer\": 42} is the result",
+		},
+		{
+			name:    "unknown tool",
+			content: "<tool_call>{\"name\": \"rm\", \"arguments\": {}}</tool_call>",
+			text:    "<tool_call>{\"name\": \"rm\", \"arguments\": {}}</tool_call>",
+		},
+		{
+			name:    "unclosed tag",
+			content: "<tool_call>{\"name\": \"get_weather\", \"arguments\": {}}",
+			calls:   []string{`get_weather {}`},
+		},
+		{
+			name:    "xml body",
+			content: "<tool_call><name>get_weather</name><arguments>{\"city\": \"Lima\"}</arguments></tool_call>",
+			calls:   []string{`get_weather {"city": "Lima"}`},
+		},
+		{
+			name:    "marker prefix in text",
+			content: "a < b and x <tool",
+			text:    "a < b and x <tool",
+		},
+	}
+
+	for _, tc := range cases {
+		// 在每个字节处切分为两段, 并逐字节输入
+		splits := [][]string{{tc.content}, strings.Split(tc.content, "")}
+		for i := 1; i < len(tc.content); i++ {
+			splits = append(splits, []string{tc.content[:i], tc.content[i:]})
+		}
+
+		for _, chunks := range splits {
+			text, calls := parseTools(chunks...)
+			if text != tc.text {
+				t.Fatalf("%s %q: text = %q, want %q", tc.name, chunks, text, tc.text)
+			}
+			if len(calls) != len(tc.calls) {
+				t.Fatalf("%s %q: %d calls, want %d", tc.name, chunks, len(calls), len(tc.calls))
+			}
+			for i, call := range calls {
+				got := model.FunctionOf(call)["name"].(string) + " " + compactJSON(model.ArgumentsOf(call))
+				if got != expectedCall(tc.calls[i]) {
+					t.Fatalf("%s %q: call %d = %s, want %s", tc.name, chunks, i, got, tc.calls[i])
+				}
+				if call["index"] != i {
+					t.Fatalf("%s: call %d index = %v", tc.name, i, call["index"])
+				}
+			}
+		}
+	}
+}
+
+func TestFlattenToolMessages(t *testing.T) {
+	messages := flattenToolMessages([]model.CompletionMessage{
+		{"role": "assistant", "tool_calls": []interface{}{
+			map[string]interface{}{"id": "1", "type": "function", "function": map[string]interface{}{"name": "get_weather", "arguments": `{"city":"Paris"}`}},
+			map[string]interface{}{"id": "2", "type": "function", "function": map[string]interface{}{"name": "get_weather", "arguments": ""}},
+			map[string]interface{}{"id": "3", "type": "function", "function": map[string]interface{}{"name": "get_weather", "arguments": "{city: Paris"}},
+		}},
+		{"role": "tool", "tool_call_id": "1", "content": "sunny"},
+	})
+
+	content := model.TextOf(messages[0])
+	for _, want := range []string{`"arguments":{"city":"Paris"}`, `"arguments":{}`, `"arguments":"{city: Paris"`} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("missing %s in %s", want, content)
+		}
+	}
+	if !strings.Contains(model.TextOf(messages[1]), `name="get_weather"`) {
+		t.Fatalf("tool result: %s", model.TextOf(messages[1]))
+	}
+}
+
+func compactJSON(value string) string {
+	var v interface{}
+	if json.Unmarshal([]byte(value), &v) != nil {
+		return value
+	}
+	chunk, _ := json.Marshal(v)
+	return string(chunk)
+}
+
+// 期望值形如 "name {json}", 参数规范化后比较
+func expectedCall(call string) string {
+	name, arguments, _ := strings.Cut(call, " ")
+	return name + " " + compactJSON(arguments)
+}
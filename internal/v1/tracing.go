@@ -0,0 +1,376 @@
+package v1
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/internal"
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// exporter 为 memory 时记录的 span
+	spanRecorder *memoryRecorder
+)
+
+// 链路追踪
+//
+//	tracing:
+//	  enabled: true
+//	  exporter: otlp            # otlp | memory
+//	  memory-size: 1000         # memory 导出器保留的 span 数
+//	  endpoint: http://localhost:4318
+//	  headers: { authorization: "Bearer xxx" }
+//	  service: ago
+//	  sample-ratio: 1.0
+//	  propagate: false          # 向上游请求注入 traceparent
+func tracingEnabled() bool {
+	return Env != nil && Env.GetBool("tracing.enabled")
+}
+
+func initTracing() {
+	if !tracingEnabled() {
+		return
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch Env.GetString("tracing.exporter") {
+	case "memory":
+		size := 1000
+		if n := Env.GetInt("tracing.memory-size"); n > 0 {
+			size = n
+		}
+		spanRecorder = &memoryRecorder{size: size}
+		exporter = spanRecorder
+	default:
+		var opts []otlptracehttp.Option
+		if endpoint := Env.GetString("tracing.endpoint"); strings.Contains(endpoint, "://") {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		} else if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+		}
+		if headers := Env.GetStringMapString("tracing.headers"); len(headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(headers))
+		}
+
+		var err error
+		if exporter, err = otlptracehttp.New(context.Background(), opts...); err != nil {
+			logger.Sugar().Errorf("tracing: create exporter failed: %v", err)
+			return
+		}
+	}
+
+	ratio := 1.0
+	if Env.IsSet("tracing.sample-ratio") {
+		ratio = Env.GetFloat64("tracing.sample-ratio")
+	}
+	service := Env.GetString("tracing.service")
+	if service == "" {
+		service = "ago"
+	}
+
+	provider := newTracerProvider(exporter, service, ratio)
+	internal.AddExited(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = provider.Shutdown(ctx)
+	})
+}
+
+func newTracerProvider(exporter sdktrace.SpanExporter, service string, ratio float64) *sdktrace.TracerProvider {
+	processor := sdktrace.NewBatchSpanProcessor(exporter)
+	if _, ok := exporter.(*memoryRecorder); ok {
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(model.TracerName)
+}
+
+// 内存中的 span 导出器, 仅保留最近的 size 条
+type memoryRecorder struct {
+	mu    sync.Mutex
+	size  int
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (recorder *memoryRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.spans = append(recorder.spans, spans...)
+	if over := len(recorder.spans) - recorder.size; over > 0 {
+		recorder.spans = slices.Delete(recorder.spans, 0, over)
+	}
+	return nil
+}
+
+func (recorder *memoryRecorder) Shutdown(context.Context) error {
+	return nil
+}
+
+func (recorder *memoryRecorder) snapshot() []sdktrace.ReadOnlySpan {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return slices.Clone(recorder.spans)
+}
+
+// 请求头载体
+type headerCarrier struct {
+	ctx *fiber.Ctx
+}
+
+func (carrier headerCarrier) Get(key string) string {
+	return carrier.ctx.Get(key)
+}
+
+func (carrier headerCarrier) Set(key, value string) {
+	carrier.ctx.Request().Header.Set(key, value)
+}
+
+func (carrier headerCarrier) Keys() (keys []string) {
+	carrier.ctx.Request().Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return
+}
+
+// 服务端 span, 追踪标识写入响应头 x-trace-id; 流式响应写完后结束
+func traceRequest(ctx *fiber.Ctx) (err error) {
+	if !tracingEnabled() || exempt(ctx) {
+		return ctx.Next()
+	}
+
+	parent := otel.GetTextMapPropagator().Extract(ctx.UserContext(), headerCarrier{ctx})
+	method, path := strings.Clone(ctx.Method()), strings.Clone(ctx.Path())
+	spanCtx, span := tracer().Start(parent, method+" "+path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.path", path),
+			attribute.String("client.address", strings.Clone(ctx.IP())),
+		))
+	ctx.SetUserContext(spanCtx)
+	if span.SpanContext().HasTraceID() {
+		ctx.Set("x-trace-id", span.SpanContext().TraceID().String())
+	}
+
+	err = ctx.Next()
+	if ctx.Response().IsBodyStream() {
+		return
+	}
+
+	status := ctx.Response().StatusCode()
+	if err != nil {
+		span.RecordError(err)
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+	endSpan(span, status)
+	return
+}
+
+// 流式响应中的错误记录到服务端 span, 写完后结束
+func traced(c *model.Ctx) {
+	span := trace.SpanFromContext(c.Context())
+	if !span.IsRecording() {
+		return
+	}
+
+	span.SetAttributes(attribute.String("ago.type", c.Type))
+	c.InterceptAt(model.StageCapture, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if err, ok := msg.(error); ok && err != io.EOF {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return next(msg)
+	})
+	c.OnDone(func() {
+		endSpan(span, fiber.StatusOK)
+	})
+}
+
+func endSpan(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// 在子 span 中调用适配器, 流式响应写完后结束. 适配器收到的是派生的上下文, 流式输出期间的出站请求同样归属该 span
+func traceCall(c *model.Ctx, adapter model.Adapter, op string, call func(*model.Ctx) error) (err error) {
+	name := nameOf(adapter)
+	parent := c.Context()
+	ctx, span := tracer().Start(parent, op+" "+name, trace.WithAttributes(
+		attribute.String("ago.adapter", name),
+		attribute.String("ago.operation", op),
+	))
+	if !span.IsRecording() {
+		span.End()
+		return call(c)
+	}
+
+	if c.Credential != nil {
+		span.SetAttributes(attribute.String("ago.credential", c.Credential.Id))
+	}
+
+	err = call(c.Derive(ctx))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	if c.Streaming() {
+		c.OnDone(func() { span.End() })
+	} else {
+		span.End()
+	}
+	return
+}
+
+// 出站请求的客户端 span, 响应体读完或关闭后结束; 命中调试抓取时记录请求与响应头
+type tracedTransport struct {
+	base http.RoundTripper
+}
+
+func (transport tracedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if set := captureOutbound(request); set != nil {
+		response, err := transport.trace(request)
+		if err != nil {
+			set.record("outbound_error", err.Error())
+		} else {
+			set.record("outbound_response", map[string]interface{}{
+				"status":  response.StatusCode,
+				"headers": map[string][]string(response.Header.Clone()),
+			})
+		}
+		return response, err
+	}
+	return transport.trace(request)
+}
+
+func (transport tracedTransport) trace(request *http.Request) (*http.Response, error) {
+	ctx, span := tracer().Start(request.Context(), "HTTP "+request.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", request.Method),
+			attribute.String("server.address", request.URL.Host),
+			attribute.String("url.path", request.URL.Path),
+		))
+	if !span.IsRecording() {
+		span.End()
+		return transport.base.RoundTrip(request)
+	}
+
+	request = request.Clone(ctx)
+	if Env != nil && Env.GetBool("tracing.propagate") {
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+	}
+
+	response, err := transport.base.RoundTrip(request)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return response, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+	if response.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, strconv.Itoa(response.StatusCode))
+	}
+	response.Body = &spanBody{ReadCloser: response.Body, span: span}
+	return response, nil
+}
+
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (body *spanBody) Read(p []byte) (n int, err error) {
+	n, err = body.ReadCloser.Read(p)
+	if err != nil {
+		body.end(err)
+	}
+	return
+}
+
+func (body *spanBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.end(nil)
+	return err
+}
+
+func (body *spanBody) end(err error) {
+	body.once.Do(func() {
+		if err != nil && err != io.EOF {
+			body.span.RecordError(err)
+		}
+		body.span.End()
+	})
+}
+
+// memory 导出器记录的 span
+func listTraces(ctx *fiber.Ctx) error {
+	if spanRecorder == nil {
+		return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "tracing_disabled",
+			"In-memory tracing is disabled, configure `tracing.exporter: memory` to enable it.")
+	}
+
+	traceId := ctx.Query("trace_id")
+	spans := make([]fiber.Map, 0)
+	for _, stub := range spanRecorder.snapshot() {
+		if traceId != "" && stub.SpanContext().TraceID().String() != traceId {
+			continue
+		}
+
+		attrs := make(map[string]interface{}, len(stub.Attributes()))
+		for _, kv := range stub.Attributes() {
+			attrs[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		span := fiber.Map{
+			"name":       stub.Name(),
+			"trace_id":   stub.SpanContext().TraceID().String(),
+			"span_id":    stub.SpanContext().SpanID().String(),
+			"start":      stub.StartTime(),
+			"end":        stub.EndTime(),
+			"status":     stub.Status().Code.String(),
+			"attributes": attrs,
+		}
+		if stub.Parent().IsValid() {
+			span["parent_id"] = stub.Parent().SpanID().String()
+		}
+		spans = append(spans, span)
+	}
+	return ctx.JSON(spans)
+}
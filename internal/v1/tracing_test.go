@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// streamed 为 true 时在流式输出期间请求上游
+type tracedAdapter struct {
+	upstream string
+	streamed bool
+}
+
+func (tracedAdapter) Support(*model.Ctx, string) bool { return true }
+func (tracedAdapter) Embed(*model.Ctx) error          { return nil }
+func (tracedAdapter) Image(*model.Ctx) error          { return nil }
+func (tracedAdapter) Model() []model.Model            { return nil }
+func (tracedAdapter) Name() string                    { return "traced" }
+
+func (adapter tracedAdapter) Relay(c *model.Ctx) error {
+	fetch := func() (string, error) {
+		request, _ := http.NewRequestWithContext(c.Context(), http.MethodGet, adapter.upstream, nil)
+		response, err := (&http.Client{Transport: Transport("")}).Do(request)
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		data, err := io.ReadAll(response.Body)
+		return string(data), err
+	}
+
+	if adapter.streamed {
+		c.SSE(func(writer func(interface{}) error) {
+			data, _ := fetch()
+			_ = writer(data)
+			_ = writer(io.EOF)
+		})
+		return nil
+	}
+
+	data, err := fetch()
+	if err != nil {
+		return err
+	}
+	c.SSE(func(writer func(interface{}) error) {
+		_ = writer(data)
+		_ = writer(io.EOF)
+	})
+	return nil
+}
+
+func TestTracingSpans(t *testing.T) {
+	vip := viper.New()
+	vip.Set("tracing.enabled", true)
+	vip.Set("tracing.exporter", "memory")
+	vip.Set("tracing.propagate", true)
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+	initTracing()
+
+	traceparents := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	// streaming 时在流式输出期间请求上游, 需配合 -race 运行
+	for _, streamed := range []bool{false, true} {
+		adapter := tracedAdapter{upstream: upstream.URL, streamed: streamed}
+		app := fiber.New()
+		app.Use(traceRequest)
+		app.Post("/v1/chat/completions", func(ctx *fiber.Ctx) error {
+			c := model.New(ctx)
+			traced(c)
+			return traceCall(c, adapter, "relay", adapter.Relay)
+		})
+
+		response, err := app.Test(httptest.NewRequest("POST", "/v1/chat/completions", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := io.ReadAll(response.Body); string(data) == "" {
+			t.Fatalf("streamed=%v: empty body", streamed)
+		}
+
+		traceId := response.Header.Get("x-trace-id")
+		if traceId == "" {
+			t.Fatal("missing x-trace-id header")
+		}
+
+		spans := make(map[string]sdktrace.ReadOnlySpan)
+		for _, span := range spanRecorder.snapshot() {
+			if span.SpanContext().TraceID().String() == traceId {
+				spans[span.Name()] = span
+			}
+		}
+
+		server, ok := spans["POST /v1/chat/completions"]
+		if !ok {
+			t.Fatalf("streamed=%v: missing server span: %v", streamed, spans)
+		}
+		relay, ok := spans["relay traced"]
+		if !ok || relay.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Fatalf("streamed=%v: relay span should be a child of the server span: %v", streamed, spans)
+		}
+		outbound, ok := spans["HTTP GET"]
+		if !ok || outbound.Parent().SpanID() != relay.SpanContext().SpanID() {
+			t.Fatalf("streamed=%v: outbound span should be a child of the relay span: %v", streamed, spans)
+		}
+
+		if traceparent := <-traceparents; traceparent == "" {
+			t.Fatalf("streamed=%v: traceparent should be propagated upstream", streamed)
+		}
+	}
+}
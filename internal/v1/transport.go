@@ -0,0 +1,23 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bincooo/ja3"
+	xtls "github.com/refraction-networking/utls"
+)
+
+// 模拟浏览器指纹的 http 传输层, 开启链路追踪时记录出站请求
+func Transport(proxies string) http.RoundTripper {
+	return newTransport(proxies, http.DefaultTransport.(*http.Transport).Clone())
+}
+
+func newTransport(proxies string, base *http.Transport) http.RoundTripper {
+	base.IdleConnTimeout = 120 * time.Second
+	return tracedTransport{ja3.NewTransport(
+		ja3.WithProxy(proxies),
+		ja3.WithClientHelloID(xtls.HelloChrome_133),
+		ja3.WithOriginalTransport(base),
+	)}
+}
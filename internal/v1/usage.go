@@ -0,0 +1,649 @@
+package v1
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bincooo/ago/internal"
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	localUsage = "ago.usage"
+	usageDay   = "2006-01-02"
+	usageMonth = "2006-01"
+)
+
+var (
+	usages = &usageStore{
+		rows:   make(map[usageKey]*usageCounter),
+		totals: make(map[string]*usageCounter),
+		warned: make(map[string]bool),
+	}
+)
+
+// 用量统计与费用估算, 按天汇总到客户端密钥、模型与适配器
+//
+//	usage:
+//	  enabled: true
+//	  state: tmp/usage.json
+//	  retention: 90          # 保留天数
+//	  prices:                # 每百万 token 的价格, 按顺序匹配模型
+//	    - match: "gpt-4o*"
+//	      input: 2.5
+//	      output: 10
+//	      request: 0         # 每次请求的固定费用
+//	  budgets:               # 未命名的密钥按 key-<摘要> 统计
+//	    - key: team-a        # 密钥名称或密钥, * 对每个密钥分别生效
+//	      period: month      # day | month
+//	      cost: 100          # 费用上限
+//	      tokens: 0          # token 上限
+//	      soft: 0.8          # 达到比例时告警, 响应头 x-budget-warning
+//	      hard: 1.0          # 达到比例时拒绝请求
+type usagePrice struct {
+	Match   string  `mapstructure:"match"`
+	Input   float64 `mapstructure:"input"`
+	Output  float64 `mapstructure:"output"`
+	Request float64 `mapstructure:"request"`
+}
+
+type usageBudget struct {
+	Key    string  `mapstructure:"key"`
+	Period string  `mapstructure:"period"`
+	Cost   float64 `mapstructure:"cost"`
+	Tokens int64   `mapstructure:"tokens"`
+	Soft   float64 `mapstructure:"soft"`
+	Hard   float64 `mapstructure:"hard"`
+}
+
+// 汇总维度
+type usageKey struct {
+	Day     string `json:"day,omitempty"`
+	Client  string `json:"client,omitempty"`
+	Model   string `json:"model,omitempty"`
+	Adapter string `json:"adapter,omitempty"`
+}
+
+// 汇总计数
+type usageCounter struct {
+	Requests   int64   `json:"requests"`
+	Prompt     int64   `json:"prompt_tokens"`
+	Completion int64   `json:"completion_tokens"`
+	Cost       float64 `json:"cost"`
+}
+
+// 持久化的一行
+type usageRow struct {
+	usageKey
+	usageCounter
+}
+
+type usageStore struct {
+	mu sync.RWMutex
+
+	rows map[usageKey]*usageCounter
+	// 按客户端与周期 (天或月) 的合计, 用于预算校验
+	totals map[string]*usageCounter
+	// 已告警的预算周期
+	warned map[string]bool
+	dirty  bool
+
+	// 初始化时解析的价格与预算
+	prices  []usagePrice
+	budgets []usageBudget
+}
+
+// 单个请求的统计状态
+type usageTrail struct {
+	mu sync.Mutex
+
+	client  string
+	model   string
+	adapter string
+	counted bool
+}
+
+func usageEnabled() bool {
+	return Env != nil && Env.GetBool("usage.enabled")
+}
+
+func initUsage() {
+	if !usageEnabled() {
+		return
+	}
+
+	usages.configure()
+	usages.restore()
+	internal.AddExited(usages.save)
+	go func() {
+		for range time.Tick(time.Minute) {
+			usages.save()
+		}
+	}()
+}
+
+func (counter *usageCounter) add(other usageCounter) {
+	counter.Requests += other.Requests
+	counter.Prompt += other.Prompt
+	counter.Completion += other.Completion
+	counter.Cost += other.Cost
+}
+
+// 解析价格与预算配置
+func (store *usageStore) configure() {
+	var prices []usagePrice
+	if err := Env.UnmarshalKey("usage.prices", &prices); err != nil {
+		logger.Sugar().Errorf("usage: load prices failed: %v", err)
+	}
+	var budgets []usageBudget
+	if err := Env.UnmarshalKey("usage.budgets", &budgets); err != nil {
+		logger.Sugar().Errorf("usage: load budgets failed: %v", err)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.prices, store.budgets = prices, budgets
+}
+
+// 估算费用, 需持有锁
+func (store *usageStore) priceOf(mod string, usage usageCounter) float64 {
+	for _, price := range store.prices {
+		if ok, _ := path.Match(price.Match, mod); ok || price.Match == mod {
+			return float64(usage.Requests)*price.Request +
+				(float64(usage.Prompt)*price.Input+float64(usage.Completion)*price.Output)/1e6
+		}
+	}
+	return 0
+}
+
+func (store *usageStore) add(key usageKey, counter usageCounter) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	counter.Cost = store.priceOf(key.Model, counter)
+	row, ok := store.rows[key]
+	if !ok {
+		row = new(usageCounter)
+		store.rows[key] = row
+	}
+	row.add(counter)
+	store.total(key.Client, key.Day).add(counter)
+	store.total(key.Client, key.Day[:len(usageMonth)]).add(counter)
+	store.dirty = true
+}
+
+// 客户端在周期内的合计, 需持有锁
+func (store *usageStore) total(client, period string) *usageCounter {
+	k := client + "\x00" + period
+	total, ok := store.totals[k]
+	if !ok {
+		total = new(usageCounter)
+		store.totals[k] = total
+	}
+	return total
+}
+
+// 恢复持久化的用量
+func (store *usageStore) restore() {
+	file := Env.GetString("usage.state")
+	if file == "" {
+		return
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Sugar().Errorf("usage: read state failed: %v", err)
+		}
+		return
+	}
+
+	var rows []usageRow
+	if err = json.Unmarshal(data, &rows); err != nil {
+		logger.Sugar().Errorf("usage: parse state failed: %v", err)
+		return
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, row := range rows {
+		if len(row.Day) != len(usageDay) {
+			continue
+		}
+		counter := row.usageCounter
+		store.rows[row.usageKey] = &counter
+		store.total(row.Client, row.Day).add(counter)
+		store.total(row.Client, row.Day[:len(usageMonth)]).add(counter)
+	}
+}
+
+// 清理超出保留天数的记录并持久化
+func (store *usageStore) save() {
+	retention := Env.GetInt("usage.retention")
+	if retention <= 0 {
+		retention = 90
+	}
+	now := time.Now()
+	expired := now.AddDate(0, 0, -retention).Format(usageDay)
+	file := Env.GetString("usage.state")
+
+	store.mu.Lock()
+	store.prune(expired, now)
+	if file == "" || !store.dirty {
+		store.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(store.list(nil))
+	store.dirty = false
+	store.mu.Unlock()
+	if err != nil {
+		logger.Sugar().Errorf("usage: marshal state failed: %v", err)
+		return
+	}
+
+	if err = writeFile(file, data); err != nil {
+		logger.Sugar().Errorf("usage: save state failed: %v", err)
+	}
+}
+
+// 清理过期的记录与合计, 已告警记录只保留当前周期, 需持有锁
+func (store *usageStore) prune(expired string, now time.Time) {
+	for key := range store.rows {
+		if key.Day < expired {
+			delete(store.rows, key)
+		}
+	}
+
+	// 月合计在整月超出保留期后清理
+	for k := range store.totals {
+		_, period, _ := strings.Cut(k, "\x00")
+		if period < expired[:len(usageMonth)] || (len(period) == len(usageDay) && period < expired) {
+			delete(store.totals, k)
+		}
+	}
+
+	day, month := now.Format(usageDay), now.Format(usageMonth)
+	for k := range store.warned {
+		if _, period, _ := strings.Cut(k, "\x00"); period != day && period != month {
+			delete(store.warned, k)
+		}
+	}
+}
+
+// 各适配器的累计用量
+func (store *usageStore) byAdapter() map[string]usageCounter {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	counters := make(map[string]usageCounter)
+	for key, counter := range store.rows {
+		total := counters[key.Adapter]
+		total.add(*counter)
+		counters[key.Adapter] = total
+	}
+	return counters
+}
+
+// 按条件筛选并排序, 需持有锁
+func (store *usageStore) list(match func(usageKey) bool) []usageRow {
+	rows := make([]usageRow, 0, len(store.rows))
+	for key, counter := range store.rows {
+		if match == nil || match(key) {
+			rows = append(rows, usageRow{key, *counter})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].usageKey, rows[j].usageKey
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Client != b.Client {
+			return a.Client < b.Client
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.Adapter < b.Adapter
+	})
+	return rows
+}
+
+// 统计请求次数与上报的用量
+func accounted(c *model.Ctx, mod string) {
+	if !usageEnabled() {
+		return
+	}
+
+	trail := newUsageTrail(c, mod)
+	c.Ctx().Locals(localUsage, trail)
+	trail.intercept(c)
+}
+
+// 派生的内部请求 (如上下文总结) 按其模型与适配器单独计入, 不影响原请求的统计
+func accountedFork(fork *model.Ctx, mod string, entry registered) {
+	if !usageEnabled() {
+		return
+	}
+
+	trail := newUsageTrail(fork, mod)
+	trail.counted = true
+	trail.adapter = entry.Id
+	trail.add(usageCounter{Requests: 1})
+	trail.intercept(fork)
+}
+
+func newUsageTrail(c *model.Ctx, mod string) *usageTrail {
+	trail := &usageTrail{model: mod}
+	if key, ok := c.Ctx().Locals(localKey).(*apiKey); ok {
+		trail.client = key.account()
+	}
+	return trail
+}
+
+func (trail *usageTrail) intercept(c *model.Ctx) {
+	c.InterceptAt(model.StageMeter, func(c *model.Ctx, msg interface{}, next func(interface{}) error) error {
+		if usage, ok := model.UsageOf(msg); ok {
+			trail.add(usageCounter{Prompt: usage.Prompt(), Completion: usage.Completion()})
+		}
+		return next(msg)
+	})
+}
+
+// 分发到适配器时计入一次请求
+func usageDispatched(c *model.Ctx, entry registered) {
+	usageRequested(c, entry.Id)
+}
+
+// 计入一次请求, 缓存命中时适配器记为 cache
+func usageRequested(c *model.Ctx, adapter string) {
+	trail, ok := c.Ctx().Locals(localUsage).(*usageTrail)
+	if !ok {
+		return
+	}
+
+	trail.mu.Lock()
+	if trail.counted {
+		trail.mu.Unlock()
+		return
+	}
+	trail.counted = true
+	trail.adapter = adapter
+	trail.mu.Unlock()
+	trail.add(usageCounter{Requests: 1})
+}
+
+func (trail *usageTrail) add(counter usageCounter) {
+	trail.mu.Lock()
+	key := usageKey{
+		Day:     time.Now().Format(usageDay),
+		Client:  trail.client,
+		Model:   trail.model,
+		Adapter: trail.adapter,
+	}
+	trail.mu.Unlock()
+	usages.add(key, counter)
+}
+
+// 客户端适用的预算
+func budgetsOf(key *apiKey) (list []usageBudget) {
+	usages.mu.RLock()
+	budgets := usages.budgets
+	usages.mu.RUnlock()
+	for _, budget := range budgets {
+		if budget.Key == key.Name || budget.Key == key.Key {
+			list = append(list, budget)
+		} else if ok, _ := path.Match(budget.Key, key.account()); ok {
+			list = append(list, budget)
+		}
+	}
+	return
+}
+
+// 当前周期及已用比例
+func (budget *usageBudget) status(client string, now time.Time) (period string, spent usageCounter, ratio float64) {
+	period = now.Format(usageDay)
+	if budget.Period != "day" {
+		period = now.Format(usageMonth)
+	}
+
+	usages.mu.RLock()
+	if total, ok := usages.totals[client+"\x00"+period]; ok {
+		spent = *total
+	}
+	usages.mu.RUnlock()
+
+	if budget.Cost > 0 {
+		ratio = spent.Cost / budget.Cost
+	}
+	if budget.Tokens > 0 {
+		ratio = max(ratio, float64(spent.Prompt+spent.Completion)/float64(budget.Tokens))
+	}
+	return
+}
+
+func (budget *usageBudget) thresholds() (soft, hard float64) {
+	soft, hard = budget.Soft, budget.Hard
+	if hard <= 0 {
+		hard = 1
+	}
+	if soft <= 0 || soft > hard {
+		soft = hard
+	}
+	return
+}
+
+// 预算中间件: 超过硬限制时拒绝请求, 超过软限制时告警
+func enforceBudget(ctx *fiber.Ctx) error {
+	if !usageEnabled() || exempt(ctx) {
+		return ctx.Next()
+	}
+
+	key, ok := ctx.Locals(localKey).(*apiKey)
+	if !ok {
+		return ctx.Next()
+	}
+
+	client := key.account()
+	for _, budget := range budgetsOf(key) {
+		period, _, ratio := budget.status(client, time.Now())
+		soft, hard := budget.thresholds()
+		if ratio >= hard {
+			return writeErrorf(ctx, fiber.StatusTooManyRequests, "insufficient_quota", "budget_exceeded",
+				fmt.Sprintf("You exceeded your %s budget (%.0f%% used).", budget.periodName(), ratio*100))
+		}
+
+		if ratio >= soft {
+			ctx.Set("x-budget-warning", fmt.Sprintf("%.0f%% of %s budget used", ratio*100, budget.periodName()))
+			usages.mu.Lock()
+			warned := usages.warned[client+"\x00"+period]
+			usages.warned[client+"\x00"+period] = true
+			usages.mu.Unlock()
+			if !warned {
+				logger.Sugar().Warnf("usage: client [%s] used %.0f%% of %s budget", key.id(), ratio*100, budget.periodName())
+			}
+		}
+	}
+	return ctx.Next()
+}
+
+func (budget *usageBudget) periodName() string {
+	if budget.Period == "day" {
+		return "daily"
+	}
+	return "monthly"
+}
+
+// 用量查询条件, 日期为闭区间
+func usageFilter(ctx *fiber.Ctx) (func(usageKey) bool, error) {
+	from, to := ctx.Query("from"), ctx.Query("to")
+	for _, value := range []string{from, to} {
+		if _, err := time.Parse(usageDay, value); value != "" && err != nil {
+			return nil, fmt.Errorf("invalid date [%s], expected YYYY-MM-DD", value)
+		}
+	}
+
+	client, mod, adapter := ctx.Query("key"), ctx.Query("model"), ctx.Query("adapter")
+	return func(key usageKey) bool {
+		if (from != "" && key.Day < from) || (to != "" && key.Day > to) {
+			return false
+		}
+		if client != "" && key.Client != client {
+			return false
+		}
+		if adapter != "" && key.Adapter != adapter {
+			return false
+		}
+		if mod != "" {
+			if ok, _ := path.Match(mod, key.Model); !ok && mod != key.Model {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// 按 group 指定的维度合并, 如 group=client,model; 为空时按全部维度
+func groupUsage(rows []usageRow, group string) []usageRow {
+	if group == "" {
+		return rows
+	}
+
+	dimensions := strings.Split(group, ",")
+	merged := make(map[usageKey]*usageCounter)
+	var order []usageKey
+	for _, row := range rows {
+		var key usageKey
+		for _, dimension := range dimensions {
+			switch strings.TrimSpace(dimension) {
+			case "day":
+				key.Day = row.Day
+			case "month":
+				key.Day = row.Day[:len(usageMonth)]
+			case "key", "client":
+				key.Client = row.Client
+			case "model":
+				key.Model = row.Model
+			case "adapter":
+				key.Adapter = row.Adapter
+			}
+		}
+
+		counter, ok := merged[key]
+		if !ok {
+			counter = new(usageCounter)
+			merged[key] = counter
+			order = append(order, key)
+		}
+		counter.add(row.usageCounter)
+	}
+
+	result := make([]usageRow, 0, len(order))
+	for _, key := range order {
+		result = append(result, usageRow{key, *merged[key]})
+	}
+	return result
+}
+
+// 查询并合并用量, ok 为 false 时已写出错误响应
+func queryUsage(ctx *fiber.Ctx) (rows []usageRow, ok bool, err error) {
+	if !usageEnabled() {
+		return nil, false, writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "usage_disabled",
+			"Usage accounting is disabled, configure `usage.enabled` to enable it.")
+	}
+
+	match, err := usageFilter(ctx)
+	if err != nil {
+		return nil, false, writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_query", err.Error())
+	}
+
+	usages.mu.RLock()
+	rows = usages.list(match)
+	usages.mu.RUnlock()
+	return groupUsage(rows, ctx.Query("group")), true, nil
+}
+
+// 查询用量
+//
+//	GET /admin/usage?from=2025-01-01&to=2025-01-31&key=team-a&model=gpt-*&group=day,model
+func listUsage(ctx *fiber.Ctx) error {
+	rows, ok, err := queryUsage(ctx)
+	if !ok {
+		return err
+	}
+
+	var total usageCounter
+	for _, row := range rows {
+		total.add(row.usageCounter)
+	}
+	return ctx.JSON(fiber.Map{"data": rows, "total": total})
+}
+
+// 以 CSV 导出用量, 参数同 /admin/usage
+func exportUsage(ctx *fiber.Ctx) error {
+	rows, ok, err := queryUsage(ctx)
+	if !ok {
+		return err
+	}
+
+	ctx.Set("content-type", "text/csv; charset=utf-8")
+	ctx.Set("content-disposition", `attachment; filename="usage.csv"`)
+	writer := csv.NewWriter(ctx)
+	_ = writer.Write([]string{"day", "client", "model", "adapter", "requests", "prompt_tokens", "completion_tokens", "cost"})
+	for _, row := range rows {
+		_ = writer.Write([]string{
+			row.Day, row.Client, row.Model, row.Adapter,
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatInt(row.Prompt, 10),
+			strconv.FormatInt(row.Completion, 10),
+			strconv.FormatFloat(row.Cost, 'f', 6, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// 各密钥的预算状态
+func listBudgets(ctx *fiber.Ctx) error {
+	if !usageEnabled() {
+		return writeErrorf(ctx, fiber.StatusNotFound, "invalid_request_error", "usage_disabled",
+			"Usage accounting is disabled, configure `usage.enabled` to enable it.")
+	}
+
+	keys.reload()
+	keys.mu.RLock()
+	list := make([]*apiKey, 0, len(keys.keys))
+	for _, key := range keys.keys {
+		list = append(list, key)
+	}
+	keys.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].account() < list[j].account() })
+
+	now := time.Now()
+	result := make([]fiber.Map, 0)
+	for _, key := range list {
+		for _, budget := range budgetsOf(key) {
+			period, spent, ratio := budget.status(key.account(), now)
+			soft, hard := budget.thresholds()
+			state := "ok"
+			if ratio >= hard {
+				state = "exceeded"
+			} else if ratio >= soft {
+				state = "warning"
+			}
+			result = append(result, fiber.Map{
+				"key": key.account(), "period": period, "cost": budget.Cost, "tokens": budget.Tokens,
+				"soft": soft, "hard": hard, "spent": spent, "ratio": ratio, "state": state,
+			})
+		}
+	}
+	return ctx.JSON(result)
+}
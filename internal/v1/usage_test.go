@@ -0,0 +1,241 @@
+package v1
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+// 替换全局用量统计, 返回恢复函数
+func usageFixture(prices []usagePrice, budgets []usageBudget) func() {
+	saved := usages
+	usages = &usageStore{
+		rows:    make(map[usageKey]*usageCounter),
+		totals:  make(map[string]*usageCounter),
+		warned:  make(map[string]bool),
+		prices:  prices,
+		budgets: budgets,
+	}
+	return func() { usages = saved }
+}
+
+func TestKeyAccount(t *testing.T) {
+	a := &apiKey{Key: "sk-aaaa1111bbbb"}
+	b := &apiKey{Key: "sk-aaaa2222bbbb"}
+	if a.id() != b.id() {
+		t.Fatalf("fixture keys should share the display id: %s, %s", a.id(), b.id())
+	}
+	if a.account() == b.account() {
+		t.Fatalf("unnamed keys should be accounted separately: %s", a.account())
+	}
+	if a.account() != (&apiKey{Key: a.Key}).account() {
+		t.Fatal("account should be stable")
+	}
+	if named := (&apiKey{Key: a.Key, Name: "team-a"}); named.account() != "team-a" {
+		t.Fatalf("account = %s", named.account())
+	}
+}
+
+func TestUsageStore(t *testing.T) {
+	defer usageFixture([]usagePrice{
+		{Match: "gpt-4o-mini", Input: 0.1, Output: 0.2},
+		{Match: "gpt-4o*", Input: 1, Output: 2, Request: 0.01},
+	}, nil)()
+
+	day := time.Now().Format(usageDay)
+	cases := []struct {
+		key     usageKey
+		counter usageCounter
+		cost    float64
+	}{
+		{usageKey{Day: day, Client: "a", Model: "gpt-4o", Adapter: "x"}, usageCounter{Requests: 1, Prompt: 1e6, Completion: 1e6}, 3.01},
+		// 按顺序匹配价格
+		{usageKey{Day: day, Client: "a", Model: "gpt-4o-mini", Adapter: "x"}, usageCounter{Requests: 1, Prompt: 1e6}, 0.1},
+		{usageKey{Day: day, Client: "b", Model: "claude-3", Adapter: "y"}, usageCounter{Requests: 2, Prompt: 1e6}, 0},
+	}
+
+	for _, tc := range cases {
+		usages.add(tc.key, tc.counter)
+		if cost := usages.rows[tc.key].Cost; cost != tc.cost {
+			t.Errorf("%s: cost = %v, want %v", tc.key.Model, cost, tc.cost)
+		}
+	}
+
+	totals := map[string]usageCounter{
+		"a\x00" + day:                   {Requests: 2, Prompt: 2e6, Completion: 1e6, Cost: 3.11},
+		"a\x00" + day[:len(usageMonth)]: {Requests: 2, Prompt: 2e6, Completion: 1e6, Cost: 3.11},
+		"b\x00" + day[:len(usageMonth)]: {Requests: 2, Prompt: 1e6},
+	}
+	for k, want := range totals {
+		got := *usages.totals[k]
+		got.Cost = float64(int(got.Cost*100+0.5)) / 100
+		if got != want {
+			t.Errorf("total %q = %+v, want %+v", k, got, want)
+		}
+	}
+
+	if byAdapter := usages.byAdapter(); byAdapter["x"].Requests != 2 || byAdapter["y"].Requests != 2 {
+		t.Errorf("byAdapter = %+v", byAdapter)
+	}
+}
+
+func TestEnforceBudget(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	vip := viper.New()
+	vip.Set("usage.enabled", true)
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	// 展示形式相同的两个未命名密钥
+	spender := &apiKey{Key: "sk-aaaa1111bbbb"}
+	other := &apiKey{Key: "sk-aaaa2222bbbb"}
+
+	cases := []struct {
+		name    string
+		budgets []usageBudget
+		key     *apiKey
+		status  int
+		warning bool
+	}{
+		{"no budget", nil, spender, fiber.StatusOK, false},
+		{"under soft", []usageBudget{{Key: "*", Cost: 100, Soft: 0.8}}, spender, fiber.StatusOK, false},
+		{"soft", []usageBudget{{Key: "*", Cost: 10, Soft: 0.5}}, spender, fiber.StatusOK, true},
+		{"hard", []usageBudget{{Key: "*", Cost: 5}}, spender, fiber.StatusTooManyRequests, false},
+		{"tokens", []usageBudget{{Key: "*", Tokens: 1000}}, spender, fiber.StatusTooManyRequests, false},
+		{"by key", []usageBudget{{Key: spender.Key, Cost: 5}}, spender, fiber.StatusTooManyRequests, false},
+		{"by account", []usageBudget{{Key: spender.account(), Cost: 5, Period: "day"}}, spender, fiber.StatusTooManyRequests, false},
+		// 其他密钥的用量不计入
+		{"other key", []usageBudget{{Key: "*", Cost: 5}}, other, fiber.StatusOK, false},
+		{"not matched", []usageBudget{{Key: "team-b", Cost: 5}}, spender, fiber.StatusOK, false},
+	}
+
+	for _, tc := range cases {
+		restore := usageFixture([]usagePrice{{Match: "*", Request: 6}}, tc.budgets)
+		usages.add(usageKey{Day: time.Now().Format(usageDay), Client: spender.account(), Model: "m"},
+			usageCounter{Requests: 1, Prompt: 2000})
+
+		app := fiber.New()
+		app.Use(func(ctx *fiber.Ctx) error {
+			ctx.Locals(localKey, tc.key)
+			return ctx.Next()
+		}, enforceBudget)
+		app.Post("/v1/chat/completions", func(ctx *fiber.Ctx) error { return ctx.SendStatus(fiber.StatusOK) })
+
+		response, err := app.Test(httptest.NewRequest("POST", "/v1/chat/completions", nil), -1)
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.name, response.StatusCode, tc.status)
+		}
+		if warning := response.Header.Get("x-budget-warning"); (warning != "") != tc.warning {
+			t.Errorf("%s: x-budget-warning = %q", tc.name, warning)
+		}
+	}
+}
+
+func TestGroupUsage(t *testing.T) {
+	rows := []usageRow{
+		{usageKey{Day: "2025-01-01", Client: "a", Model: "m1", Adapter: "x"}, usageCounter{Requests: 1, Prompt: 10}},
+		{usageKey{Day: "2025-01-02", Client: "a", Model: "m2", Adapter: "x"}, usageCounter{Requests: 2, Prompt: 20}},
+		{usageKey{Day: "2025-02-01", Client: "b", Model: "m1", Adapter: "y"}, usageCounter{Requests: 4, Completion: 40}},
+	}
+
+	cases := []struct {
+		group string
+		want  []usageRow
+	}{
+		{"", rows},
+		{"client", []usageRow{
+			{usageKey{Client: "a"}, usageCounter{Requests: 3, Prompt: 30}},
+			{usageKey{Client: "b"}, usageCounter{Requests: 4, Completion: 40}},
+		}},
+		{"month, model", []usageRow{
+			{usageKey{Day: "2025-01", Model: "m1"}, usageCounter{Requests: 1, Prompt: 10}},
+			{usageKey{Day: "2025-01", Model: "m2"}, usageCounter{Requests: 2, Prompt: 20}},
+			{usageKey{Day: "2025-02", Model: "m1"}, usageCounter{Requests: 4, Completion: 40}},
+		}},
+		{"adapter", []usageRow{
+			{usageKey{Adapter: "x"}, usageCounter{Requests: 3, Prompt: 30}},
+			{usageKey{Adapter: "y"}, usageCounter{Requests: 4, Completion: 40}},
+		}},
+	}
+
+	for _, tc := range cases {
+		got := groupUsage(rows, tc.group)
+		if len(got) != len(tc.want) {
+			t.Errorf("group %q = %+v", tc.group, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("group %q [%d] = %+v, want %+v", tc.group, i, got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+// 输出固定内容且不返回用量的总结模型
+type summaryAdapter struct{ model.BasicAdapter }
+
+func (summaryAdapter) Support(_ *model.Ctx, mod string) bool { return mod == "summary" }
+func (summaryAdapter) Model() []model.Model                  { return []model.Model{{Id: "summary"}} }
+
+func (summaryAdapter) Relay(c *model.Ctx) error {
+	c.SSE(func(writer func(interface{}) error) {
+		_ = writer(&model.Response{Model: "summary", Choices: []model.Choice{{Delta: &model.ChoiceDelta{Content: "the user said hello"}}}})
+		_ = writer(io.EOF)
+	})
+	return nil
+}
+
+func TestSummaryMetered(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	vip := viper.New()
+	vip.Set("usage.enabled", true)
+	vip.Set("context-window.summary-model", "summary")
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+	defer usageFixture(nil, nil)()
+
+	savedKeys := keys
+	defer func() { keys = savedKeys }()
+	keys = &keyStore{keys: make(map[string]*apiKey), usage: make(map[string]*keyUsage)}
+
+	AddAdapter(summaryAdapter{})
+	defer RemoveAdapter(nameOf(summaryAdapter{}))
+
+	key := &apiKey{Key: "sk-aaaa1111bbbb"}
+	c := conversationCtx(t, "")
+	c.Ctx().Locals(localKey, key)
+	accounted(c, "m")
+
+	summary, err := summarizeMessages(c, []model.CompletionMessage{{"role": "user", "content": "hello"}}, 100)
+	if err != nil || summary != "the user said hello" {
+		t.Fatalf("summary = %q, err = %v", summary, err)
+	}
+
+	// 原请求未被计为分发
+	if trail := c.Ctx().Locals(localUsage).(*usageTrail); trail.counted || trail.adapter != "" {
+		t.Fatalf("summary should not dispatch the request: %+v", trail)
+	}
+
+	summaryKey := usageKey{Day: time.Now().Format(usageDay), Client: key.account(), Model: "summary", Adapter: nameOf(summaryAdapter{})}
+	row, ok := usages.rows[summaryKey]
+	if !ok || len(usages.rows) != 1 {
+		t.Fatalf("rows = %+v", usages.rows)
+	}
+	if row.Requests != 1 || row.Prompt == 0 || row.Completion == 0 {
+		t.Fatalf("summary usage = %+v", row)
+	}
+	if consumed := keys.usage[key.Key]; consumed == nil || consumed.Tokens != row.Prompt+row.Completion {
+		t.Fatalf("key tokens = %+v, want %d", consumed, row.Prompt+row.Completion)
+	}
+}
@@ -3,28 +3,26 @@ package v1
 import (
 	"fmt"
 	"iter"
+	"strings"
 
+	"github.com/bincooo/ago/internal"
 	"github.com/bincooo/ago/logger"
 	"github.com/bincooo/ago/model"
 	"github.com/gofiber/contrib/fiberzap/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	adapters = make([]model.Adapter, 0)
-)
-
-func AddAdapter(adapter model.Adapter) {
-	adapters = append(adapters, adapter)
-}
-
-// 模型迭代器
+// 模型迭代器, 不含禁用的适配器
 func Models() iter.Seq[model.Model] {
 	return func(yield func(model.Model) bool) {
-		for _, adapter := range adapters {
-			for _, mod := range adapter.Model() {
-				yield(mod)
+		for _, entry := range adapters.enabled() {
+			for _, mod := range entry.models() {
+				if !yield(mod) {
+					return
+				}
 			}
 		}
 	}
@@ -41,16 +39,39 @@ func Initialized(addr string) {
 		},
 	}))
 
+	initTracing()
+	app.Use(identify)
+	app.Use(traceRequest)
 	app.Use(fiberzap.New(fiberzap.Config{
-		Logger: logger.Logger(),
+		Logger:     logger.Logger(),
+		FieldsFunc: logFields,
 	}))
+	app.Use(measure)
+	app.Use(audit)
+	app.Use(track)
+
+	initAuth()
+	initTokenizer()
+	initUsage()
+	// 初始化回调可能注册适配器、凭证与模板, 依赖它们的初始化在回调之后执行, 完成前转发请求返回 503
+	go internal.Initialized(initCredentials, initTemplates, initHealth, adapters.warnDuplicates)
+	app.Use(awaitReady)
+	app.Use(authenticate)
+	app.Use(rateLimit)
+	app.Use(enforceBudget)
 
 	app.Get("/", index)
+	healthRoutes(app)
+	adminRoutes(app)
+	metricsRoutes(app)
 
 	app.Post("v1/chat/completions", completions)
 	app.Post("v1/object/completions", completions)
 	app.Post("proxies/v1/chat/completions", completions)
 
+	app.Post("v1/messages/count_tokens", countTokens)
+	app.Post("proxies/v1/messages/count_tokens", countTokens)
+
 	app.Post("/v1/embeddings", embeddings)
 	app.Post("proxies/v1/embeddings", embeddings)
 
@@ -64,31 +85,67 @@ func Initialized(addr string) {
 	}
 }
 
-func index(ctx *fiber.Ctx) error {
-	ctx.Set("content-type", "text/html")
-	return JustError(
-		ctx.WriteString("<div style='color:green'>success ~</div>"),
-	)
-}
-
 func completions(ctx *fiber.Ctx) (err error) {
 	completion := new(model.Completion)
 	if err = ctx.BodyParser(completion); err != nil {
 		return
 	}
 
-	c := model.New(ctx)
-	c.Type = "relay"
+	c := newCtx(ctx, "relay", completion.Model)
 	c.Put("completion", completion)
-	for _, adapter := range adapters {
-		if !adapter.Support(c, completion.Model) {
-			continue
-		}
-		return adapter.Relay(c)
+	if handled, e := middleware(c); handled {
+		return e
 	}
 
-	err = writeError(ctx, fmt.Sprintf("model [%s] is not found", completion.Model))
-	return
+	if err = rewritePrompt(c, completion); err != nil {
+		return writeErrorf(ctx, fiber.StatusInternalServerError, "server_error", "", err.Error())
+	}
+
+	supported := supports(c, completion.Model)
+	if len(supported) == 0 {
+		err = writeError(ctx, fmt.Sprintf("model [%s] is not found", completion.Model))
+		return
+	}
+	if handled, e := charge(c); handled {
+		return e
+	}
+
+	adapter := supported[0].Adapter
+	tools, err := emulateTools(completion, adapter)
+	if err != nil {
+		return writeErrorf(ctx, fiber.StatusInternalServerError, "server_error", "", err.Error())
+	}
+
+	format, err := formatOf(completion, adapter)
+	if err != nil {
+		return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_response_format", err.Error())
+	}
+
+	if err = normalizeMedia(c, completion, adapter); err != nil {
+		return writeErrorf(ctx, fiber.StatusBadRequest, "invalid_request_error", "invalid_image", err.Error())
+	}
+
+	trimContext(c, completion, adapter)
+	if hit, e := cached(c, completion); hit {
+		return e
+	}
+
+	if format == nil {
+		postprocess(c, completion, tools)
+	}
+
+	if format != nil {
+		return enforceFormat(c, supported[0], completion, format, tools)
+	}
+
+	if len(supported) > 1 && hedging(completion.Model) {
+		return hedge(c, supported[:2]...)
+	}
+
+	if err = mount(c, supported[0]); err != nil {
+		return writeUnavailable(ctx, err)
+	}
+	return traceCall(c, adapter, "relay", adapter.Relay)
 }
 
 func embeddings(ctx *fiber.Ctx) (err error) {
@@ -97,14 +154,20 @@ func embeddings(ctx *fiber.Ctx) (err error) {
 		return
 	}
 
-	c := model.New(ctx)
-	c.Type = "embed"
+	c := newCtx(ctx, "embed", embedding.Model)
 	c.Put("embedding", embedding)
-	for _, adapter := range adapters {
-		if adapter.Support(c, embedding.Model) {
-			err = adapter.Embed(c)
-			break
+	if handled, e := middleware(c); handled {
+		return e
+	}
+	for _, entry := range supports(c, embedding.Model) {
+		if handled, e := charge(c); handled {
+			return e
+		}
+		if err = mount(c, entry); err != nil {
+			return writeUnavailable(ctx, err)
 		}
+		err = traceCall(c, entry.Adapter, "embed", entry.Adapter.Embed)
+		break
 	}
 
 	err = writeError(ctx, fmt.Sprintf("model [%s] is not found", embedding.Model))
@@ -117,22 +180,139 @@ func generations(ctx *fiber.Ctx) (err error) {
 		return
 	}
 
-	c := model.New(ctx)
-	c.Type = "image"
+	c := newCtx(ctx, "image", generation.Model)
 	c.Put("generation", generation)
-	for _, adapter := range adapters {
-		if adapter.Support(c, generation.Model) {
-			return adapter.Image(c)
+	if handled, e := middleware(c); handled {
+		return e
+	}
+	for _, entry := range supports(c, generation.Model) {
+		if handled, e := charge(c); handled {
+			return e
+		}
+		if err = mount(c, entry); err != nil {
+			return writeUnavailable(ctx, err)
 		}
+		return traceCall(c, entry.Adapter, "image", entry.Adapter.Image)
 	}
 
 	err = writeError(ctx, fmt.Sprintf("model [%s] is not found", generation.Model))
 	return
 }
 
+func newCtx(ctx *fiber.Ctx, typ, mod string) *model.Ctx {
+	c := model.New(ctx)
+	c.Type = typ
+	c.Annotate("request_id", requestIdOf(ctx))
+	c.Annotate("model", mod)
+	c.Annotate("attempt", 1)
+	observe(c, mod)
+	traced(c)
+	captureRequest(c, mod)
+	audited(c, mod)
+	tracked(c, mod)
+	if typ == "relay" {
+		meter(c)
+	}
+	authorize(c)
+	accounted(c, mod)
+	throttle(c)
+	return c
+}
+
+// 派生的内部请求 (如上下文总结) 与客户端请求同样计量: 补全用量, 消耗密钥配额与 TPM, 并单独计入用量统计
+func meterFork(fork *model.Ctx, mod string, entry registered) {
+	meter(fork)
+	authorize(fork)
+	accountedFork(fork, mod, entry)
+	throttle(fork)
+}
+
+// 输出后处理: 思考内容提取、工具调用解析、停止词与长度限制
+func postprocess(c *model.Ctx, completion *model.Completion, tools map[string]bool) {
+	extractReasoning(c, completion.Model)
+	if len(tools) > 0 {
+		c.Intercept(toolInterceptor(tools))
+	}
+	enforceLimits(c, completion)
+}
+
+// 支持该模型的适配器, 跳过禁用与不健康的适配器; 返回注册项, 分发时按注册标识统计
+func supports(c *model.Ctx, mod string) (supported []registered) {
+	_, span := tracer().Start(c.Context(), "select adapter", trace.WithAttributes(attribute.String("ago.model", mod)))
+	defer span.End()
+
+	for _, entry := range adapters.enabled() {
+		if entry.support(c, mod) {
+			supported = append(supported, entry)
+		}
+	}
+	supported = skipUnhealthy(supported)
+
+	if span.IsRecording() {
+		names := make([]string, 0, len(supported))
+		for _, entry := range supported {
+			names = append(names, entry.Id)
+		}
+		span.SetAttributes(attribute.StringSlice("ago.adapters", names))
+	}
+	return
+}
+
+// 调用适配器前的准备: 记录分发的适配器, 从凭证池挂载凭证
+func mount(c *model.Ctx, entry registered) (err error) {
+	dispatched(c, entry)
+	return pick(c, entry.Adapter)
+}
+
+// 从适配器的凭证池选取凭证
+func pick(c *model.Ctx, adapter model.Adapter) (err error) {
+	pooled, ok := adapter.(interface{ Pool() string })
+	if !ok || pooled.Pool() == "" {
+		return
+	}
+
+	c.Credential, err = credentials.pick(pooled.Pool(), c)
+	return
+}
+
+// 不经过鉴权与限流的路径, 调试台请求在其路由内单独校验
+func exempt(ctx *fiber.Ctx) bool {
+	if playing, _ := ctx.Locals(localPlayground).(bool); playing {
+		return false
+	}
+
+	p := ctx.Path()
+	return p == "/" || strings.HasPrefix(p, "/admin") || p == "/healthz" || p == "/readyz" ||
+		strings.HasPrefix(p, "/health/") || (metricsEnabled() && p == metricsPath())
+}
+
+// 适配器名称
+func nameOf(adapter model.Adapter) string {
+	if named, ok := adapter.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", adapter)
+}
+
 func writeError(ctx *fiber.Ctx, msg string) (err error) {
 	return ctx.Status(fiber.StatusInternalServerError).
 		JSON(model.Record[string, any]{
 			"error": msg,
 		})
 }
+
+// OpenAI 风格的错误响应
+func writeErrorf(ctx *fiber.Ctx, status int, typ, code, msg string) (err error) {
+	return ctx.Status(status).
+		JSON(model.Record[string, any]{
+			"error": model.Record[string, any]{
+				"message": msg,
+				"type":    typ,
+				"code":    code,
+			},
+		})
+}
+
+func writeUnavailable(ctx *fiber.Ctx, err error) error {
+	return writeErrorf(ctx, fiber.StatusServiceUnavailable, "server_error", "service_unavailable", err.Error())
+}
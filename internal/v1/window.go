@@ -0,0 +1,250 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bincooo/ago/model"
+	"github.com/bincooo/ago/tokenizer"
+)
+
+// 上下文窗口覆盖配置
+//
+//	context-window:
+//	  enabled: true
+//	  strategy: truncate        # truncate | summarize
+//	  summary-model: gpt-4o-mini
+//	  models:
+//	    - match: "gpt-3.5*"
+//	      length: 16385
+//	      output: 4096
+type windowRule struct {
+	Match  string `mapstructure:"match"`
+	Length int    `mapstructure:"length"`
+	Output int    `mapstructure:"output"`
+}
+
+// 裁剪单元: 系统消息固定保留, 工具调用与其结果不拆分
+type turn struct {
+	messages []model.CompletionMessage
+	tokens   int
+	pinned   bool
+}
+
+// 超出上下文窗口时裁剪历史: 保留系统消息与最近的对话, 丢弃或总结中间部分
+func trimContext(c *model.Ctx, completion *model.Completion, adapter model.Adapter) {
+	if Env != nil && Env.IsSet("context-window.enabled") && !Env.GetBool("context-window.enabled") {
+		return
+	}
+
+	length, output := windowOf(adapter, completion.Model)
+	if completion.MaxTokens > 0 && (output <= 0 || completion.MaxTokens < output) {
+		output = completion.MaxTokens
+	}
+
+	budget := length - output
+	if length <= 0 || budget <= 0 {
+		return
+	}
+
+	used := tokenizer.Count(completion.Model, completion.System)
+	if len(completion.Tools) > 0 {
+		chunk, _ := json.Marshal(completion.Tools)
+		used += tokenizer.Count(completion.Model, string(chunk))
+	}
+
+	turns := turnsOf(completion.Model, completion.Messages)
+	total := used
+	for _, t := range turns {
+		total += t.tokens
+		if t.pinned {
+			used += t.tokens
+		}
+	}
+	if total <= budget {
+		return
+	}
+
+	summarize := Env != nil && Env.GetString("context-window.strategy") == "summarize" &&
+		Env.GetString("context-window.summary-model") != ""
+	if summarize {
+		// 为总结预留四分之一的预算
+		budget -= budget / 4
+	}
+
+	// 自后向前保留连续的对话, 最后一轮总是保留
+	keep := make([]bool, len(turns))
+	for i := range turns {
+		keep[i] = turns[i].pinned
+	}
+
+	for i := len(turns) - 1; i >= 0; i-- {
+		if turns[i].pinned {
+			continue
+		}
+		if used+turns[i].tokens > budget && i != lastTurn(turns) {
+			break
+		}
+		keep[i] = true
+		used += turns[i].tokens
+	}
+
+	var kept, dropped []model.CompletionMessage
+	droppedTokens := 0
+	for i, t := range turns {
+		if keep[i] {
+			continue
+		}
+		dropped = append(dropped, t.messages...)
+		droppedTokens += t.tokens
+	}
+
+	if len(dropped) == 0 {
+		c.Logger().Warnf("context-window: [%s] pinned messages exceed %d tokens", completion.Model, budget)
+		return
+	}
+
+	var summary string
+	if summarize {
+		var err error
+		if summary, err = summarizeMessages(c, dropped, budget/3); err != nil {
+			c.Logger().Errorf("context-window: summarize failed: %v", err)
+		}
+	}
+
+	inserted := summary == ""
+	for i, t := range turns {
+		if !inserted && !t.pinned {
+			kept = append(kept, model.CompletionMessage{
+				"role":    "system",
+				"content": "Summary of the earlier conversation:\n" + summary,
+			})
+			inserted = true
+		}
+		if keep[i] {
+			kept = append(kept, t.messages...)
+		}
+	}
+
+	completion.Messages = kept
+	c.Ctx().Set("x-context-trimmed", fmt.Sprintf("messages=%d; tokens=%d; summarized=%t", len(dropped), droppedTokens, summary != ""))
+	c.Logger().Infof("context-window: [%s] trimmed %d messages (~%d tokens)", completion.Model, len(dropped), droppedTokens)
+}
+
+// 模型的上下文长度与最大输出, 配置优先于适配器声明
+func windowOf(adapter model.Adapter, mod string) (length, output int) {
+	for _, m := range adapter.Model() {
+		if m.Id == mod {
+			length, output = m.ContextLength, m.MaxOutput
+			break
+		}
+	}
+
+	if Env == nil {
+		return
+	}
+
+	var rules []windowRule
+	_ = Env.UnmarshalKey("context-window.models", &rules)
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Match, mod); ok || rule.Match == mod {
+			if rule.Length > 0 {
+				length = rule.Length
+			}
+			if rule.Output > 0 {
+				output = rule.Output
+			}
+			break
+		}
+	}
+	return
+}
+
+// 按轮次分组: 助手的工具调用与随后的工具结果合为一组
+func turnsOf(mod string, messages []model.CompletionMessage) (turns []turn) {
+	for _, message := range messages {
+		tokens := estimateMessage(mod, message)
+		role := model.RoleOf(message)
+		if role == "tool" && len(turns) > 0 && !turns[len(turns)-1].pinned {
+			last := &turns[len(turns)-1]
+			if model.RoleOf(last.messages[0]) == "assistant" && len(model.ToolCallsOf(last.messages[0])) > 0 {
+				last.messages = append(last.messages, message)
+				last.tokens += tokens
+				continue
+			}
+		}
+
+		turns = append(turns, turn{
+			messages: []model.CompletionMessage{message},
+			tokens:   tokens,
+			pinned:   role == "system",
+		})
+	}
+	return
+}
+
+func lastTurn(turns []turn) int {
+	for i := len(turns) - 1; i >= 0; i-- {
+		if !turns[i].pinned {
+			return i
+		}
+	}
+	return -1
+}
+
+// 单条消息的 token 估算, 含固定开销
+func estimateMessage(mod string, message model.CompletionMessage) int {
+	return tokenizer.Message(mod, message)
+}
+
+// 调用 context-window.summary-model 总结被裁剪的消息
+func summarizeMessages(c *model.Ctx, messages []model.CompletionMessage, maxTokens int) (string, error) {
+	mod := Env.GetString("context-window.summary-model")
+	supported := supports(c, mod)
+	if len(supported) == 0 {
+		return "", fmt.Errorf("summary model [%s] is not found", mod)
+	}
+
+	transcript, err := model.Render("plain", &model.Completion{Messages: messages},
+		model.TemplateOptions{Tools: true, Image: "[image]"})
+	if err != nil {
+		return "", err
+	}
+
+	fork := c.Fork()
+	fork.Put("completion", &model.Completion{
+		Model:     mod,
+		MaxTokens: maxTokens,
+		Messages: []model.CompletionMessage{
+			{"role": "system", "content": "Summarize the following conversation concisely. Keep facts, decisions, names, numbers and open questions that later messages may rely on. Reply with the summary only."},
+			{"role": "user", "content": transcript},
+		},
+	})
+
+	agg := new(model.Aggregator)
+	fork.Redirect(func(kind string, msg interface{}) error {
+		agg.Add(msg)
+		return nil
+	})
+	defer fork.Cancel()
+
+	// 总结请求单独计量, 不计为原请求的分发
+	entry := supported[0]
+	fork.Annotate("adapter", entry.Id)
+	if err = pick(fork, entry.Adapter); err != nil {
+		return "", err
+	}
+	meterFork(fork, mod, entry)
+	err = traceCall(fork, entry.Adapter, "relay", entry.Adapter.Relay)
+	// 写出拦截器中暂缓的内容, 补全用量
+	fork.SSE(func(writer func(interface{}) error) { _ = writer(model.Flush) })
+	if err != nil {
+		return "", err
+	}
+	if agg.Err != nil {
+		return "", agg.Err
+	}
+	return strings.TrimSpace(agg.Content.String()), nil
+}
@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
+	"github.com/spf13/viper"
+)
+
+// 声明上下文窗口的适配器
+type windowAdapter struct {
+	model.BasicAdapter
+	length int
+	output int
+}
+
+func (windowAdapter) Support(*model.Ctx, string) bool { return true }
+
+func (adapter windowAdapter) Model() []model.Model {
+	return []model.Model{{Id: "m", ContextLength: adapter.length, MaxOutput: adapter.output}}
+}
+
+func windowMessages() []model.CompletionMessage {
+	long := strings.Repeat("lorem ipsum ", 50)
+	return []model.CompletionMessage{
+		{"role": "system", "content": "be brief"},
+		{"role": "user", "content": "u1 " + long},
+		{"role": "assistant", "content": "a1 " + long},
+		{"role": "assistant", "content": "", "tool_calls": []interface{}{
+			map[string]interface{}{"id": "1", "type": "function", "function": map[string]interface{}{"name": "f", "arguments": "{}"}},
+		}},
+		{"role": "tool", "tool_call_id": "1", "content": "t1 " + long},
+		{"role": "user", "content": "u2 " + long},
+	}
+}
+
+// 按内容前缀标识保留的消息
+func windowIds(messages []model.CompletionMessage) (ids []string) {
+	for _, message := range messages {
+		text := model.TextOf(message)
+		if text == "" {
+			text = "call"
+		}
+		ids = append(ids, strings.Fields(text)[0])
+	}
+	return
+}
+
+func TestTrimContext(t *testing.T) {
+	logger.InitLogger(t.TempDir(), logger.Level(0))
+	messages := windowMessages()
+	sizes := make([]int, len(messages))
+	for i, message := range messages {
+		sizes[i] = estimateMessage("m", message)
+	}
+	sum := func(indexes ...int) (n int) {
+		for _, i := range indexes {
+			n += sizes[i]
+		}
+		return
+	}
+
+	cases := []struct {
+		name     string
+		disabled bool
+		length   int
+		want     []string
+	}{
+		{"fits", false, sum(0, 1, 2, 3, 4, 5) + 100, []string{"be", "u1", "a1", "call", "t1", "u2"}},
+		{"drops oldest turns", false, sum(0, 3, 4, 5) + 100, []string{"be", "call", "t1", "u2"}},
+		// 工具调用与结果作为整体, 放不下时一并丢弃
+		{"keeps tool pair together", false, sum(0, 4, 5) + 100, []string{"be", "u2"}},
+		// 最后一轮总是保留
+		{"keeps last turn", false, sum(0) + 110, []string{"be", "u2"}},
+		{"disabled", true, sum(0) + 110, []string{"be", "u1", "a1", "call", "t1", "u2"}},
+	}
+
+	for _, tc := range cases {
+		vip := viper.New()
+		vip.Set("context-window.enabled", !tc.disabled)
+		Env = &Environ{Viper: vip}
+
+		completion := &model.Completion{Model: "m", Messages: windowMessages()}
+		c := conversationCtx(t, "")
+		trimContext(c, completion, windowAdapter{length: tc.length, output: 100})
+		if got := windowIds(completion.Messages); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: messages = %v, want %v", tc.name, got, tc.want)
+		}
+
+		trimmed := string(c.Ctx().Response().Header.Peek("x-context-trimmed"))
+		if (trimmed != "") != (len(tc.want) < len(messages)) {
+			t.Errorf("%s: x-context-trimmed = %q", tc.name, trimmed)
+		}
+	}
+	Env = nil
+}
+
+func TestWindowOf(t *testing.T) {
+	vip := viper.New()
+	vip.Set("context-window.models", []map[string]interface{}{
+		{"match": "m*", "length": 1000},
+		{"match": "x", "length": 10, "output": 5},
+	})
+	Env = &Environ{Viper: vip}
+	defer func() { Env = nil }()
+
+	cases := []struct {
+		mod            string
+		length, output int
+	}{
+		// 配置覆盖长度, 未配置的输出沿用适配器声明
+		{"m", 1000, 100},
+		{"x", 10, 5},
+		{"y", 0, 0},
+	}
+	for _, tc := range cases {
+		length, output := windowOf(windowAdapter{length: 500, output: 100}, tc.mod)
+		if length != tc.length || output != tc.output {
+			t.Errorf("%s: window = %d/%d, want %d/%d", tc.mod, length, output, tc.length, tc.output)
+		}
+	}
+}
+
+func TestTurnsOf(t *testing.T) {
+	turns := turnsOf("m", windowMessages())
+	var roles []string
+	for _, turn := range turns {
+		var group []string
+		for _, message := range turn.messages {
+			group = append(group, model.RoleOf(message))
+		}
+		roles = append(roles, strings.Join(group, "+"))
+	}
+
+	want := []string{"system", "user", "assistant", "assistant+tool", "user"}
+	if !slices.Equal(roles, want) {
+		t.Fatalf("turns = %v, want %v", roles, want)
+	}
+	if !turns[0].pinned || turns[1].pinned {
+		t.Fatal("only system messages should be pinned")
+	}
+}
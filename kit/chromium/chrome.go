@@ -11,6 +11,7 @@ import (
 
 	"github.com/bincooo/ago/internal/chromium/plugins"
 	"github.com/bincooo/ago/logger"
+	"github.com/bincooo/ago/model"
 	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/dom"
 	"github.com/chromedp/cdproto/input"
@@ -19,10 +20,31 @@ import (
 	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
-func Run(ctx context.Context, actions ...chromedp.Action) error {
-	return chromedp.Run(ctx, actions...)
+// 执行浏览器动作, 开启链路追踪时记录为子 span
+func Run(ctx context.Context, actions ...chromedp.Action) (err error) {
+	ctx, span := otel.Tracer(model.TracerName).Start(ctx, "chromium.Run")
+	defer span.End()
+
+	if span.IsRecording() {
+		names := make([]string, 0, len(actions))
+		for _, action := range actions {
+			names = append(names, fmt.Sprintf("%T", action))
+		}
+		span.SetAttributes(attribute.StringSlice("chromium.actions", names))
+	}
+
+	if err = chromedp.Run(ctx, actions...); err != nil {
+		// 错误中可能含页面地址与 cookie, 导出到追踪后端前脱敏
+		msg := logger.Redact(err.Error())
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	return
 }
 
 func TaskLogger(message string) chromedp.Tasks {
@@ -375,6 +397,7 @@ func GetCookies(ctx context.Context) (cookies, lang, userAgent string, err error
 	return
 }
 
+// 截图保存到 tmp 目录; 图片内容无法脱敏, 可能包含页面上的账号信息, 仅用于本地调试
 func Screenshot(result chan string) chromedp.ActionFunc {
 	// 执行动作
 	var screenshotBytes []byte
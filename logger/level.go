@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	levels = &levelStore{global: zap.NewAtomicLevelAt(InfoLevel)}
+)
+
+// 全局级别与按包覆盖的级别, 均可在运行时修改
+type levelStore struct {
+	mu sync.RWMutex
+
+	global   zap.AtomicLevel
+	packages map[string]Level
+}
+
+func (store *levelStore) set(global Level, packages map[string]Level) {
+	store.global.SetLevel(global)
+	SetPackageLevels(packages)
+}
+
+// 当前的全局级别
+func GetLevel() Level {
+	return levels.global.Level()
+}
+
+// 修改全局级别
+func SetLevel(level Level) {
+	levels.global.SetLevel(level)
+}
+
+// 当前按包覆盖的级别
+func PackageLevels() map[string]Level {
+	levels.mu.RLock()
+	defer levels.mu.RUnlock()
+	result := make(map[string]Level, len(levels.packages))
+	for pkg, level := range levels.packages {
+		result[pkg] = level
+	}
+	return result
+}
+
+// 替换按包覆盖的级别
+func SetPackageLevels(packages map[string]Level) {
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+	levels.packages = make(map[string]Level, len(packages))
+	for pkg, level := range packages {
+		levels.packages[strings.Trim(pkg, "/")] = level
+	}
+}
+
+// 最低的生效级别
+func (store *levelStore) min() Level {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	level := store.global.Level()
+	for _, l := range store.packages {
+		level = min(level, l)
+	}
+	return level
+}
+
+// 包的生效级别, 匹配最长的包路径
+func (store *levelStore) of(pkg string) Level {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	level, matched := store.global.Level(), ""
+	for key, l := range store.packages {
+		if len(key) <= len(matched) {
+			continue
+		}
+		if pkg == key || strings.HasSuffix(pkg, "/"+key) || strings.HasPrefix(pkg, key+"/") {
+			level, matched = l, key
+		}
+	}
+	return level
+}
+
+// 按调用方所在的包过滤日志
+type levelCore struct {
+	zapcore.Core
+}
+
+func (core *levelCore) Enabled(level Level) bool {
+	return level >= levels.min()
+}
+
+func (core *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: core.Core.With(fields)}
+}
+
+func (core *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if core.Enabled(entry.Level) {
+		return checked.AddCore(entry, core)
+	}
+	return checked
+}
+
+// 调用方信息在 Check 之后才写入, 因此在 Write 中按包过滤
+func (core *levelCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	if entry.Level < levels.of(packageOf(entry.Caller.Function)) {
+		return nil
+	}
+	return core.Core.Write(entry, fields)
+}
+
+// 从函数全名中提取包路径, 如 github.com/bincooo/ago/model.(*Ctx).Logger
+func packageOf(function string) string {
+	slash := strings.LastIndexByte(function, '/')
+	if i := strings.IndexByte(function[slash+1:], '.'); i >= 0 {
+		return function[:slash+1+i]
+	}
+	return function
+}
+
+// 解析级别名称: debug | info | warn | error ...
+func ParseLevel(text string) (Level, error) {
+	return zapcore.ParseLevel(text)
+}
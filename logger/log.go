@@ -1,19 +1,32 @@
 package logger
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
-	_logger *zap.Logger
-	_sugar  *zap.SugaredLogger
+	// 当前的日志实例, Init 时整体替换
+	current atomic.Pointer[instance]
+	// 串行化 Init
+	initMu sync.Mutex
 )
 
+type instance struct {
+	logger *zap.Logger
+	sugar  *zap.SugaredLogger
+	writer *lumberjack.Logger
+}
+
 type Level = zapcore.Level
 
 var (
@@ -24,39 +37,128 @@ var (
 	FatalLevel = zapcore.FatalLevel
 )
 
+// 日志选项
+type Options struct {
+	// 日志目录
+	Path string
+	// 全局级别
+	Level Level
+	// 输出格式: console | json
+	Format string
+	// 同时输出到标准输出
+	Stdout bool
+	// 按包覆盖的级别, 键为包路径或其后缀, 如 internal/v1
+	Levels map[string]Level
+
+	// 单个文件大小上限 (MB)
+	MaxSize int
+	// 保留的旧文件个数
+	MaxBackups int
+	// 旧文件保留天数
+	MaxAge int
+	// 压缩旧文件
+	Compress bool
+
+	// 脱敏规则
+	Redact RedactOptions
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Path:       "logger",
+		Level:      InfoLevel,
+		Format:     "console",
+		Stdout:     true,
+		MaxSize:    1,
+		MaxBackups: 3,
+		MaxAge:     28,
+	}
+}
+
 func InitLogger(path string, logLevel Level) {
-	if len(path) == 0 {
-		path = "logger"
+	opts := DefaultOptions()
+	opts.Path = path
+	opts.Level = logLevel
+	Init(opts)
+}
+
+// 按选项初始化日志, 可重复调用以应用新的配置
+func Init(opts Options) {
+	if len(opts.Path) == 0 {
+		opts.Path = "logger"
+	}
+
+	initMu.Lock()
+	defer initMu.Unlock()
+
+	writer := &lumberjack.Logger{
+		Filename:   filepath.Join(opts.Path, "/adapter.log"),
+		MaxSize:    opts.MaxSize,
+		MaxAge:     opts.MaxAge,
+		MaxBackups: opts.MaxBackups,
+		Compress:   opts.Compress,
+	}
+
+	// 文件配置未变时沿用旧的 writer, 否则延迟关闭, 留给仍在使用旧实例的日志写完
+	old := current.Load()
+	if old != nil && sameFile(old.writer, writer) {
+		writer = old.writer
+	} else if old != nil {
+		time.AfterFunc(time.Minute, func() { _ = old.writer.Close() })
+	}
+
+	writeSyncer := zapcore.AddSync(writer)
+	if opts.Stdout {
+		writeSyncer = zapcore.NewMultiWriteSyncer(writeSyncer, zapcore.AddSync(os.Stdout))
 	}
-	writeSyncer := getWriter(filepath.Join(path, "/adapter.log"), 1, 3, 28)
 
 	encoderConfig := zap.NewProductionEncoderConfig()
 	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
 
-	encoder := zapcore.NewConsoleEncoder(encoderConfig)
-	core := zapcore.NewCore(encoder, writeSyncer, logLevel)
-	_logger = zap.New(core, zap.AddCaller())
-}
+	var encoder zapcore.Encoder
+	if opts.Format == "json" {
+		encoderConfig.EncodeLevel = zapcore.LowercaseLevelEncoder
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	} else {
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	}
+
+	var err error
+	var r *Redactor
+	if !opts.Redact.Disabled {
+		r, err = NewRedactor(opts.Redact.Fields, opts.Redact.Patterns)
+	}
+	SetRedactor(r)
 
-func getWriter(filename string, maxsize, maxBackup, maxAge int) zapcore.WriteSyncer {
-	lumberJackLogger := &lumberjack.Logger{
-		Filename:   filename,
-		MaxSize:    maxsize,
-		MaxAge:     maxAge,
-		MaxBackups: maxBackup,
-		Compress:   false,
+	levels.set(opts.Level, opts.Levels)
+	core := &levelCore{Core: &redactCore{Core: zapcore.NewCore(encoder, writeSyncer, zapcore.DebugLevel)}}
+	log := zap.New(core, zap.AddCaller())
+	current.Store(&instance{logger: log, sugar: log.Sugar(), writer: writer})
+
+	if err != nil {
+		Sugar().Errorf("logger: %v", err)
 	}
-	return zapcore.NewMultiWriteSyncer(zapcore.AddSync(lumberJackLogger), zapcore.AddSync(os.Stdout))
+}
+
+func sameFile(a, b *lumberjack.Logger) bool {
+	return a.Filename == b.Filename && a.MaxSize == b.MaxSize && a.MaxAge == b.MaxAge &&
+		a.MaxBackups == b.MaxBackups && a.Compress == b.Compress
 }
 
 func Sugar() *zap.SugaredLogger {
-	if _sugar == nil {
-		_sugar = _logger.Sugar()
-	}
-	return _sugar
+	return current.Load().sugar
 }
 
 func Logger() *zap.Logger {
-	return _logger
+	return current.Load().logger
+}
+
+// 附带追踪标识的日志
+func WithContext(ctx context.Context) *zap.SugaredLogger {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return Sugar()
+	}
+	return Sugar().With("trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
 }
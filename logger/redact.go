@@ -0,0 +1,278 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	Redacted = "[REDACTED]"
+)
+
+var (
+	// 敏感的字段名, 比较时忽略大小写与 - _
+	sensitiveFields = []string{
+		"authorization", "proxy-authorization", "cookie", "cookies", "set-cookie",
+		"x-api-key", "api-key", "apikey", "api_key", "x-goog-api-key",
+		"token", "access-token", "refresh-token", "id-token", "session-key", "session-token",
+		"password", "passwd", "secret", "client-secret", "private-key", "upstream", "credential",
+	}
+
+	// 常见的密钥格式, 第一个分组存在时保留分组内容
+	secretPatterns = []string{
+		// Authorization: Bearer xxx / Basic xxx
+		`(?i)\b(bearer\s+)[A-Za-z0-9\-._~+/]{8,}=*`,
+		`(?i)(authorization["']?\s*[:=]\s*["']?(?:basic|bearer|token)?\s*)[^\s"',;]{6,}`,
+		// 键值对: api_key=xxx、"token": "xxx"、sessionKey=xxx
+		`(?i)((?:api[_-]?key|access[_-]?token|refresh[_-]?token|auth[_-]?token|token|secret|password|passwd|session[_-]?key|session[_-]?token|sessionid|cookies?)["']?\s*[:=]\s*["']?)[^\s"'&;,]{6,}`,
+		// 查询参数: ?key=xxx&access_token=xxx
+		`(?i)([?&](?:key|api[_-]?key|access[_-]?token|token|auth)=)[^&\s"'#]+`,
+		// chromedp 协议消息中的 cookie: {"name":"xxx","value":"xxx"}
+		`("name"\s*:\s*"[^"]*"\s*,\s*"value"\s*:\s*")[^"]+`,
+		// OpenAI / Anthropic / DeepSeek 等 sk- 前缀的密钥
+		`\bsk-[A-Za-z0-9_\-]{16,}`,
+		// Google API key
+		`\bAIza[0-9A-Za-z_\-]{35}`,
+		// JWT
+		`\beyJ[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}`,
+		// GitHub token
+		`\bgh[pousr]_[A-Za-z0-9]{36,}`,
+		// AWS access key
+		`\bAKIA[0-9A-Z]{16}\b`,
+		// Slack token
+		`\bxox[abposr]-[A-Za-z0-9\-]{10,}`,
+	}
+
+	redactor atomic.Pointer[Redactor]
+)
+
+func init() {
+	r, _ := NewRedactor(nil, nil)
+	redactor.Store(r)
+}
+
+// 脱敏选项
+type RedactOptions struct {
+	// 关闭脱敏
+	Disabled bool
+	// 追加的敏感字段名
+	Fields []string
+	// 追加的正则, 第一个分组存在时保留分组内容
+	Patterns []string
+}
+
+// 按字段名与正则脱敏
+type Redactor struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func NewRedactor(fields, patterns []string) (*Redactor, error) {
+	r := &Redactor{fields: make(map[string]bool)}
+	for _, field := range append(sensitiveFields, fields...) {
+		r.fields[normalizeField(field)] = true
+	}
+
+	for _, pattern := range append(secretPatterns, patterns...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return r, fmt.Errorf("redact pattern [%s]: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// 替换全局脱敏规则, 为 nil 时关闭脱敏
+func SetRedactor(r *Redactor) {
+	redactor.Store(r)
+}
+
+func normalizeField(name string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+}
+
+// 是否为敏感字段
+func (r *Redactor) Sensitive(name string) bool {
+	return r != nil && r.fields[normalizeField(name)]
+}
+
+// 替换文本中的密钥
+func (r *Redactor) String(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+
+	for _, re := range r.patterns {
+		if re.NumSubexp() > 0 {
+			s = re.ReplaceAllString(s, "${1}"+Redacted)
+		} else {
+			s = re.ReplaceAllString(s, Redacted)
+		}
+	}
+	return s
+}
+
+// 递归脱敏: 敏感字段的值整体替换, 其余字符串按正则替换
+func (r *Redactor) Value(v interface{}) interface{} {
+	if r == nil {
+		return v
+	}
+
+	switch value := v.(type) {
+	case string:
+		return r.String(value)
+	case []byte:
+		return r.String(string(value))
+	case json.RawMessage:
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return r.String(string(value))
+		}
+		return r.Value(decoded)
+	case error:
+		return r.String(value.Error())
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if r.Sensitive(key) {
+				result[key] = Redacted
+			} else {
+				result[key] = r.Value(item)
+			}
+		}
+		return result
+	case map[string]string:
+		result := make(map[string]string, len(value))
+		for key, item := range value {
+			if r.Sensitive(key) {
+				result[key] = Redacted
+			} else {
+				result[key] = r.String(item)
+			}
+		}
+		return result
+	case map[string][]string:
+		result := make(map[string][]string, len(value))
+		for key, items := range value {
+			redacted := make([]string, len(items))
+			for i, item := range items {
+				if r.Sensitive(key) {
+					redacted[i] = Redacted
+				} else {
+					redacted[i] = r.String(item)
+				}
+			}
+			result[key] = redacted
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = r.Value(item)
+		}
+		return result
+	case []string:
+		result := make([]string, len(value))
+		for i, item := range value {
+			result[i] = r.String(item)
+		}
+		return result
+	}
+	return v
+}
+
+// 使用全局规则脱敏文本
+func Redact(s string) string {
+	return redactor.Load().String(s)
+}
+
+// 包装 printf 风格的输出函数, 格式化后按全局规则脱敏, 用于 chromedp 等不经过 zap 字段的输出
+func Redactf(printf func(string, ...interface{})) func(string, ...interface{}) {
+	return func(format string, args ...interface{}) {
+		printf("%s", Redact(fmt.Sprintf(format, args...)))
+	}
+}
+
+// 使用全局规则递归脱敏
+func RedactValue(v interface{}) interface{} {
+	return redactor.Load().Value(v)
+}
+
+// 写出前对消息与字段脱敏
+type redactCore struct {
+	zapcore.Core
+}
+
+func (core *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: core.Core.With(redactFields(fields))}
+}
+
+func (core *redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if core.Enabled(entry.Level) {
+		return checked.AddCore(entry, core)
+	}
+	return checked
+}
+
+func (core *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redact(entry.Message)
+	return core.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	r := redactor.Load()
+	if r == nil || len(fields) == 0 {
+		return fields
+	}
+
+	result := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		result[i] = redactField(r, field)
+	}
+	return result
+}
+
+func redactField(r *Redactor, field zapcore.Field) zapcore.Field {
+	if field.Type == zapcore.SkipType {
+		return field
+	}
+	if r.Sensitive(field.Key) {
+		return zap.String(field.Key, Redacted)
+	}
+
+	switch field.Type {
+	case zapcore.StringType:
+		field.String = r.String(field.String)
+	case zapcore.ByteStringType:
+		return zap.String(field.Key, r.String(string(field.Interface.([]byte))))
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok {
+			return zap.String(field.Key, r.String(err.Error()))
+		}
+	case zapcore.StringerType:
+		if stringer, ok := field.Interface.(fmt.Stringer); ok {
+			return zap.String(field.Key, r.String(stringer.String()))
+		}
+	case zapcore.ReflectType:
+		switch field.Interface.(type) {
+		case map[string]interface{}, map[string]string, map[string][]string, []interface{}, []string:
+			return zap.Any(field.Key, r.Value(field.Interface))
+		}
+
+		chunk, err := json.Marshal(field.Interface)
+		if err != nil {
+			return field
+		}
+		if redacted := r.String(string(chunk)); redacted != string(chunk) {
+			return zap.Any(field.Key, json.RawMessage(redacted))
+		}
+	}
+	return field
+}
@@ -0,0 +1,239 @@
+package model
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// 响应聚合器, 将流式分片或完整响应合并为一个完整的响应
+type Aggregator struct {
+	Id      string
+	Model   string
+	Created int64
+
+	Content   strings.Builder
+	Reasoning strings.Builder
+	ToolCalls []ChoiceToolCall
+
+	FinishReason string
+	Usage        ResponseUsage
+
+	// 收到 io.EOF 或完整响应
+	Done bool
+	// 收到错误
+	Err error
+}
+
+// 合并一条待写出的消息
+func (agg *Aggregator) Add(msg interface{}) {
+	switch v := msg.(type) {
+	case *Response:
+		if v != nil {
+			agg.add(v)
+		}
+	case Response:
+		agg.add(&v)
+	case error:
+		if v == io.EOF {
+			agg.Done = true
+		} else {
+			agg.Err = v
+		}
+	case flushSignal:
+		// 未写出 io.EOF 但已给出结束原因
+		if agg.FinishReason != "" {
+			agg.Done = true
+		}
+	}
+}
+
+func (agg *Aggregator) add(response *Response) {
+	if agg.Id == "" {
+		agg.Id = response.Id
+	}
+	if agg.Model == "" {
+		agg.Model = response.Model
+	}
+	if agg.Created == 0 {
+		agg.Created = response.Created
+	}
+	if len(response.Usage) > 0 {
+		agg.Usage = response.Usage
+	}
+
+	for _, choice := range response.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+
+		if choice.Message != nil {
+			agg.Content.WriteString(choice.Message.Content)
+			agg.Reasoning.WriteString(choice.Message.ReasoningContent)
+			agg.ToolCalls = append(agg.ToolCalls, choice.Message.ToolCalls...)
+			agg.Done = true
+		}
+
+		if choice.Delta != nil {
+			agg.Content.WriteString(choice.Delta.Content)
+			agg.Reasoning.WriteString(choice.Delta.ReasoningContent)
+			for _, call := range choice.Delta.ToolCalls {
+				agg.mergeToolCall(call)
+			}
+		}
+
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			agg.FinishReason = *choice.FinishReason
+		}
+	}
+}
+
+// 流式工具调用按 index 合并, arguments 逐段拼接
+func (agg *Aggregator) mergeToolCall(call ChoiceToolCall) {
+	index, ok := call["index"]
+	if ok {
+		for _, exists := range agg.ToolCalls {
+			if exists["index"] != index {
+				continue
+			}
+
+			if id, _ := call["id"].(string); id != "" {
+				exists["id"] = id
+			}
+
+			fn := FunctionOf(exists)
+			delta := FunctionOf(call)
+			if name, _ := delta["name"].(string); name != "" {
+				fn["name"] = name
+			}
+			arguments, _ := fn["arguments"].(string)
+			chunk, _ := delta["arguments"].(string)
+			fn["arguments"] = arguments + chunk
+			exists["function"] = fn
+			return
+		}
+	}
+
+	cpy := ChoiceToolCall(Record[string, any](call).Clone())
+	cpy["function"] = FunctionOf(cpy)
+	agg.ToolCalls = append(agg.ToolCalls, cpy)
+}
+
+// 聚合后的完整响应
+func (agg *Aggregator) Response() *Response {
+	finishReason := agg.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+
+	toolCalls := make([]ChoiceToolCall, 0, len(agg.ToolCalls))
+	for _, call := range agg.ToolCalls {
+		cpy := ChoiceToolCall(Record[string, any](call).Clone())
+		delete(cpy, "index")
+		toolCalls = append(toolCalls, cpy)
+	}
+	if len(toolCalls) == 0 {
+		toolCalls = nil
+	}
+
+	return &Response{
+		Id:      agg.Id,
+		Object:  "chat.completion",
+		Created: agg.Created,
+		Model:   agg.Model,
+		Choices: []Choice{
+			{
+				Index:        0,
+				Message:      &ChoiceMessage{"assistant", agg.Content.String(), agg.Reasoning.String(), toolCalls},
+				FinishReason: &finishReason,
+			},
+		},
+		Usage: agg.Usage,
+	}
+}
+
+// 工具调用中的 function 字段
+func FunctionOf(call ChoiceToolCall) Record[string, any] {
+	switch fn := call["function"].(type) {
+	case Record[string, any]:
+		return fn
+	case map[string]interface{}:
+		return fn
+	}
+	return Record[string, any]{}
+}
+
+// 将完整响应以流式分片重放
+func Replay(response *Response, writer func(interface{}) error) (err error) {
+	var message ChoiceMessage
+	finishReason := "stop"
+	if len(response.Choices) > 0 {
+		if response.Choices[0].Message != nil {
+			message = *response.Choices[0].Message
+		}
+		if response.Choices[0].FinishReason != nil {
+			finishReason = *response.Choices[0].FinishReason
+		}
+	}
+
+	chunk := func(delta *ChoiceDelta, finish *string, usage ResponseUsage) error {
+		return writer(&Response{
+			Id:      response.Id,
+			Object:  "chat.completion.chunk",
+			Created: response.Created,
+			Model:   response.Model,
+			Choices: []Choice{
+				{
+					Index:        0,
+					Delta:        delta,
+					FinishReason: finish,
+				},
+			},
+			Usage: usage,
+		})
+	}
+
+	for _, piece := range split(message.ReasoningContent, 32) {
+		if err = chunk(&ChoiceDelta{Type: "text", Role: "assistant", ReasoningContent: piece}, nil, nil); err != nil {
+			return
+		}
+	}
+
+	for _, piece := range split(message.Content, 32) {
+		if err = chunk(&ChoiceDelta{Type: "text", Role: "assistant", Content: piece}, nil, nil); err != nil {
+			return
+		}
+	}
+
+	if len(message.ToolCalls) > 0 {
+		toolCalls := make([]ChoiceToolCall, 0, len(message.ToolCalls))
+		for i, call := range message.ToolCalls {
+			cpy := ChoiceToolCall(Record[string, any](call).Clone())
+			cpy["index"] = i
+			toolCalls = append(toolCalls, cpy)
+		}
+		if err = chunk(&ChoiceDelta{Type: "text", Role: "assistant", ToolCalls: toolCalls}, nil, nil); err != nil {
+			return
+		}
+	}
+
+	if err = chunk(&ChoiceDelta{}, &finishReason, response.Usage); err != nil {
+		return
+	}
+	return writer(io.EOF)
+}
+
+// 按字符数切分
+func split(content string, size int) (pieces []string) {
+	for len(content) > 0 {
+		n, i := 0, 0
+		for i < len(content) && n < size {
+			_, width := utf8.DecodeRuneInString(content[i:])
+			i += width
+			n++
+		}
+		pieces = append(pieces, content[:i])
+		content = content[i:]
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+)
+
+// 上游凭证, 由凭证池选出后挂载到 Ctx.Credential
+type Credential struct {
+	Id    string            `json:"id"`
+	Pool  string            `json:"pool"`
+	Value string            `json:"value"`
+	Meta  map[string]string `json:"meta,omitempty"`
+
+	report func(status string, d time.Duration, reason string)
+}
+
+func NewCredential(pool, id, value string, meta map[string]string, report func(status string, d time.Duration, reason string)) *Credential {
+	return &Credential{
+		Id:    id,
+		Pool:  pool,
+		Value: value,
+		Meta:  meta,
+
+		report: report,
+	}
+}
+
+// 冷却, 上游限流时调用; d 为 0 时使用凭证池的默认冷却时间
+func (c *Credential) Cooldown(d time.Duration) {
+	if c != nil && c.report != nil {
+		c.report("cooling", d, "")
+	}
+}
+
+// 标记失效, 鉴权失败时调用
+func (c *Credential) Invalid(reason string) {
+	if c != nil && c.report != nil {
+		c.report("invalid", 0, reason)
+	}
+}
@@ -2,37 +2,258 @@ package model
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/bincooo/ago/logger"
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
 )
 
 type Ctx struct {
 	ctx *fiber.Ctx
 	Record[string, any]
 
+	// 上游凭证
 	Token string
-	Type  string
+	// 客户端密钥, 开启鉴权时有效
+	ClientKey string
+	// 凭证池选出的上游凭证
+	Credential *Credential
+	Type       string
+
+	context context.Context
+	cancel  context.CancelFunc
+
+	*output
+
+	// 请求级日志字段
+	fields []interface{}
 }
 
+// 拦截器与输出状态, 由 Derive 派生的上下文共享
+type output struct {
+	interceptors []Interceptor
+	stages       []Stage
+
+	// 重定向输出, 不为空时 SSE/JSON 不再直接写入 fiber
+	sink func(kind string, msg interface{}) error
+	// 重定向未经拦截器处理的输出
+	raw bool
+
+	streaming bool
+
+	mu       sync.Mutex
+	done     []func()
+	finished bool
+}
+
+// 流拦截器: 消息写出前调用, 通过 next 继续写出; 可改写、拆分或丢弃消息
+type Interceptor func(ctx *Ctx, msg interface{}, next func(interface{}) error) error
+
+// 拦截器阶段, 按阶段先后执行, 同阶段按添加顺序执行
+type Stage int
+
+const (
+	// 核心输出处理, Intercept 的默认阶段
+	StageProcess Stage = iota
+	// 适配器中间件
+	StageAdapter
+	// 全局中间件
+	StageGlobal
+	// 用量统计与限额, 统计处理后实际写出的内容
+	StageMeter
+	// 缓存与审计, 记录最终写出的内容
+	StageCapture
+)
+
+// 中间件已自行写出响应, 终止后续处理
+var ErrHandled = errors.New("request handled")
+
+// 流结束信号: SSE 结束时经过拦截器链, 缓冲了内容的拦截器收到后应写出剩余内容并继续传递
+var Flush = flushSignal{}
+
+type flushSignal struct{}
+
 func New(ctx *fiber.Ctx) *Ctx {
-	return &Ctx{
+	c := &Ctx{
 		ctx:    ctx,
 		Record: make(Record[string, any]),
+		output: new(output),
 
 		Token: token(ctx),
 	}
+	c.context, c.cancel = context.WithCancel(ctx.UserContext())
+	return c
 }
 
 func (ctx *Ctx) Ctx() *fiber.Ctx {
 	return ctx.ctx
 }
 
+// 请求上下文, 取消后写出函数将返回错误
+func (ctx *Ctx) Context() context.Context {
+	return ctx.context
+}
+
+// 替换请求上下文, 用于附加追踪等信息; 新上下文需派生自 Context() 以保留取消信号
+func (ctx *Ctx) WithContext(c context.Context) {
+	ctx.context = c
+}
+
+// 使用新的请求上下文 (需派生自 Context()) 的副本, 与原上下文共享 Record、拦截器与输出.
+// 流式输出在处理函数返回前即可能开始, 此时不能再修改共享的上下文, 如为单次调用附加追踪信息
+func (ctx *Ctx) Derive(c context.Context) *Ctx {
+	derived := *ctx
+	derived.context = c
+	derived.fields = slices.Clone(ctx.fields)
+	return &derived
+}
+
+// 取消当前请求
+func (ctx *Ctx) Cancel() {
+	ctx.cancel()
+}
+
+// 请求级日志, 附带请求标识、模型、适配器、客户端与尝试次数等字段
+func (ctx *Ctx) Logger() *zap.SugaredLogger {
+	return logger.WithContext(ctx.context).With(ctx.fields...)
+}
+
+// 添加请求级日志字段, 同名时覆盖
+func (ctx *Ctx) Annotate(key string, value interface{}) {
+	for i := 0; i < len(ctx.fields); i += 2 {
+		if ctx.fields[i] == key {
+			ctx.fields[i+1] = value
+			return
+		}
+	}
+	ctx.fields = append(ctx.fields, key, value)
+}
+
+// 是否以流式响应直接写出到客户端, 此时写出发生在处理函数返回之后
+func (ctx *Ctx) Streaming() bool {
+	return ctx.streaming
+}
+
+// 流式响应写完后执行, 响应已写完时立即执行
+func (ctx *Ctx) OnDone(f func()) {
+	ctx.mu.Lock()
+	if !ctx.finished {
+		ctx.done = append(ctx.done, f)
+		ctx.mu.Unlock()
+		return
+	}
+	ctx.mu.Unlock()
+	f()
+}
+
+// 添加流拦截器, 按添加顺序执行
+func (ctx *Ctx) Intercept(interceptors ...Interceptor) {
+	ctx.InterceptAt(StageProcess, interceptors...)
+}
+
+// 在指定阶段添加流拦截器
+func (ctx *Ctx) InterceptAt(stage Stage, interceptors ...Interceptor) {
+	i := len(ctx.stages)
+	for i > 0 && ctx.stages[i-1] > stage {
+		i--
+	}
+
+	for _, interceptor := range interceptors {
+		ctx.interceptors = slices.Insert(ctx.interceptors, i, interceptor)
+		ctx.stages = slices.Insert(ctx.stages, i, stage)
+		i++
+	}
+}
+
+// 移除指定阶段的拦截器, 如重放缓存时跳过已处理过的阶段
+func (ctx *Ctx) Discard(stages ...Stage) {
+	for i := len(ctx.interceptors) - 1; i >= 0; i-- {
+		if slices.Contains(stages, ctx.stages[i]) {
+			ctx.interceptors = slices.Delete(ctx.interceptors, i, i+1)
+			ctx.stages = slices.Delete(ctx.stages, i, i+1)
+		}
+	}
+}
+
+// 派生上下文: 深克隆 Record, 独立的取消信号; 拦截器不会被派生, 由原上下文写出时执行
+func (ctx *Ctx) Fork() *Ctx {
+	c := &Ctx{
+		ctx:    ctx.ctx,
+		Record: ctx.Record.Clone(),
+
+		Token:      ctx.Token,
+		ClientKey:  ctx.ClientKey,
+		Credential: ctx.Credential,
+		Type:       ctx.Type,
+
+		output: new(output),
+		fields: slices.Clone(ctx.fields),
+	}
+	if c.Record == nil {
+		c.Record = make(Record[string, any])
+	}
+	c.context, c.cancel = context.WithCancel(ctx.context)
+	return c
+}
+
+// 接管派生上下文添加的拦截器 (如适配器 After), 按原阶段插入, 配合 RedirectRaw 使用
+func (ctx *Ctx) Adopt(fork *Ctx) {
+	for i, interceptor := range fork.interceptors {
+		ctx.InterceptAt(fork.stages[i], interceptor)
+	}
+}
+
+// 脱离 fiber 请求: 复制请求与 Locals 到独立的 fiber.Ctx, 返回的 release 在不再使用后归还.
+// 处理函数返回后 fiber.Ctx 将被回收, 仍在运行的派生上下文 (如对冲请求) 需先脱离
+func (ctx *Ctx) Detach() (release func()) {
+	request := new(fasthttp.RequestCtx)
+	ctx.ctx.Request().CopyTo(&request.Request)
+	ctx.ctx.Context().VisitUserValuesAll(func(key, value any) {
+		request.SetUserValue(key, value)
+	})
+	app := ctx.ctx.App()
+	detached := app.AcquireCtx(request)
+	ctx.ctx = detached
+	var once sync.Once
+	return func() {
+		once.Do(func() { app.ReleaseCtx(detached) })
+	}
+}
+
+// 重定向输出, kind 为 sse 或 json
+func (ctx *Ctx) Redirect(sink func(kind string, msg interface{}) error) {
+	ctx.sink = sink
+	ctx.raw = false
+}
+
+// 重定向未经拦截器处理的输出, 拦截器由原上下文 Adopt 后按阶段执行
+func (ctx *Ctx) RedirectRaw(sink func(kind string, msg interface{}) error) {
+	ctx.sink = sink
+	ctx.raw = true
+}
+
 func (ctx *Ctx) SSE(yield func(writer func(interface{}) error)) {
+	if ctx.sink != nil {
+		yield(func(msg interface{}) error {
+			if err := ctx.context.Err(); err != nil {
+				return err
+			}
+			return ctx.emit(msg, func(msg interface{}) error {
+				return ctx.sink("sse", msg)
+			})
+		})
+		return
+	}
+
 	ctx.ctx.Set("content-type", "text/event-stream")
 	ctx.ctx.Set("cache-control", "no-cache")
 	ctx.ctx.Set("x-accel-buffering", "no")
@@ -40,16 +261,68 @@ func (ctx *Ctx) SSE(yield func(writer func(interface{}) error)) {
 	ctx.ctx.Set("connection", "keep-alive")
 	ctx.ctx.Set("transfer-encoding", "chunked")
 
+	ctx.streaming = true
 	ctx.ctx.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writer := func(msg interface{}) error {
+			return ctx.emit(msg, func(msg interface{}) error {
+				if msg == Flush {
+					return nil
+				}
+				return write(w, msg)
+			})
+		}
+
 		yield(func(msg interface{}) error {
-			return write(w, msg)
+			if err := ctx.context.Err(); err != nil {
+				return err
+			}
+			return writer(msg)
 		})
+		_ = writer(Flush)
+		ctx.mu.Lock()
+		ctx.finished = true
+		done := ctx.done
+		ctx.mu.Unlock()
+		for _, f := range done {
+			f()
+		}
 	})
 	return
 }
 
 func (ctx *Ctx) JSON(msg interface{}) error {
-	return ctx.ctx.JSON(msg)
+	if ctx.sink != nil {
+		if err := ctx.context.Err(); err != nil {
+			return err
+		}
+		return ctx.emit(msg, func(msg interface{}) error {
+			return ctx.sink("json", msg)
+		})
+	}
+	return ctx.emit(msg, func(msg interface{}) error {
+		return ctx.ctx.JSON(msg)
+	})
+}
+
+func (ctx *Ctx) emit(msg interface{}, writer func(interface{}) error) error {
+	if ctx.raw && ctx.sink != nil {
+		return writer(msg)
+	}
+	return ctx.chain(0, msg, writer)
+}
+
+func (ctx *Ctx) chain(i int, msg interface{}, writer func(interface{}) error) error {
+	if i >= len(ctx.interceptors) {
+		return writer(msg)
+	}
+	return ctx.interceptors[i](ctx, msg, func(msg interface{}) error {
+		return ctx.chain(i+1, msg, writer)
+	})
+}
+
+// 提取请求头中的密钥
+func ExtractToken(ctx *fiber.Ctx) string {
+	return token(ctx)
 }
 
 func token(ctx *fiber.Ctx) (token string) {
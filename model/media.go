@@ -0,0 +1,38 @@
+package model
+
+import (
+	"encoding/base64"
+)
+
+// 归一化后的图片, 由核心在调用适配器前解析
+type Image struct {
+	// 所在的消息与内容段下标
+	Message int
+	Part    int
+
+	// 原始地址, data URI 时为空
+	URL    string
+	Detail string
+
+	// 已下载或解码的内容, 未下载时为空
+	MIME string
+	Data []byte
+}
+
+// 图片内容的 base64 编码
+func (image Image) Base64() string {
+	return base64.StdEncoding.EncodeToString(image.Data)
+}
+
+// data URI, 未下载时返回原始地址
+func (image Image) DataURI() string {
+	if len(image.Data) == 0 {
+		return image.URL
+	}
+	return "data:" + image.MIME + ";base64," + image.Base64()
+}
+
+// 请求中的图片列表
+func ImagesOf(ctx *Ctx) []Image {
+	return JustValue[string, []Image](ctx.Record, "images")
+}
@@ -0,0 +1,100 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// 消息角色
+func RoleOf(message CompletionMessage) string {
+	role, _ := message["role"].(string)
+	return role
+}
+
+// 消息的文本内容, 多段内容时仅拼接文本部分
+func TextOf(message CompletionMessage) string {
+	switch content := message["content"].(type) {
+	case string:
+		return content
+	case nil:
+		return ""
+	default:
+		var texts []string
+		for _, part := range PartsOf(message) {
+			if part["type"] == "text" {
+				text, _ := part["text"].(string)
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+}
+
+// 多段内容, 字符串内容视为单个文本段
+func PartsOf(message CompletionMessage) (parts []Record[string, any]) {
+	switch content := message["content"].(type) {
+	case string:
+		return []Record[string, any]{{"type": "text", "text": content}}
+	case []Record[string, any]:
+		return content
+	case []map[string]interface{}:
+		for _, part := range content {
+			parts = append(parts, part)
+		}
+	case []interface{}:
+		for _, item := range content {
+			switch part := item.(type) {
+			case map[string]interface{}:
+				parts = append(parts, part)
+			case Record[string, any]:
+				parts = append(parts, part)
+			}
+		}
+	}
+	return
+}
+
+// 消息中的工具调用
+func ToolCallsOf(message CompletionMessage) (calls []ChoiceToolCall) {
+	switch v := message["tool_calls"].(type) {
+	case []ChoiceToolCall:
+		return v
+	case []interface{}:
+		for _, item := range v {
+			switch call := item.(type) {
+			case map[string]interface{}:
+				calls = append(calls, call)
+			case ChoiceToolCall:
+				calls = append(calls, call)
+			case Record[string, any]:
+				calls = append(calls, ChoiceToolCall(call))
+			}
+		}
+	}
+	return
+}
+
+// 工具参数, 统一转为 JSON 字符串
+func ArgumentsOf(call ChoiceToolCall) string {
+	switch arguments := FunctionOf(call)["arguments"].(type) {
+	case string:
+		return arguments
+	case nil:
+		return "{}"
+	default:
+		chunk, _ := json.Marshal(arguments)
+		return string(chunk)
+	}
+}
+
+// 工具参数, 用于序列化: 合法 JSON 原样嵌入, 为空时视为 {}, 否则作为字符串
+func ArgumentsValue(call ChoiceToolCall) interface{} {
+	arguments := strings.TrimSpace(ArgumentsOf(call))
+	if arguments == "" {
+		return json.RawMessage("{}")
+	}
+	if json.Valid([]byte(arguments)) {
+		return json.RawMessage(arguments)
+	}
+	return arguments
+}
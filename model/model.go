@@ -43,6 +43,10 @@ type Model struct {
 	Object  string `json:"object"`
 	Created int    `json:"created"`
 	By      string `json:"owned_by"`
+
+	// 上下文长度与最大输出 token 数
+	ContextLength int `json:"context_length,omitempty"`
+	MaxOutput     int `json:"max_output_tokens,omitempty"`
 }
 
 type Completion struct {
@@ -57,6 +61,9 @@ type Completion struct {
 	TopP          float32             `json:"top_p,omitempty"`
 	Stream        bool                `json:"stream,omitempty"`
 	ToolChoice    interface{}         `json:"tool_choice,omitempty"`
+	Seed          *int64              `json:"seed,omitempty"`
+
+	ResponseFormat Record[string, any] `json:"response_format,omitempty"`
 }
 
 type CompletionMessage = Record[string, any]
@@ -95,23 +102,27 @@ type Response struct {
 type ResponseUsage Record[string, any]
 
 type Choice struct {
-	Index   int `json:"index"`
-	Message *struct {
-		Role             string `json:"role,omitempty"`
-		Content          string `json:"content,omitempty"`
-		ReasoningContent string `json:"reasoning_content,omitempty"`
-
-		ToolCalls []ChoiceToolCall `json:"tool_calls,omitempty"`
-	} `json:"message,omitempty"`
-	Delta *struct {
-		Type             string `json:"type,omitempty"`
-		Role             string `json:"role,omitempty"`
-		Content          string `json:"content,omitempty"`
-		ReasoningContent string `json:"reasoning_content,omitempty"`
-
-		ToolCalls []ChoiceToolCall `json:"tool_calls,omitempty"`
-	} `json:"delta,omitempty"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int            `json:"index"`
+	Message      *ChoiceMessage `json:"message,omitempty"`
+	Delta        *ChoiceDelta   `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type ChoiceMessage = struct {
+	Role             string `json:"role,omitempty"`
+	Content          string `json:"content,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+
+	ToolCalls []ChoiceToolCall `json:"tool_calls,omitempty"`
+}
+
+type ChoiceDelta = struct {
+	Type             string `json:"type,omitempty"`
+	Role             string `json:"role,omitempty"`
+	Content          string `json:"content,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+
+	ToolCalls []ChoiceToolCall `json:"tool_calls,omitempty"`
 }
 
 type ChoiceToolCall Record[string, any]
@@ -13,14 +13,7 @@ func MakeSSEResponse(content string, created int64) *Response {
 		Choices: []Choice{
 			{
 				Index: 0,
-				Delta: &struct {
-					Type             string `json:"type,omitempty"`
-					Role             string `json:"role,omitempty"`
-					Content          string `json:"content,omitempty"`
-					ReasoningContent string `json:"reasoning_content,omitempty"`
-
-					ToolCalls []ChoiceToolCall `json:"tool_calls,omitempty"`
-				}{"text", "assistant", content, "", nil},
+				Delta: &ChoiceDelta{"text", "assistant", content, "", nil},
 			},
 		},
 	}
@@ -35,17 +28,62 @@ func MakeResponse(content string, created int64) *Response {
 		Object:  "chat.completion",
 		Choices: []Choice{
 			{
-				Index: 0,
-				Message: &struct {
-					Role             string `json:"role,omitempty"`
-					Content          string `json:"content,omitempty"`
-					ReasoningContent string `json:"reasoning_content,omitempty"`
-
-					ToolCalls []ChoiceToolCall `json:"tool_calls,omitempty"`
-				}{"assistant", content, "", nil},
+				Index:        0,
+				Message:      &ChoiceMessage{"assistant", content, "", nil},
 				FinishReason: &stop,
 			},
 		},
 		//Usage: usage,
 	}
 }
+
+// 提取消息中的用量
+func UsageOf(msg interface{}) (usage ResponseUsage, ok bool) {
+	switch v := msg.(type) {
+	case *Response:
+		if v != nil && len(v.Usage) > 0 {
+			return v.Usage, true
+		}
+	case Response:
+		if len(v.Usage) > 0 {
+			return v.Usage, true
+		}
+	}
+	return
+}
+
+// 输入 token 数
+func (usage ResponseUsage) Prompt() int64 {
+	return usage.int64("prompt_tokens", "input_tokens")
+}
+
+// 输出 token 数
+func (usage ResponseUsage) Completion() int64 {
+	return usage.int64("completion_tokens", "output_tokens")
+}
+
+// 总 token 数
+func (usage ResponseUsage) Total() int64 {
+	if total := usage.int64("total_tokens"); total > 0 {
+		return total
+	}
+	return usage.Prompt() + usage.Completion()
+}
+
+func (usage ResponseUsage) int64(keys ...string) int64 {
+	for _, k := range keys {
+		switch v := usage[k].(type) {
+		case int:
+			return int64(v)
+		case int32:
+			return int64(v)
+		case int64:
+			return v
+		case float32:
+			return int64(v)
+		case float64:
+			return int64(v)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// 对话模版, 将消息列表拼接为单个提示词
+//
+// 模版数据:
+//
+//	.Messages    消息列表: Role、Content、Name
+//	.Generation  是否追加助手回复的起始标记
+var (
+	templates  = make(map[string]*template.Template)
+	templateMu sync.RWMutex
+)
+
+// 渲染选项
+type TemplateOptions struct {
+	// 将 Completion.System 作为首条系统消息
+	System bool
+	// 包含助手的工具调用与工具结果
+	Tools bool
+	// 图片占位符, 为空时忽略图片
+	Image string
+	// 末尾追加助手回复的起始标记
+	Generation bool
+}
+
+var DefaultTemplateOptions = TemplateOptions{System: true, Tools: true, Generation: true}
+
+type TemplateMessage struct {
+	Role    string
+	Content string
+	Name    string
+}
+
+func init() {
+	builtin := map[string]string{
+		"chatml": `{{range .Messages}}<|im_start|>{{.Role}}
+{{.Content}}<|im_end|>
+{{end}}{{if .Generation}}<|im_start|>assistant
+{{end}}`,
+
+		"llama3": `<|begin_of_text|>{{range .Messages}}<|start_header_id|>{{.Role}}<|end_header_id|>
+
+{{.Content}}<|eot_id|>{{end}}{{if .Generation}}<|start_header_id|>assistant<|end_header_id|>
+
+{{end}}`,
+
+		"mistral": `<s>{{$system := ""}}{{range .Messages}}{{if eq .Role "system"}}{{$system = .Content}}
+{{- else if eq .Role "assistant"}} {{.Content}}</s>
+{{- else}}[INST] {{if $system}}{{$system}}
+
+{{$system = ""}}{{end}}{{.Content}} [/INST]{{end}}{{end}}`,
+
+		"claude": `{{range .Messages}}{{if eq .Role "system"}}{{.Content}}{{else if eq .Role "assistant"}}
+
+Assistant: {{.Content}}{{else}}
+
+Human: {{.Content}}{{end}}{{end}}{{if .Generation}}
+
+Assistant:{{end}}`,
+
+		"plain": `{{range .Messages}}{{.Role}}: {{.Content}}
+
+{{end}}{{if .Generation}}assistant: {{end}}`,
+	}
+
+	for name, text := range builtin {
+		if err := RegisterTemplate(name, text); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// 注册对话模版, 同名时覆盖
+func RegisterTemplate(name, text string) error {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return fmt.Errorf("template [%s]: %v", name, err)
+	}
+
+	templateMu.Lock()
+	defer templateMu.Unlock()
+	templates[name] = tmpl
+	return nil
+}
+
+// 使用指定模版拼接对话
+func Render(name string, completion *Completion, opts TemplateOptions) (string, error) {
+	templateMu.RLock()
+	tmpl, ok := templates[name]
+	templateMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("template [%s] is not found", name)
+	}
+
+	var buffer strings.Builder
+	err := tmpl.Execute(&buffer, map[string]interface{}{
+		"Messages":   TemplateMessages(completion, opts),
+		"Generation": opts.Generation,
+	})
+	return buffer.String(), err
+}
+
+// 转为模版消息: 合并多段内容, 按选项处理系统提示、工具与图片
+func TemplateMessages(completion *Completion, opts TemplateOptions) (messages []TemplateMessage) {
+	if opts.System && completion.System != "" {
+		messages = append(messages, TemplateMessage{Role: "system", Content: completion.System})
+	}
+
+	for _, message := range completion.Messages {
+		role := RoleOf(message)
+		if role == "tool" && !opts.Tools {
+			continue
+		}
+
+		var contents []string
+		for _, part := range PartsOf(message) {
+			switch part["type"] {
+			case "text":
+				if text, _ := part["text"].(string); text != "" {
+					contents = append(contents, text)
+				}
+			case "image_url", "image":
+				if opts.Image != "" {
+					contents = append(contents, opts.Image)
+				}
+			}
+		}
+
+		if opts.Tools {
+			for _, call := range ToolCallsOf(message) {
+				chunk, _ := json.Marshal(map[string]interface{}{
+					"name":      FunctionOf(call)["name"],
+					"arguments": ArgumentsValue(call),
+				})
+				contents = append(contents, string(chunk))
+			}
+		}
+
+		if len(contents) == 0 {
+			continue
+		}
+
+		name, _ := message["name"].(string)
+		messages = append(messages, TemplateMessage{Role: role, Content: strings.Join(contents, "\n"), Name: name})
+	}
+	return
+}
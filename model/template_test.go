@@ -0,0 +1,121 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func templateCompletion() *Completion {
+	return &Completion{
+		System: "be brief",
+		Messages: []CompletionMessage{
+			{"role": "user", "content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "what is this?"},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:,"}},
+			}},
+			{"role": "assistant", "content": "", "tool_calls": []interface{}{
+				map[string]interface{}{"id": "1", "type": "function", "function": map[string]interface{}{"name": "look", "arguments": `{"q":1}`}},
+			}},
+			{"role": "tool", "content": "a cat", "name": "look"},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	completion := &Completion{Messages: []CompletionMessage{
+		{"role": "system", "content": "sys"},
+		{"role": "user", "content": "hi"},
+		{"role": "assistant", "content": "hello"},
+		{"role": "user", "content": "bye"},
+	}}
+
+	cases := []struct {
+		name       string
+		generation bool
+		want       string
+	}{
+		{"chatml", true, "<|im_start|>system\nsys<|im_end|>\n<|im_start|>user\nhi<|im_end|>\n<|im_start|>assistant\nhello<|im_end|>\n<|im_start|>user\nbye<|im_end|>\n<|im_start|>assistant\n"},
+		{"chatml", false, "<|im_start|>system\nsys<|im_end|>\n<|im_start|>user\nhi<|im_end|>\n<|im_start|>assistant\nhello<|im_end|>\n<|im_start|>user\nbye<|im_end|>\n"},
+		{"llama3", true, "<|begin_of_text|><|start_header_id|>system<|end_header_id|>\n\nsys<|eot_id|><|start_header_id|>user<|end_header_id|>\n\nhi<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\nhello<|eot_id|><|start_header_id|>user<|end_header_id|>\n\nbye<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n"},
+		{"mistral", true, "<s>[INST] sys\n\nhi [/INST] hello</s>[INST] bye [/INST]"},
+		{"claude", true, "sys\n\nHuman: hi\n\nAssistant: hello\n\nHuman: bye\n\nAssistant:"},
+		{"plain", true, "system: sys\n\nuser: hi\n\nassistant: hello\n\nuser: bye\n\nassistant: "},
+	}
+
+	for _, tc := range cases {
+		opts := DefaultTemplateOptions
+		opts.Generation = tc.generation
+		got, err := Render(tc.name, completion, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s (generation=%v):\n got %q\nwant %q", tc.name, tc.generation, got, tc.want)
+		}
+	}
+
+	if _, err := Render("missing", completion, DefaultTemplateOptions); err == nil {
+		t.Fatal("unknown template should fail")
+	}
+}
+
+func TestRegisterTemplate(t *testing.T) {
+	if err := RegisterTemplate("broken", "{{range}}"); err == nil {
+		t.Fatal("invalid template should fail to register")
+	}
+	if err := RegisterTemplate("roles", "{{range .Messages}}{{.Role}};{{end}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Render("roles", &Completion{Messages: []CompletionMessage{{"role": "user", "content": "hi"}}}, DefaultTemplateOptions)
+	if err != nil || got != "user;" {
+		t.Fatalf("got %q, err = %v", got, err)
+	}
+}
+
+func TestTemplateMessages(t *testing.T) {
+	cases := []struct {
+		name string
+		opts TemplateOptions
+		want []TemplateMessage
+	}{
+		{"default", DefaultTemplateOptions, []TemplateMessage{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "what is this?"},
+			{Role: "assistant", Content: `{"arguments":{"q":1},"name":"look"}`},
+			{Role: "tool", Content: "a cat", Name: "look"},
+		}},
+		{"image placeholder", TemplateOptions{System: true, Tools: true, Image: "[image]"}, []TemplateMessage{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "what is this?\n[image]"},
+			{Role: "assistant", Content: `{"arguments":{"q":1},"name":"look"}`},
+			{Role: "tool", Content: "a cat", Name: "look"},
+		}},
+		{"without system and tools", TemplateOptions{}, []TemplateMessage{
+			{Role: "user", Content: "what is this?"},
+		}},
+	}
+
+	for _, tc := range cases {
+		got := TemplateMessages(templateCompletion(), tc.opts)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: got %+v", tc.name, got)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: message %d = %+v, want %+v", tc.name, i, got[i], tc.want[i])
+			}
+		}
+	}
+
+	// 仅有图片且无占位符时跳过该消息
+	completion := &Completion{Messages: []CompletionMessage{{"role": "user", "content": []interface{}{
+		map[string]interface{}{"type": "image_url"},
+	}}}}
+	if got := TemplateMessages(completion, DefaultTemplateOptions); len(got) != 0 {
+		t.Fatalf("image-only message should be skipped: %+v", got)
+	}
+	if got, _ := Render("plain", completion, TemplateOptions{Image: "<img>"}); !strings.Contains(got, "user: <img>") {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 链路追踪的 tracer 名称
+const TracerName = "github.com/bincooo/ago"
+
+var (
+	browsers atomic.Int64
+)
+
+// 浏览器实例计数, 返回释放函数
+func TrackBrowser() (release func()) {
+	browsers.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { browsers.Add(-1) })
+	}
+}
+
+// 运行中的浏览器实例数
+func Browsers() int64 {
+	return browsers.Load()
+}
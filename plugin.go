@@ -1,6 +1,8 @@
 package ago
 
 import (
+	"context"
+	"errors"
 	"path"
 
 	"github.com/bincooo/ago/model"
@@ -30,6 +32,68 @@ func (receiver *plugin) model(mod ...string) *plugin {
 	return receiver
 }
 
+// 上下文窗口, 超出时由核心裁剪历史消息
+func (receiver *plugin) Context(length, output int) *plugin {
+	models := model.JustValue[string, []model.Model](receiver.rec, "model")
+	for i := range models {
+		models[i].ContextLength = length
+		models[i].MaxOutput = output
+	}
+	return receiver
+}
+
+// 适配器名称
+func (receiver *plugin) Name(name string) *plugin {
+	receiver.rec.Put("name", name)
+	return receiver
+}
+
+// 凭证池, 调用前从池中选取凭证挂载到 Ctx.Credential
+func (receiver *plugin) Pool(name string) *plugin {
+	receiver.rec.Put("pool", name)
+	return receiver
+}
+
+// 模拟工具调用, 用于不支持原生函数调用的适配器
+func (receiver *plugin) ToolEmulation() *plugin {
+	receiver.rec.Put("tool-emulation", true)
+	return receiver
+}
+
+// 原生支持 response_format, 不再注入结构化输出提示词
+func (receiver *plugin) StructuredOutput() *plugin {
+	receiver.rec.Put("structured-output", true)
+	return receiver
+}
+
+// 图片输入偏好: url | data-uri, data-uri 时由核心下载远程图片并改写内容段
+func (receiver *plugin) Media(format string) *plugin {
+	receiver.rec.Put("media", format)
+	return receiver
+}
+
+// 健康检查, 如校验 cookie 是否有效、浏览器是否存活; 结果按 health.interval 缓存,
+// 路由时跳过不健康的适配器
+func (receiver *plugin) Health(check func(ctx context.Context) error) *plugin {
+	receiver.rec.Put("health", check)
+	return receiver
+}
+
+// 前置中间件, 调用适配器前按添加顺序执行, 可改写请求;
+// 返回 model.ErrHandled 表示已自行写出响应, 不再调用适配器
+func (receiver *plugin) Before(before ...func(*model.Ctx) error) *plugin {
+	befores := model.JustValue[string, []func(*model.Ctx) error](receiver.rec, "before")
+	receiver.rec.Put("before", append(befores, before...))
+	return receiver
+}
+
+// 后置中间件, 在核心输出处理之后、全局中间件之前处理每条写出的消息
+func (receiver *plugin) After(after ...model.Interceptor) *plugin {
+	afters := model.JustValue[string, []model.Interceptor](receiver.rec, "after")
+	receiver.rec.Put("after", append(afters, after...))
+	return receiver
+}
+
 // 上下文对话
 func (receiver *plugin) Relay(yield func(ctx *model.Ctx) error) *plugin {
 	receiver.rec.Put("relay", yield)
@@ -58,6 +122,36 @@ func (receiver innerAdapter) Model() []model.Model {
 	return model.JustValue[string, []model.Model](receiver.rec, "model")
 }
 
+func (receiver innerAdapter) Name() string {
+	if name, ok := model.GetValue[string, string](receiver.rec, "name"); ok {
+		return name
+	}
+	if models := receiver.Model(); len(models) > 0 {
+		return models[0].Id
+	}
+	return "adapter"
+}
+
+func (receiver innerAdapter) Pool() string {
+	return model.JustValue[string, string](receiver.rec, "pool")
+}
+
+func (receiver innerAdapter) ToolEmulation() bool {
+	return model.JustValue[string, bool](receiver.rec, "tool-emulation")
+}
+
+func (receiver innerAdapter) StructuredOutput() bool {
+	return model.JustValue[string, bool](receiver.rec, "structured-output")
+}
+
+func (receiver innerAdapter) Media() string {
+	return model.JustValue[string, string](receiver.rec, "media")
+}
+
+func (receiver innerAdapter) HealthCheck() func(context.Context) error {
+	return model.JustValue[string, func(context.Context) error](receiver.rec, "health")
+}
+
 func (receiver innerAdapter) Support(ctx *model.Ctx, mod string) bool {
 	models, ok := model.GetValue[string, []model.Model](receiver.rec, "model")
 	if !ok {
@@ -84,27 +178,35 @@ func (receiver innerAdapter) Support(ctx *model.Ctx, mod string) bool {
 
 // 上下文对话
 func (receiver innerAdapter) Relay(ctx *model.Ctx) (err error) {
-	relay, ok := model.GetValue[string, func(*model.Ctx) error](receiver.rec, "relay")
-	if !ok {
-		return
-	}
-	return relay(ctx)
+	return receiver.invoke(ctx, "relay")
 }
 
 // 向量查询
 func (receiver innerAdapter) Embed(ctx *model.Ctx) (err error) {
-	embed, ok := model.GetValue[string, func(*model.Ctx) error](receiver.rec, "embed")
-	if !ok {
-		return
-	}
-	return embed(ctx)
+	return receiver.invoke(ctx, "embed")
 }
 
 // 文生图
 func (receiver innerAdapter) Image(ctx *model.Ctx) (err error) {
-	image, ok := model.GetValue[string, func(*model.Ctx) error](receiver.rec, "image")
+	return receiver.invoke(ctx, "image")
+}
+
+// 执行中间件后调用对应的处理函数
+func (receiver innerAdapter) invoke(ctx *model.Ctx, key string) (err error) {
+	yield, ok := model.GetValue[string, func(*model.Ctx) error](receiver.rec, key)
 	if !ok {
 		return
 	}
-	return image(ctx)
+
+	for _, before := range model.JustValue[string, []func(*model.Ctx) error](receiver.rec, "before") {
+		if err = before(ctx); err != nil {
+			if errors.Is(err, model.ErrHandled) {
+				return nil
+			}
+			return
+		}
+	}
+
+	ctx.InterceptAt(model.StageAdapter, model.JustValue[string, []model.Interceptor](receiver.rec, "after")...)
+	return yield(ctx)
 }
@@ -3,14 +3,11 @@ package ago
 import (
 	"context"
 	"net/http"
-	"time"
 
 	"github.com/bincooo/ago/internal"
 	"github.com/bincooo/ago/internal/chromium"
 	"github.com/bincooo/ago/internal/v1"
 	"github.com/bincooo/ago/model"
-	"github.com/bincooo/ja3"
-	xtls "github.com/refraction-networking/utls"
 )
 
 type interfaces struct {
@@ -20,9 +17,15 @@ type interfaces struct {
 func Sdk() interface {
 	Plugin(...string) *plugin
 	RegisterAdapter(adapter model.Adapter)
+	ReplaceAdapter(id string, adapter model.Adapter) bool
+	RemoveAdapter(id string) bool
 
 	Transport(proxies string) http.RoundTripper
 	Env() *v1.Environ
+	LimitStore(store v1.LimitStore)
+	Credentials(pool string, values ...string)
+	Before(before ...func(*model.Ctx) error)
+	After(after ...model.Interceptor)
 
 	Chrome(ctx context.Context, proxies, userAgent, userDir string, plugins ...string) (context.Context, context.CancelFunc)
 
@@ -40,14 +43,16 @@ func (interfaces) RegisterAdapter(ada model.Adapter) {
 	v1.AddAdapter(ada)
 }
 
+func (interfaces) ReplaceAdapter(id string, adapter model.Adapter) bool {
+	return v1.ReplaceAdapter(id, adapter)
+}
+
+func (interfaces) RemoveAdapter(id string) bool {
+	return v1.RemoveAdapter(id)
+}
+
 func (interfaces) Transport(proxies string) http.RoundTripper {
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.IdleConnTimeout = 120 * time.Second
-	return ja3.NewTransport(
-		ja3.WithProxy(proxies),
-		ja3.WithClientHelloID(xtls.HelloChrome_133),
-		ja3.WithOriginalTransport(transport),
-	)
+	return v1.Transport(proxies)
 }
 
 func (interfaces) Chrome(ctx context.Context, proxies, userAgent, userDir string, plugins ...string) (context.Context, context.CancelFunc) {
@@ -58,6 +63,22 @@ func (interfaces) Env() *v1.Environ {
 	return v1.Env
 }
 
+func (interfaces) LimitStore(store v1.LimitStore) {
+	v1.SetLimitStore(store)
+}
+
+func (interfaces) Credentials(pool string, values ...string) {
+	v1.AddCredentials(pool, values...)
+}
+
+func (interfaces) Before(before ...func(*model.Ctx) error) {
+	v1.AddBefore(before...)
+}
+
+func (interfaces) After(after ...model.Interceptor) {
+	v1.AddAfter(after...)
+}
+
 func (interfaces) OnInitialized(f func()) {
 	internal.AddInitialized(f)
 }
@@ -0,0 +1,77 @@
+package tokenizer
+
+import (
+	"encoding/json"
+
+	"github.com/bincooo/ago/model"
+)
+
+const (
+	// 每条消息的固定开销与回复的起始开销
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	tokensPerReply   = 3
+
+	// 图片开销: low 细节固定值与默认值
+	imageLowTokens = 85
+	imageTokens    = 765
+)
+
+// 请求的提示词 token 数, 包含系统提示、工具定义与图片
+func Prompt(completion *model.Completion) (tokens int) {
+	mod := completion.Model
+	if completion.System != "" {
+		tokens += tokensPerMessage + Count(mod, completion.System)
+	}
+
+	for _, message := range completion.Messages {
+		tokens += Message(mod, message)
+	}
+
+	if len(completion.Tools) > 0 {
+		chunk, _ := json.Marshal(completion.Tools)
+		tokens += Count(mod, string(chunk))
+	}
+	return tokens + tokensPerReply
+}
+
+// 单条消息的 token 数, 含每条消息的固定开销, 不含回复的起始开销
+func Message(mod string, message model.CompletionMessage) (tokens int) {
+	tokens = tokensPerMessage + Count(mod, model.RoleOf(message))
+	if name, _ := message["name"].(string); name != "" {
+		tokens += tokensPerName + Count(mod, name)
+	}
+
+	for _, part := range model.PartsOf(message) {
+		switch part["type"] {
+		case "text":
+			text, _ := part["text"].(string)
+			tokens += Count(mod, text)
+		case "image_url", "image":
+			tokens += imageCost(part)
+		}
+	}
+
+	for _, call := range model.ToolCallsOf(message) {
+		name, _ := model.FunctionOf(call)["name"].(string)
+		tokens += Count(mod, name) + Count(mod, model.ArgumentsOf(call))
+	}
+	return
+}
+
+// 输出的 token 数
+func Output(mod string, content, reasoning string, calls []model.ChoiceToolCall) (tokens int) {
+	tokens = Count(mod, content) + Count(mod, reasoning)
+	for _, call := range calls {
+		name, _ := model.FunctionOf(call)["name"].(string)
+		tokens += Count(mod, name) + Count(mod, model.ArgumentsOf(call))
+	}
+	return
+}
+
+func imageCost(part model.Record[string, any]) int {
+	if image, ok := part["image_url"].(map[string]interface{}); ok && image["detail"] == "low" {
+		return imageLowTokens
+	}
+	return imageTokens
+}
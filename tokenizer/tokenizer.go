@@ -0,0 +1,108 @@
+package tokenizer
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bincooo/ago/logger"
+	"github.com/pkoukk/tiktoken-go"
+	loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+var (
+	encoders = make(map[string]*tiktoken.Tiktoken)
+	mu       sync.Mutex
+
+	heuristic bool
+)
+
+func init() {
+	// 使用内嵌的词表, 不访问网络
+	tiktoken.SetBpeLoader(loader.NewOfflineLoader())
+}
+
+// 仅使用启发式估算, 不加载 BPE 词表
+func UseHeuristic(enabled bool) {
+	heuristic = enabled
+}
+
+// 文本的 token 数, 未知模型按 cl100k_base 计算, 词表不可用时使用启发式估算
+func Count(mod, text string) int {
+	if text == "" {
+		return 0
+	}
+
+	if !heuristic {
+		if enc := encoder(encodingOf(mod)); enc != nil {
+			return len(enc.EncodeOrdinary(text))
+		}
+	}
+	return Heuristic(text)
+}
+
+// 启发式估算: CJK 字符按 1 个计, 其余按 4 个字符 1 个计
+func Heuristic(text string) int {
+	var counter Counter
+	for _, r := range text {
+		counter.Add(r)
+	}
+	return counter.Tokens()
+}
+
+// 逐字符累计的启发式计数器
+type Counter struct {
+	cjk    int
+	others int
+}
+
+func (counter *Counter) Add(r rune) {
+	if r >= utf8.RuneSelf && (unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)) {
+		counter.cjk++
+		return
+	}
+	counter.others++
+}
+
+func (counter *Counter) Tokens() int {
+	return counter.cjk + (counter.others+3)/4
+}
+
+// 模型对应的编码
+func encodingOf(mod string) string {
+	mod = strings.ToLower(mod)
+	if name, ok := tiktoken.MODEL_TO_ENCODING[mod]; ok {
+		return name
+	}
+
+	for _, prefix := range []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "chatgpt-4o", "o1", "o3", "o4"} {
+		if strings.HasPrefix(mod, prefix) {
+			return tiktoken.MODEL_O200K_BASE
+		}
+	}
+
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(mod, prefix) {
+			return name
+		}
+	}
+	return tiktoken.MODEL_CL100K_BASE
+}
+
+// 加载失败时返回 nil, 且不再重试
+func encoder(name string) *tiktoken.Tiktoken {
+	mu.Lock()
+	defer mu.Unlock()
+	if enc, ok := encoders[name]; ok {
+		return enc
+	}
+
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		logger.Sugar().Errorf("tokenizer: load [%s] failed, fallback to heuristic: %v", name, err)
+	}
+	encoders[name] = enc
+	return enc
+}
@@ -0,0 +1,126 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/bincooo/ago/model"
+	"github.com/pkoukk/tiktoken-go"
+)
+
+func TestEncodingOf(t *testing.T) {
+	cases := []struct {
+		mod      string
+		encoding string
+	}{
+		{"gpt-4", tiktoken.MODEL_CL100K_BASE},
+		{"gpt-3.5-turbo-0125", tiktoken.MODEL_CL100K_BASE},
+		{"gpt-4o-mini", tiktoken.MODEL_O200K_BASE},
+		{"GPT-4.1", tiktoken.MODEL_O200K_BASE},
+		{"o3-mini", tiktoken.MODEL_O200K_BASE},
+		{"claude-3-5-sonnet", tiktoken.MODEL_CL100K_BASE},
+		{"", tiktoken.MODEL_CL100K_BASE},
+	}
+
+	for _, tc := range cases {
+		if got := encodingOf(tc.mod); got != tc.encoding {
+			t.Errorf("encodingOf(%q) = %s, want %s", tc.mod, got, tc.encoding)
+		}
+	}
+}
+
+func TestHeuristic(t *testing.T) {
+	cases := []struct {
+		text   string
+		tokens int
+	}{
+		{"", 0},
+		{"a", 1},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"你好", 2},
+		{"こんにちは", 5},
+		{"你好 abcd", 4},
+	}
+
+	for _, tc := range cases {
+		if got := Heuristic(tc.text); got != tc.tokens {
+			t.Errorf("Heuristic(%q) = %d, want %d", tc.text, got, tc.tokens)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	cases := []struct {
+		mod       string
+		text      string
+		heuristic bool
+		tokens    int
+	}{
+		{"gpt-4", "", false, 0},
+		{"gpt-4", "hello world", false, 2},
+		{"gpt-4o", "hello world", false, 2},
+		{"unknown", "hello world", false, 2},
+		{"gpt-4", "hello world", true, 3},
+	}
+
+	for _, tc := range cases {
+		UseHeuristic(tc.heuristic)
+		if got := Count(tc.mod, tc.text); got != tc.tokens {
+			t.Errorf("Count(%q, %q, heuristic=%v) = %d, want %d", tc.mod, tc.text, tc.heuristic, got, tc.tokens)
+		}
+	}
+	UseHeuristic(false)
+}
+
+func TestMessage(t *testing.T) {
+	image := func(detail string) model.CompletionMessage {
+		return model.CompletionMessage{"role": "user", "content": []interface{}{
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:,", "detail": detail}},
+		}}
+	}
+
+	cases := []struct {
+		name    string
+		message model.CompletionMessage
+		tokens  int
+	}{
+		// 固定开销 3 + 角色 1 + 内容
+		{"text", model.CompletionMessage{"role": "user", "content": "hello world"}, 6},
+		{"name", model.CompletionMessage{"role": "user", "content": "hello world", "name": "bob"}, 8},
+		{"image", image("auto"), 3 + 1 + imageTokens},
+		{"low detail image", image("low"), 3 + 1 + imageLowTokens},
+		{"tool call", model.CompletionMessage{"role": "assistant", "tool_calls": []interface{}{
+			map[string]interface{}{"function": map[string]interface{}{"name": "look", "arguments": "{}"}},
+		}}, 3 + 1 + Count("gpt-4", "look") + Count("gpt-4", "{}")},
+	}
+
+	for _, tc := range cases {
+		if got := Message("gpt-4", tc.message); got != tc.tokens {
+			t.Errorf("%s: Message = %d, want %d", tc.name, got, tc.tokens)
+		}
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	message := model.CompletionMessage{"role": "user", "content": "hello world"}
+	completion := &model.Completion{Model: "gpt-4", Messages: []model.CompletionMessage{message}}
+	if got := Prompt(completion); got != Message("gpt-4", message)+tokensPerReply {
+		t.Fatalf("Prompt = %d", got)
+	}
+
+	// 系统提示按一条消息计算, 工具定义按 JSON 计算
+	completion.System = "hello world"
+	completion.Tools = []model.CompletionTool{{"type": "function"}}
+	want := Message("gpt-4", message) + tokensPerReply + tokensPerMessage + 2 + Count("gpt-4", `[{"type":"function"}]`)
+	if got := Prompt(completion); got != want {
+		t.Fatalf("Prompt = %d, want %d", got, want)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	calls := []model.ChoiceToolCall{{"function": map[string]interface{}{"name": "look", "arguments": map[string]interface{}{"q": 1}}}}
+	want := Count("gpt-4", "hello world") + Count("gpt-4", "think") + Count("gpt-4", "look") + Count("gpt-4", `{"q":1}`)
+	if got := Output("gpt-4", "hello world", "think", calls); got != want {
+		t.Fatalf("Output = %d, want %d", got, want)
+	}
+}